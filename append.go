@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Append mode: extend an existing TBBO .quantdev file with new chunks instead
+// of rewriting the archive.
+//
+// The chunk index, zone maps, column directory, source block and metadata
+// of the file are read back into a gncFile, new chunks are written from the
+// end of the last chunk (over the old footer) and Close writes the extended
+// footer and rewrites the header. Abort, or a failed Close, puts the old
+// footer and header back. The file is modified in place, so unlike a fresh
+// encoder a crash mid-append leaves it unreadable; keep a copy of archives
+// that cannot be rebuilt.
+//
+// Appended rows must be in ts_event order, starting no earlier than the
+// file's last ts_event, so the file stays sorted and merge can stream it.
+// DBN files are ordered by ts_recv and ts_event can step back; sort such
+// rows before appending them.
+// -----------------------------------------------------------------------------
+
+// appendState is what an append needs to restore the file it extends.
+type appendState struct {
+	end    int64  // end of the last original chunk (the old footer position)
+	tail   []byte // original bytes from end to EOF
+	header []byte // original 64-byte header
+}
+
+// OpenEncoderAppend opens the TBBO .quantdev file path for appending rows
+// with AddRow. Prices keep the file's encoding (float64 or fixed-point) and
+// columns keep their encodings; SetCompact has no effect.
+func OpenEncoderAppend(path string) (*Encoder, error) {
+	x, err := ReadQuantDevIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	hdr := x.Header
+	if err := hdr.expectLayout(LayoutTBBO); err != nil {
+		return nil, err
+	}
+	if hdr.Version < FormatChecksums || hdr.ZonePos == 0 {
+		return nil, fmt.Errorf("format %d file has no zone maps or checksums to extend; re-run data conversion", hdr.Version)
+	}
+	source, err := ReadQuantDevSource(path)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := ReadQuantDevMeta(path)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	orig := &appendState{
+		end:    int64(hdr.FooterPos),
+		tail:   make([]byte, st.Size()-int64(hdr.FooterPos)),
+		header: make([]byte, 64),
+	}
+	if _, err := f.ReadAt(orig.header, 0); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.ReadAt(orig.tail, orig.end); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if _, err := f.Seek(orig.end, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	g := gncFile{
+		path:      path,
+		layout:    hdr.Layout,
+		rtype:     hdr.RType,
+		totalRows: uint64(hdr.Rows),
+		extents:   x.Columns.Extents,
+		outFile:   f,
+		columns:   x.Columns.Columns,
+		source:    source,
+		orig:      orig,
+	}
+	if hdr.PriceEnc == PriceFixedInt64 {
+		g.pxScale = hdr.PriceScale
+	}
+	for _, c := range g.columns {
+		g.compact = g.compact || c.Encoding != EncodingPlain
+	}
+
+	var last uint64
+	for _, c := range x.Chunks {
+		g.chunkOffsets = append(g.chunkOffsets, c.Offset)
+		g.zones = append(g.zones, chunkZone{
+			rows:        uint32(c.Rows),
+			tsMin:       c.TsMin,
+			tsMax:       c.TsMax,
+			pxMin:       c.PxMin,
+			pxMax:       c.PxMax,
+			instruments: c.Instruments,
+		})
+		if c.Rows > 0 {
+			last = max(last, c.TsMax)
+		}
+	}
+
+	// Keys fileMeta derives are recomputed over old and new chunks; the rest
+	// (source, asset, created ...) carry over.
+	for k, v := range meta {
+		switch k {
+		case MetaLayout, MetaRows, MetaTsFirst, MetaTsLast, MetaSymbols, MetaEncoder, MetaBuild:
+		default:
+			g.SetMeta(k, v)
+		}
+	}
+	g.SetMeta(MetaAppended, time.Now().UTC().Format(time.RFC3339))
+
+	e := newEncoder(g)
+	if g.pxScale != 0 {
+		e.pxRaw = make([]int64, 0, ChunkSize)
+		e.bpRaw = make([]int64, 0, ChunkSize)
+		e.apRaw = make([]int64, 0, ChunkSize)
+	}
+	e.tsFloor = last
+	return e, nil
+}
+
+// restore puts the original footer and header back.
+func (a *appendState) restore(f *os.File) error {
+	if err := f.Truncate(a.end); err != nil {
+		return err
+	}
+	if _, err := f.WriteAt(a.tail, a.end); err != nil {
+		return err
+	}
+	_, err := f.WriteAt(a.header, 0)
+	return err
+}
@@ -0,0 +1,404 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+// -----------------------------------------------------------------------------
+// Apache Arrow IPC writer (stream and file format, metadata version V5).
+//
+// A stream is the schema message, one record batch message per call to
+// WriteBatch and an end-of-stream marker. Each message is
+//
+//	[0xFFFFFFFF][i32 metadata length][Message flatbuffer, padded to 8][body]
+//
+// The file format wraps the same messages in "ARROW1" magic and ends with a
+// Footer flatbuffer listing where the batches are, so readers can seek.
+//
+// Columns are written without a validity bitmap (null_count 0) straight
+// from the TBBOColumns slices. Flatbuffers are built by fbTable below, front
+// to back: every table is written before the objects it points to, which
+// keeps all uoffsets forward as the format requires.
+// -----------------------------------------------------------------------------
+
+const (
+	arrowMagic        = "ARROW1"
+	arrowMetadataV5   = 4
+	arrowHeaderSchema = 1
+	arrowHeaderBatch  = 3
+
+	// Type union members (Schema.fbs).
+	arrowTypeInt       = 2
+	arrowTypeFloat     = 3
+	arrowTypeTimestamp = 10
+
+	arrowPrecisionDouble = 2
+	arrowUnitNanosecond  = 3
+)
+
+// ArrowWriter writes TBBO batches as Arrow IPC.
+type ArrowWriter struct {
+	w      *countingWriter
+	cols   []exportColumn
+	file   bool
+	schema *fbTable
+
+	blocks []byte // Footer Block structs, file format only
+	pad    [8]byte
+}
+
+// NewArrowWriter writes the schema of cols to w. file selects the random
+// access file format instead of the stream format. meta becomes the schema's
+// custom metadata.
+func NewArrowWriter(w io.Writer, cols []exportColumn, file bool, meta FileMeta) (*ArrowWriter, error) {
+	a := &ArrowWriter{w: &countingWriter{w: w}, cols: cols, file: file}
+	a.schema = arrowSchema(cols, meta)
+	if file {
+		if _, err := a.w.Write([]byte(arrowMagic + "\x00\x00")); err != nil {
+			return nil, err
+		}
+	}
+	msg := arrowMessage(arrowHeaderSchema, a.schema, 0)
+	if _, _, err := a.writeMessage(msg, nil); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// WriteBatch writes the rows of c as one record batch.
+func (a *ArrowWriter) WriteBatch(c *TBBOColumns) error {
+	if c.Count == 0 {
+		return nil
+	}
+	nodes := make([]byte, 0, 16*len(a.cols))
+	buffers := make([]byte, 0, 32*len(a.cols))
+	body := make([][]byte, len(a.cols))
+	var off int64
+	for i, col := range a.cols {
+		b := c.column(col.set)
+		body[i] = b
+		nodes = binary.LittleEndian.AppendUint64(nodes, uint64(c.Count))
+		nodes = binary.LittleEndian.AppendUint64(nodes, 0)
+		// Validity bitmap (absent), then the values.
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(off))
+		buffers = binary.LittleEndian.AppendUint64(buffers, 0)
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(off))
+		buffers = binary.LittleEndian.AppendUint64(buffers, uint64(len(b)))
+		off += int64(pad8(len(b)))
+	}
+
+	batch := &fbTable{}
+	batch.int64(0, c.Count)
+	batch.ref(1, fbStructs{size: 16, data: nodes})
+	batch.ref(2, fbStructs{size: 16, data: buffers})
+
+	start := a.w.n
+	metaLen, bodyLen, err := a.writeMessage(arrowMessage(arrowHeaderBatch, batch, off), body)
+	if err != nil {
+		return err
+	}
+	if a.file {
+		a.blocks = binary.LittleEndian.AppendUint64(a.blocks, uint64(start))
+		a.blocks = binary.LittleEndian.AppendUint32(a.blocks, uint32(metaLen))
+		a.blocks = binary.LittleEndian.AppendUint32(a.blocks, 0)
+		a.blocks = binary.LittleEndian.AppendUint64(a.blocks, uint64(bodyLen))
+	}
+	return nil
+}
+
+// Close writes the end-of-stream marker and, in the file format, the
+// footer. It does not close the underlying writer.
+func (a *ArrowWriter) Close() error {
+	if _, err := a.w.Write([]byte{0xFF, 0xFF, 0xFF, 0xFF, 0, 0, 0, 0}); err != nil {
+		return err
+	}
+	if !a.file {
+		return nil
+	}
+	footer := &fbTable{}
+	footer.int16(0, arrowMetadataV5)
+	footer.ref(1, a.schema)
+	footer.ref(2, fbStructs{size: 24})
+	footer.ref(3, fbStructs{size: 24, data: a.blocks})
+	fb := finishFlatbuffer(footer)
+	if _, err := a.w.Write(fb); err != nil {
+		return err
+	}
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(fb)))
+	if _, err := a.w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := a.w.Write([]byte(arrowMagic))
+	return err
+}
+
+// writeMessage writes one encapsulated message and returns the length of
+// its metadata (prefix included) and of its body.
+func (a *ArrowWriter) writeMessage(msg *fbTable, body [][]byte) (int, int, error) {
+	fb := finishFlatbuffer(msg)
+	var prefix [8]byte
+	binary.LittleEndian.PutUint32(prefix[0:4], 0xFFFFFFFF)
+	binary.LittleEndian.PutUint32(prefix[4:8], uint32(len(fb)))
+	if _, err := a.w.Write(prefix[:]); err != nil {
+		return 0, 0, err
+	}
+	if _, err := a.w.Write(fb); err != nil {
+		return 0, 0, err
+	}
+	bodyLen := 0
+	for _, b := range body {
+		if _, err := a.w.Write(b); err != nil {
+			return 0, 0, err
+		}
+		p := pad8(len(b)) - len(b)
+		if _, err := a.w.Write(a.pad[:p]); err != nil {
+			return 0, 0, err
+		}
+		bodyLen += len(b) + p
+	}
+	return len(prefix) + len(fb), bodyLen, nil
+}
+
+func arrowMessage(headerType uint8, header *fbTable, bodyLen int64) *fbTable {
+	m := &fbTable{}
+	m.int16(0, arrowMetadataV5)
+	m.uint8(1, headerType)
+	m.ref(2, header)
+	m.int64(3, int(bodyLen))
+	return m
+}
+
+func arrowSchema(cols []exportColumn, meta FileMeta) *fbTable {
+	fields := make(fbTables, len(cols))
+	for i, col := range cols {
+		f := &fbTable{}
+		f.ref(0, fbString(col.name))
+		f.bool(1, false)
+		typ := &fbTable{}
+		switch {
+		case col.time:
+			f.uint8(2, arrowTypeTimestamp)
+			typ.int16(0, arrowUnitNanosecond)
+			typ.ref(1, fbString("UTC"))
+		case col.dtype == DTypeF64:
+			f.uint8(2, arrowTypeFloat)
+			typ.int16(0, arrowPrecisionDouble)
+		default:
+			f.uint8(2, arrowTypeInt)
+			typ.int32(0, int32(col.dtype.Size()*8))
+			typ.bool(1, col.dtype.signed())
+		}
+		f.ref(3, typ)
+		f.ref(5, fbTables{})
+		fields[i] = f
+	}
+
+	s := &fbTable{}
+	s.int16(0, 0) // little endian
+	s.ref(1, fields)
+	if len(meta) > 0 {
+		keys := make([]string, 0, len(meta))
+		for k := range meta {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		kvs := make(fbTables, len(keys))
+		for i, k := range keys {
+			kv := &fbTable{}
+			kv.ref(0, fbString(k))
+			kv.ref(1, fbString(meta[k]))
+			kvs[i] = kv
+		}
+		s.ref(2, kvs)
+	}
+	return s
+}
+
+// signed reports whether t is a signed integer type.
+func (t DType) signed() bool {
+	switch t {
+	case DTypeI8, DTypeI32, DTypeI64:
+		return true
+	}
+	return false
+}
+
+func pad8(n int) int {
+	return (n + 7) &^ 7
+}
+
+// countingWriter tracks the offset written so far.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// =============================================================================
+//  Flatbuffers
+// =============================================================================
+
+// fbObj is anything a flatbuffer field can point to.
+type fbObj interface {
+	write(b *fbBuilder) int // returns the object's position
+}
+
+// fbTable is a table under construction. Fields are scalars or references
+// to other objects, addressed by their slot in the schema.
+type fbTable struct {
+	fields []fbField
+}
+
+type fbField struct {
+	slot int
+	size int    // inline bytes: 1, 2, 4 or 8; references are 4
+	val  uint64 // scalar value
+	ref  fbObj  // reference, written after the table
+}
+
+func (t *fbTable) scalar(slot, size int, v uint64) {
+	t.fields = append(t.fields, fbField{slot: slot, size: size, val: v})
+}
+
+func (t *fbTable) bool(slot int, v bool) {
+	var b uint64
+	if v {
+		b = 1
+	}
+	t.scalar(slot, 1, b)
+}
+
+func (t *fbTable) uint8(slot int, v uint8) { t.scalar(slot, 1, uint64(v)) }
+func (t *fbTable) int16(slot int, v int16) { t.scalar(slot, 2, uint64(uint16(v))) }
+func (t *fbTable) int32(slot int, v int32) { t.scalar(slot, 4, uint64(uint32(v))) }
+func (t *fbTable) int64(slot int, v int)   { t.scalar(slot, 8, uint64(v)) }
+func (t *fbTable) ref(slot int, obj fbObj) {
+	t.fields = append(t.fields, fbField{slot: slot, size: 4, ref: obj})
+}
+
+// fbString is a string object.
+type fbString string
+
+// fbTables is a vector of tables.
+type fbTables []*fbTable
+
+// fbStructs is a vector of structs of size bytes each, already serialized.
+// Structs here are 8-byte aligned.
+type fbStructs struct {
+	size int
+	data []byte
+}
+
+// fbBuilder is the buffer objects are appended to.
+type fbBuilder struct {
+	b []byte
+}
+
+func (b *fbBuilder) align(n int) {
+	for len(b.b)%n != 0 {
+		b.b = append(b.b, 0)
+	}
+}
+
+// patch stores at pos the uoffset from pos to target.
+func (b *fbBuilder) patch(pos, target int) {
+	binary.LittleEndian.PutUint32(b.b[pos:], uint32(target-pos))
+}
+
+// finishFlatbuffer serializes root with its root offset first and pads the
+// result to 8 bytes.
+func finishFlatbuffer(root *fbTable) []byte {
+	b := &fbBuilder{b: make([]byte, 4, 256)}
+	b.patch(0, root.write(b))
+	b.align(8)
+	return b.b
+}
+
+func (t *fbTable) write(b *fbBuilder) int {
+	// Inline layout: the vtable soffset, then fields largest first so each
+	// is naturally aligned once the table is 8-byte aligned.
+	fields := slices.Clone(t.fields)
+	slices.SortStableFunc(fields, func(x, y fbField) int { return y.size - x.size })
+	pos := make([]int, len(fields))
+	nslots := 0
+	size := 4
+	for i, f := range fields {
+		size = (size + f.size - 1) &^ (f.size - 1)
+		pos[i] = size
+		size += f.size
+		nslots = max(nslots, f.slot+1)
+	}
+
+	b.align(2)
+	vt := len(b.b)
+	vtable := make([]byte, 4+2*nslots)
+	binary.LittleEndian.PutUint16(vtable[0:], uint16(len(vtable)))
+	binary.LittleEndian.PutUint16(vtable[2:], uint16(size))
+	for i, f := range fields {
+		binary.LittleEndian.PutUint16(vtable[4+2*f.slot:], uint16(pos[i]))
+	}
+	b.b = append(b.b, vtable...)
+
+	b.align(8)
+	tp := len(b.b)
+	b.b = append(b.b, make([]byte, size)...)
+	binary.LittleEndian.PutUint32(b.b[tp:], uint32(int32(tp-vt)))
+	for i, f := range fields {
+		p := b.b[tp+pos[i]:]
+		switch f.size {
+		case 1:
+			p[0] = uint8(f.val)
+		case 2:
+			binary.LittleEndian.PutUint16(p, uint16(f.val))
+		case 4:
+			binary.LittleEndian.PutUint32(p, uint32(f.val))
+		case 8:
+			binary.LittleEndian.PutUint64(p, f.val)
+		}
+	}
+	for i, f := range fields {
+		if f.ref != nil {
+			b.patch(tp+pos[i], f.ref.write(b))
+		}
+	}
+	return tp
+}
+
+func (s fbString) write(b *fbBuilder) int {
+	b.align(4)
+	p := len(b.b)
+	b.b = binary.LittleEndian.AppendUint32(b.b, uint32(len(s)))
+	b.b = append(b.b, s...)
+	b.b = append(b.b, 0)
+	return p
+}
+
+func (v fbTables) write(b *fbBuilder) int {
+	b.align(4)
+	p := len(b.b)
+	b.b = binary.LittleEndian.AppendUint32(b.b, uint32(len(v)))
+	b.b = append(b.b, make([]byte, 4*len(v))...)
+	for i, t := range v {
+		b.patch(p+4+4*i, t.write(b))
+	}
+	return p
+}
+
+func (v fbStructs) write(b *fbBuilder) int {
+	// The length goes right before the 8-byte aligned elements.
+	for len(b.b)%8 != 4 {
+		b.b = append(b.b, 0)
+	}
+	p := len(b.b)
+	b.b = binary.LittleEndian.AppendUint32(b.b, uint32(len(v.data)/v.size))
+	b.b = append(b.b, v.data...)
+	return p
+}
@@ -0,0 +1,387 @@
+package main
+
+import (
+	"encoding/binary"
+	"sort"
+)
+
+// -----------------------------------------------------------------------------
+// MBO (market-by-order, rtype 0xA0) and limit order book reconstruction.
+//
+// MBO record body after the 16-byte header:
+//
+//	[16:24] order_id (u64)
+//	[24:32] price (i64 fixed-9)
+//	[32:36] size (u32)
+//	[36]    flags (u8)
+//	[37]    channel_id (u8)
+//	[38]    action (char: 'A','C','M','R','T','F','N')
+//	[39]    side (char: 'B','A','N')
+//	[40:48] ts_recv (u64)
+//	[48:52] ts_in_delta (i32)
+//	[52:56] sequence (u32)
+//
+// Book semantics follow Databento's reference book: trades and fills do not
+// touch resting orders (the venue follows them with the matching cancels and
+// modifies), and a modify that moves the price or raises the size loses queue
+// priority.
+// -----------------------------------------------------------------------------
+
+const (
+	RTypeMBO   = 0xA0
+	RecSizeMBO = 56
+)
+
+// MBOMsg is one decoded MBO record. Action and Side keep the raw DBN chars.
+type MBOMsg struct {
+	PubID     uint16
+	InstrID   uint32
+	TsEvent   uint64
+	TsRecv    uint64
+	TsInDelta int32
+	OrderID   uint64
+	PxRaw     int64
+	Size      uint32
+	Flags     uint8
+	Channel   uint8
+	Action    byte
+	Side      byte
+	Seq       uint32
+}
+
+func decodeMBOMsg(rec []byte) MBOMsg {
+	_ = rec[55]
+	return MBOMsg{
+		PubID:     binary.LittleEndian.Uint16(rec[2:4]),
+		InstrID:   binary.LittleEndian.Uint32(rec[4:8]),
+		TsEvent:   binary.LittleEndian.Uint64(rec[8:16]),
+		OrderID:   binary.LittleEndian.Uint64(rec[16:24]),
+		PxRaw:     int64(binary.LittleEndian.Uint64(rec[24:32])),
+		Size:      binary.LittleEndian.Uint32(rec[32:36]),
+		Flags:     rec[36],
+		Channel:   rec[37],
+		Action:    rec[38],
+		Side:      rec[39],
+		TsRecv:    binary.LittleEndian.Uint64(rec[40:48]),
+		TsInDelta: int32(binary.LittleEndian.Uint32(rec[48:52])),
+		Seq:       binary.LittleEndian.Uint32(rec[52:56]),
+	}
+}
+
+// TradeEvent renders the message in the event shape shared with TBBO/MBP rows.
+func (m *MBOMsg) TradeEvent() TradeEvent {
+	var s int8
+	switch m.Side {
+	case 'B':
+		s = 1
+	case 'A':
+		s = -1
+	}
+	return TradeEvent{
+		PubID:     m.PubID,
+		InstrID:   m.InstrID,
+		TsEvent:   m.TsEvent,
+		TsRecv:    m.TsRecv,
+		TsInDelta: m.TsInDelta,
+		PxRaw:     m.PxRaw,
+		Size:      m.Size,
+		Side:      s,
+		Action:    int8(m.Action),
+		Flags:     m.Flags,
+		Seq:       m.Seq,
+	}
+}
+
+// =============================================================================
+//  Order book
+// =============================================================================
+
+type bookOrder struct {
+	id   uint64
+	size uint32
+}
+
+// priceLevel holds the resting orders at one price in time priority.
+type priceLevel struct {
+	px     int64
+	size   uint64
+	orders []bookOrder
+}
+
+func (l *priceLevel) indexOf(id uint64) int {
+	for i := range l.orders {
+		if l.orders[i].id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// bookSide keeps its levels sorted best first: bids descending, asks ascending.
+type bookSide struct {
+	bid    bool
+	levels []*priceLevel
+}
+
+func (s *bookSide) search(px int64) int {
+	if s.bid {
+		return sort.Search(len(s.levels), func(i int) bool { return s.levels[i].px <= px })
+	}
+	return sort.Search(len(s.levels), func(i int) bool { return s.levels[i].px >= px })
+}
+
+func (s *bookSide) find(px int64) (int, bool) {
+	i := s.search(px)
+	return i, i < len(s.levels) && s.levels[i].px == px
+}
+
+func (s *bookSide) getOrInsert(px int64) *priceLevel {
+	i, ok := s.find(px)
+	if ok {
+		return s.levels[i]
+	}
+	lv := &priceLevel{px: px}
+	s.levels = append(s.levels, nil)
+	copy(s.levels[i+1:], s.levels[i:])
+	s.levels[i] = lv
+	return lv
+}
+
+func (s *bookSide) removeAt(i int) {
+	copy(s.levels[i:], s.levels[i+1:])
+	s.levels[len(s.levels)-1] = nil
+	s.levels = s.levels[:len(s.levels)-1]
+}
+
+type orderRef struct {
+	bid bool
+	px  int64
+}
+
+// OrderBook is the full-depth book of one instrument on one publisher.
+type OrderBook struct {
+	bids   bookSide
+	asks   bookSide
+	orders map[uint64]orderRef
+
+	// Unknown counts cancels/modifies for orders that are not on the book,
+	// typically because the file starts mid-session without a snapshot.
+	Unknown int
+}
+
+func NewOrderBook() *OrderBook {
+	return &OrderBook{
+		bids:   bookSide{bid: true},
+		asks:   bookSide{bid: false},
+		orders: make(map[uint64]orderRef),
+	}
+}
+
+func (b *OrderBook) sideOf(bid bool) *bookSide {
+	if bid {
+		return &b.bids
+	}
+	return &b.asks
+}
+
+// Apply updates the book with one MBO message. Trade, fill and none actions
+// are informational and leave the book unchanged.
+func (b *OrderBook) Apply(m *MBOMsg) {
+	switch m.Action {
+	case 'A':
+		if m.Side == 'B' || m.Side == 'A' {
+			b.add(m.Side == 'B', m.OrderID, m.PxRaw, m.Size)
+		}
+	case 'C':
+		b.cancel(m.OrderID, m.Size)
+	case 'M':
+		b.modify(m)
+	case 'R':
+		b.Clear()
+	}
+}
+
+// Clear empties both sides, as on a book reset or before a snapshot.
+func (b *OrderBook) Clear() {
+	clear(b.bids.levels)
+	clear(b.asks.levels)
+	b.bids.levels = b.bids.levels[:0]
+	b.asks.levels = b.asks.levels[:0]
+	clear(b.orders)
+}
+
+func (b *OrderBook) add(bid bool, id uint64, px int64, size uint32) {
+	if _, dup := b.orders[id]; dup {
+		b.remove(id, ^uint32(0))
+	}
+	lv := b.sideOf(bid).getOrInsert(px)
+	lv.orders = append(lv.orders, bookOrder{id: id, size: size})
+	lv.size += uint64(size)
+	b.orders[id] = orderRef{bid: bid, px: px}
+}
+
+// cancel removes size from an order (partial cancel) or the whole order.
+func (b *OrderBook) cancel(id uint64, size uint32) {
+	if !b.remove(id, size) {
+		b.Unknown++
+	}
+}
+
+// remove takes up to size off order id and drops it once empty.
+func (b *OrderBook) remove(id uint64, size uint32) bool {
+	ref, ok := b.orders[id]
+	if !ok {
+		return false
+	}
+	side := b.sideOf(ref.bid)
+	li, ok := side.find(ref.px)
+	if !ok {
+		delete(b.orders, id)
+		return false
+	}
+	lv := side.levels[li]
+	oi := lv.indexOf(id)
+	if oi < 0 {
+		delete(b.orders, id)
+		return false
+	}
+
+	o := &lv.orders[oi]
+	if size < o.size {
+		o.size -= size
+		lv.size -= uint64(size)
+		return true
+	}
+
+	lv.size -= uint64(o.size)
+	lv.orders = append(lv.orders[:oi], lv.orders[oi+1:]...)
+	delete(b.orders, id)
+	if len(lv.orders) == 0 {
+		side.removeAt(li)
+	}
+	return true
+}
+
+func (b *OrderBook) modify(m *MBOMsg) {
+	ref, ok := b.orders[m.OrderID]
+	if !ok {
+		// Databento treats a modify of an unseen order as an add.
+		b.Unknown++
+		if m.Side == 'B' || m.Side == 'A' {
+			b.add(m.Side == 'B', m.OrderID, m.PxRaw, m.Size)
+		}
+		return
+	}
+	if m.Size == 0 {
+		b.remove(m.OrderID, ^uint32(0))
+		return
+	}
+
+	bid := ref.bid
+	if m.Side == 'B' || m.Side == 'A' {
+		bid = m.Side == 'B'
+	}
+	if bid == ref.bid && m.PxRaw == ref.px {
+		side := b.sideOf(bid)
+		if li, ok := side.find(ref.px); ok {
+			lv := side.levels[li]
+			if oi := lv.indexOf(m.OrderID); oi >= 0 && m.Size <= lv.orders[oi].size {
+				// Size reduction keeps queue priority.
+				o := &lv.orders[oi]
+				lv.size -= uint64(o.size - m.Size)
+				o.size = m.Size
+				return
+			}
+		}
+	}
+
+	// Price change or size increase: back of the queue at the new price.
+	b.remove(m.OrderID, ^uint32(0))
+	b.add(bid, m.OrderID, m.PxRaw, m.Size)
+}
+
+// Depth fills dst with the best len(dst) levels of each side. Missing levels
+// carry NullPrice and zero size, as in DBN MBP records.
+func (b *OrderBook) Depth(dst []BookLevel) {
+	for i := range dst {
+		lv := BookLevel{BidPxRaw: NullPrice, AskPxRaw: NullPrice}
+		if i < len(b.bids.levels) {
+			p := b.bids.levels[i]
+			lv.BidPxRaw = p.px
+			lv.BidSz = clampU32(p.size)
+			lv.BidCt = uint32(len(p.orders))
+		}
+		if i < len(b.asks.levels) {
+			p := b.asks.levels[i]
+			lv.AskPxRaw = p.px
+			lv.AskSz = clampU32(p.size)
+			lv.AskCt = uint32(len(p.orders))
+		}
+		dst[i] = lv
+	}
+}
+
+// BBO returns the top level of the book.
+func (b *OrderBook) BBO() BookLevel {
+	var top [1]BookLevel
+	b.Depth(top[:])
+	return top[0]
+}
+
+func clampU32(v uint64) uint32 {
+	if v > uint64(^uint32(0)) {
+		return ^uint32(0)
+	}
+	return uint32(v)
+}
+
+// =============================================================================
+//  Replay
+// =============================================================================
+
+type bookKey struct {
+	pub   uint16
+	instr uint32
+}
+
+// MBOReplay routes MBO messages to one OrderBook per (publisher, instrument)
+// and turns trades into TBBO-equivalent events.
+type MBOReplay struct {
+	books map[bookKey]*OrderBook
+}
+
+func NewMBOReplay() *MBOReplay {
+	return &MBOReplay{books: make(map[bookKey]*OrderBook)}
+}
+
+func (r *MBOReplay) Book(pub uint16, instr uint32) *OrderBook {
+	k := bookKey{pub, instr}
+	b := r.books[k]
+	if b == nil {
+		b = NewOrderBook()
+		r.books[k] = b
+	}
+	return b
+}
+
+// Apply feeds m to its book. For a priced trade it returns true and fills
+// depth with the book as it stood when the trade printed (the TBBO
+// convention); depth is left untouched otherwise.
+func (r *MBOReplay) Apply(m *MBOMsg, depth []BookLevel) bool {
+	b := r.Book(m.PubID, m.InstrID)
+	if m.Action == 'T' && m.PxRaw != NullPrice {
+		b.Depth(depth)
+		return true
+	}
+	b.Apply(m)
+	return false
+}
+
+// Unknown sums OrderBook.Unknown across all books.
+func (r *MBOReplay) Unknown() int {
+	n := 0
+	for _, b := range r.books {
+		n += b.Unknown
+	}
+	return n
+}
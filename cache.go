@@ -0,0 +1,241 @@
+package main
+
+import (
+	"container/list"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// -----------------------------------------------------------------------------
+// Shared column cache: whole TBBO files loaded once and handed to every
+// strategy that asks for the same path, within an explicit byte budget.
+//
+// Entries are reference counted. Load pins an entry until the matching
+// Release; only unpinned entries are evicted, least recently used first, and
+// only then do their columns go back to TBBOPool. Pinned entries can push
+// the cache past its budget; the excess is evicted as they are released. A
+// file larger than the whole budget is loaded for its callers but not kept.
+// An entry whose file changed size or modification time since it was loaded
+// (an append, a re-conversion) is dropped and the file read again.
+// -----------------------------------------------------------------------------
+
+// SharedCache is a byte-budgeted LRU of loaded TBBO files keyed by path.
+type SharedCache struct {
+	mu      sync.Mutex
+	budget  int64
+	used    int64                         // bytes of all entries, pinned or not
+	entries map[string]*sharedEntry       // by path
+	byCols  map[*TBBOColumns]*sharedEntry // for Release
+	lru     list.List                     // of *sharedEntry, front = most recent
+
+	hits, misses, evictions uint64
+}
+
+type sharedEntry struct {
+	path  string
+	cols  *TBBOColumns
+	bytes int64
+	refs  int
+	elem  *list.Element // nil while loading and once dropped from the LRU
+	kept  bool          // counted in used
+	size  int64         // file size and modification time when loaded
+	mod   time.Time
+
+	loaded chan struct{} // closed when cols or err is set
+	err    error
+}
+
+// CacheStats is a snapshot of a SharedCache.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64 // loads from disk
+	Evictions uint64
+	Entries   int
+	Pinned    int // entries with callers that have not released them
+	Bytes     int64
+	Budget    int64
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d files, %.1f / %.1f GiB, %d pinned; %d hits, %d misses, %d evictions",
+		s.Entries, float64(s.Bytes)/(1<<30), float64(s.Budget)/(1<<30), s.Pinned,
+		s.Hits, s.Misses, s.Evictions)
+}
+
+// SharedColumns backs LoadQuantDevShared.
+var SharedColumns = NewSharedCache(SharedCacheBytes)
+
+// NewSharedCache returns an empty cache that keeps at most budget bytes of
+// unpinned columns.
+func NewSharedCache(budget int64) *SharedCache {
+	return &SharedCache{
+		budget:  budget,
+		entries: make(map[string]*sharedEntry),
+		byCols:  make(map[*TBBOColumns]*sharedEntry),
+	}
+}
+
+// LoadQuantDevShared loads a whole TBBO file through SharedColumns. The
+// columns are shared and must not be modified; hand them back with
+// SharedColumns.Release, not TBBOPool.Put.
+func LoadQuantDevShared(path string) (*TBBOColumns, error) {
+	return SharedColumns.Load(path)
+}
+
+// Load returns the columns of path, loading them on a miss, and pins them
+// until Release. Concurrent loads of one path read the file once.
+func (c *SharedCache) Load(path string) (*TBBOColumns, error) {
+	st, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	if ok && e.cols != nil && (e.size != st.Size() || !e.mod.Equal(st.ModTime())) {
+		c.drop(e)
+		ok = false
+	}
+	if ok {
+		e.refs++
+		if e.elem != nil {
+			c.lru.MoveToFront(e.elem)
+		}
+		c.hits++
+		c.mu.Unlock()
+
+		<-e.loaded
+		if e.err != nil {
+			c.mu.Lock()
+			e.refs--
+			c.mu.Unlock()
+			return nil, e.err
+		}
+		return e.cols, nil
+	}
+
+	e = &sharedEntry{path: path, refs: 1, size: st.Size(), mod: st.ModTime(), loaded: make(chan struct{})}
+	c.entries[path] = e
+	c.misses++
+	c.mu.Unlock()
+
+	cols := TBBOPool.Get().(*TBBOColumns)
+	err = loadFromFile(path, cols)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err != nil {
+		TBBOPool.Put(cols)
+		e.err = err
+		e.refs--
+		delete(c.entries, path)
+		close(e.loaded)
+		return nil, err
+	}
+	e.cols = cols
+	e.bytes = cols.memBytes()
+	c.byCols[cols] = e
+	if e.bytes <= c.budget {
+		e.kept = true
+		e.elem = c.lru.PushFront(e)
+		c.used += e.bytes
+		c.evict()
+	} else {
+		// Too big to keep: shared by whoever is already waiting, then gone.
+		delete(c.entries, path)
+	}
+	close(e.loaded)
+	return cols, nil
+}
+
+// Release unpins columns returned by Load. Columns no longer cached go back
+// to TBBOPool with their last release.
+func (c *SharedCache) Release(cols *TBBOColumns) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.byCols[cols]
+	if !ok || e.refs == 0 {
+		panic("SharedCache.Release of columns it did not hand out")
+	}
+	e.refs--
+	if e.refs > 0 {
+		return
+	}
+	if !e.kept {
+		delete(c.byCols, cols)
+		TBBOPool.Put(cols)
+		return
+	}
+	c.evict()
+}
+
+// evict drops unpinned entries from the cold end until the cache fits its
+// budget. Called with mu held.
+func (c *SharedCache) evict() {
+	for el := c.lru.Back(); el != nil && c.used > c.budget; {
+		e := el.Value.(*sharedEntry)
+		el = el.Prev()
+		if e.refs == 0 {
+			c.drop(e)
+		}
+	}
+}
+
+// drop removes e from the cache. Its columns go back to TBBOPool now if
+// unpinned, else with their last Release. Called with mu held.
+func (c *SharedCache) drop(e *sharedEntry) {
+	if e.elem != nil {
+		c.lru.Remove(e.elem)
+		e.elem = nil
+	}
+	if e.kept {
+		e.kept = false
+		c.used -= e.bytes
+	}
+	delete(c.entries, e.path)
+	c.evictions++
+	if e.refs == 0 {
+		delete(c.byCols, e.cols)
+		TBBOPool.Put(e.cols)
+	}
+}
+
+// Stats returns the cache's counters and current size.
+func (c *SharedCache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s := CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   c.lru.Len(),
+		Bytes:     c.used,
+		Budget:    c.budget,
+	}
+	for _, e := range c.byCols {
+		if e.refs > 0 {
+			s.Pinned++
+		}
+	}
+	return s
+}
+
+// memBytes is the memory held by the column slices, by capacity.
+func (c *TBBOColumns) memBytes() int64 {
+	return capBytes(c.PublisherID) + capBytes(c.InstrumentID) +
+		capBytes(c.TsEvent) + capBytes(c.TsRecv) + capBytes(c.TsInDelta) +
+		capBytes(c.Prices) + capBytes(c.Sizes) + capBytes(c.Sides) +
+		capBytes(c.Actions) + capBytes(c.Flags) + capBytes(c.Depth) +
+		capBytes(c.Sequences) +
+		capBytes(c.BidPx) + capBytes(c.AskPx) + capBytes(c.BidSz) +
+		capBytes(c.AskSz) + capBytes(c.BidCt) + capBytes(c.AskCt) +
+		capBytes(c.PricesRaw) + capBytes(c.BidPxRaw) + capBytes(c.AskPxRaw)
+}
+
+func capBytes[T any](s []T) int64 {
+	var zero T
+	return int64(cap(s)) * int64(unsafe.Sizeof(zero))
+}
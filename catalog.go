@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Instrument catalog: instrument_id -> raw symbol, product, tick size,
+// multiplier, expiration and the date intervals the id was live.
+//
+// Fed by DBN definition records (rtype 0x13) and metadata symbol mappings
+// during `data`, persisted as a TSV next to the .quantdev files and read back
+// by `test` so rows resolve to a contract regardless of the filename.
+// -----------------------------------------------------------------------------
+
+const (
+	RTypeDefinition = 0x13
+	CatalogFile     = "instruments.tsv"
+
+	dbnSTypeInstrumentID = 0
+	dbnSTypeRawSymbol    = 1
+
+	nullI32 = 2147483647
+)
+
+// DateInterval is a YYYYMMDD range, start inclusive, end exclusive.
+type DateInterval struct {
+	Start uint32
+	End   uint32
+}
+
+func (d DateInterval) contains(date uint32) bool {
+	return date >= d.Start && (d.End == 0 || date < d.End)
+}
+
+type Instrument struct {
+	ID         uint32
+	RawSymbol  string // e.g. "MESZ4"
+	Asset      string // product root, e.g. "MES"
+	Class      byte   // DBN instrument_class: 'F' future, 'C'/'P' option, ...
+	TickSize   float64
+	Multiplier float64 // contract units per price point
+	Expiration uint64  // ns since UNIX epoch, 0 = unknown
+	Activation uint64
+	Intervals  []DateInterval
+}
+
+// TickValue is the currency value of one tick, or 0 if not defined.
+func (in *Instrument) TickValue() float64 {
+	return in.TickSize * in.Multiplier
+}
+
+// ContractMonth renders the expiration as "2024-12", or "" if unknown.
+func (in *Instrument) ContractMonth() string {
+	if in.Expiration == 0 {
+		return ""
+	}
+	return time.Unix(0, int64(in.Expiration)).UTC().Format("2006-01")
+}
+
+// AssetConfig starts from the AssetConfigs entry of the product (costs are
+// not in the data) and takes the tick value from the definition when known.
+func (in *Instrument) AssetConfig() AssetConfig {
+	c := GetAssetConfig(in.Asset)
+	if tv := in.TickValue(); tv > 0 {
+		c.TickValue = tv
+	}
+	return c
+}
+
+func (in *Instrument) activeOn(date uint32) bool {
+	for _, iv := range in.Intervals {
+		if iv.contains(date) {
+			return true
+		}
+	}
+	return false
+}
+
+func (in *Instrument) addInterval(iv DateInterval) {
+	for _, have := range in.Intervals {
+		if have == iv {
+			return
+		}
+	}
+	in.Intervals = append(in.Intervals, iv)
+}
+
+// InstrumentCatalog is safe for concurrent use by the ingestion workers.
+// An id maps to several instruments only when the venue recycled it.
+type InstrumentCatalog struct {
+	mu   sync.Mutex
+	byID map[uint32][]*Instrument
+}
+
+// Instruments is the process-wide catalog, loaded from CatalogFile by the
+// commands that need it.
+var Instruments = NewInstrumentCatalog()
+
+func NewInstrumentCatalog() *InstrumentCatalog {
+	return &InstrumentCatalog{byID: make(map[uint32][]*Instrument)}
+}
+
+func (c *InstrumentCatalog) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := 0
+	for _, list := range c.byID {
+		n += len(list)
+	}
+	return n
+}
+
+// entry returns the instrument for (id, rawSymbol), creating it if needed.
+// An empty rawSymbol matches the first entry. Caller holds c.mu.
+func (c *InstrumentCatalog) entry(id uint32, rawSymbol string) *Instrument {
+	list := c.byID[id]
+	for _, in := range list {
+		if rawSymbol == "" || in.RawSymbol == "" || in.RawSymbol == rawSymbol {
+			if in.RawSymbol == "" {
+				in.RawSymbol = rawSymbol
+			}
+			return in
+		}
+	}
+	in := &Instrument{ID: id, RawSymbol: rawSymbol}
+	c.byID[id] = append(list, in)
+	return in
+}
+
+// Resolve finds the instrument behind id at time ts (ns). When the id was
+// recycled, the entry whose intervals cover ts wins.
+func (c *InstrumentCatalog) Resolve(id uint32, ts uint64) (*Instrument, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	list := c.byID[id]
+	if len(list) == 0 {
+		return nil, false
+	}
+	if len(list) > 1 && ts != 0 {
+		date := yyyymmdd(ts)
+		for _, in := range list {
+			if in.activeOn(date) {
+				return in, true
+			}
+		}
+	}
+	return list[0], true
+}
+
+// Symbol renders the raw symbol behind id at ts, or the id itself when the
+// catalog does not know it.
+func (c *InstrumentCatalog) Symbol(id uint32, ts uint64) string {
+	if in, ok := c.Resolve(id, ts); ok && in.RawSymbol != "" {
+		return in.RawSymbol
+	}
+	return strconv.FormatUint(uint64(id), 10)
+}
+
+// AddMappings records the metadata symbology. Only mappings that resolve to
+// instrument ids carry information for the catalog.
+func (c *InstrumentCatalog) AddMappings(m *DBNMetadata) {
+	if m == nil || m.StypeOut != dbnSTypeInstrumentID {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, sm := range m.Mappings {
+		raw := ""
+		if m.StypeIn == dbnSTypeRawSymbol {
+			raw = sm.RawSymbol
+		}
+		for _, iv := range sm.Intervals {
+			id, err := strconv.ParseUint(iv.Symbol, 10, 32)
+			if err != nil {
+				continue
+			}
+			in := c.entry(uint32(id), raw)
+			if in.Asset == "" {
+				in.Asset = symbolRoot(sm.RawSymbol)
+			}
+			in.addInterval(DateInterval{Start: iv.StartDate, End: iv.EndDate})
+		}
+	}
+}
+
+// defLayout holds the field offsets of one InstrumentDefMsg version. The
+// leading fields (ts_recv .. activation) are shared by all versions.
+type defLayout struct {
+	uomQty, multiplier int
+	rawSymbol, symLen  int
+	asset, assetLen    int
+	class              int
+}
+
+// Keyed by record size without ts_out: v1 = 360, v2 = 400, v3 = 520 bytes.
+var defLayouts = map[int]defLayout{
+	360: {uomQty: 88, multiplier: 160, rawSymbol: 200, symLen: 22, asset: 248, assetLen: 7, class: 325},
+	400: {uomQty: 88, multiplier: 164, rawSymbol: 200, symLen: 71, asset: 297, assetLen: 7, class: 374},
+	520: {uomQty: 80, multiplier: 176, rawSymbol: 238, symLen: 71, asset: 335, assetLen: 11, class: 487},
+}
+
+// AddDefinition merges one definition record into the catalog. It reports
+// false for record sizes that match no known DBN version.
+func (c *InstrumentCatalog) AddDefinition(rec []byte) bool {
+	lay, ok := defLayouts[len(rec)]
+	if !ok {
+		// Same record with the 8-byte ts_out suffix.
+		lay, ok = defLayouts[len(rec)-8]
+	}
+	if !ok {
+		return false
+	}
+
+	// [16:24] ts_recv, [24:32] min_price_increment, [32:40] display_factor,
+	// [40:48] expiration, [48:56] activation
+	id := binary.LittleEndian.Uint32(rec[4:8])
+	tick := int64(binary.LittleEndian.Uint64(rec[24:32]))
+	expiration := binary.LittleEndian.Uint64(rec[40:48])
+	activation := binary.LittleEndian.Uint64(rec[48:56])
+	uomQty := int64(binary.LittleEndian.Uint64(rec[lay.uomQty : lay.uomQty+8]))
+	contractMult := int32(binary.LittleEndian.Uint32(rec[lay.multiplier : lay.multiplier+4]))
+
+	raw := cstrAt(rec, lay.rawSymbol, lay.symLen)
+	asset := cstrAt(rec, lay.asset, lay.assetLen)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	in := c.entry(id, raw)
+	in.Class = rec[lay.class]
+	if asset != "" {
+		in.Asset = asset
+	} else if in.Asset == "" {
+		in.Asset = symbolRoot(raw)
+	}
+	if tick != NullPrice && tick > 0 {
+		in.TickSize = float64(tick) * PxScale
+	}
+	// CME quotes the contract size as unit_of_measure_qty (fixed-9);
+	// contract_multiplier is the fallback for venues that use it instead.
+	switch {
+	case uomQty != NullPrice && uomQty > 0:
+		in.Multiplier = float64(uomQty) * PxScale
+	case contractMult != nullI32 && contractMult > 0:
+		in.Multiplier = float64(contractMult)
+	case in.Multiplier == 0:
+		in.Multiplier = 1
+	}
+	if expiration != ^uint64(0) {
+		in.Expiration = expiration
+	}
+	if activation != ^uint64(0) {
+		in.Activation = activation
+	}
+	return true
+}
+
+func cstrAt(b []byte, off, n int) string {
+	s := b[off : off+n]
+	for i, ch := range s {
+		if ch == 0 {
+			return string(s[:i])
+		}
+	}
+	return string(s)
+}
+
+func yyyymmdd(ns uint64) uint32 {
+	y, m, d := time.Unix(0, int64(ns)).UTC().Date()
+	return uint32(y*10000 + int(m)*100 + d)
+}
+
+// -----------------------------------------------------------------------------
+// Persistence: one TSV row per instrument; intervals as "start-end,...".
+// -----------------------------------------------------------------------------
+
+const catalogHeader = "instrument_id\traw_symbol\tasset\tclass\ttick_size\tmultiplier\texpiration\tactivation\tintervals"
+
+// Load merges the catalog file at path. A missing file is not an error.
+func (c *InstrumentCatalog) Load(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if text == "" || strings.HasPrefix(text, "instrument_id\t") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 9 {
+			return fmt.Errorf("%s:%d: want 9 fields, got %d", path, line, len(fields))
+		}
+		id, err := strconv.ParseUint(fields[0], 10, 32)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+
+		in := c.entry(uint32(id), fields[1])
+		in.Asset = fields[2]
+		if fields[3] != "" {
+			in.Class = fields[3][0]
+		}
+		in.TickSize, _ = strconv.ParseFloat(fields[4], 64)
+		in.Multiplier, _ = strconv.ParseFloat(fields[5], 64)
+		in.Expiration, _ = strconv.ParseUint(fields[6], 10, 64)
+		in.Activation, _ = strconv.ParseUint(fields[7], 10, 64)
+		for _, s := range strings.Split(fields[8], ",") {
+			a, b, ok := strings.Cut(s, "-")
+			if !ok {
+				continue
+			}
+			start, _ := strconv.ParseUint(a, 10, 32)
+			end, _ := strconv.ParseUint(b, 10, 32)
+			in.addInterval(DateInterval{Start: uint32(start), End: uint32(end)})
+		}
+	}
+	return sc.Err()
+}
+
+// Save writes the catalog to a temp file and renames it over path.
+func (c *InstrumentCatalog) Save(path string) error {
+	c.mu.Lock()
+	ids := make([]uint32, 0, len(c.byID))
+	for id := range c.byID {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	var sb strings.Builder
+	sb.WriteString(catalogHeader)
+	sb.WriteByte('\n')
+	for _, id := range ids {
+		for _, in := range c.byID[id] {
+			class := ""
+			if in.Class != 0 {
+				class = string(in.Class)
+			}
+			ivs := make([]string, len(in.Intervals))
+			for i, iv := range in.Intervals {
+				ivs[i] = fmt.Sprintf("%d-%d", iv.Start, iv.End)
+			}
+			fmt.Fprintf(&sb, "%d\t%s\t%s\t%s\t%g\t%g\t%d\t%d\t%s\n",
+				in.ID, in.RawSymbol, in.Asset, class,
+				in.TickSize, in.Multiplier, in.Expiration, in.Activation,
+				strings.Join(ivs, ","))
+		}
+	}
+	c.mu.Unlock()
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
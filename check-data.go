@@ -1,9 +1,12 @@
 package main
 
 import (
+	"flag"
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"text/tabwriter"
 	"time"
 )
@@ -15,7 +18,13 @@ const (
 	WarnBigGapFrac   = 0.01             // 1% of ticks have >60s gap → WARN
 )
 
-func runCheck() {
+// runCheck implements `check [-chunks] [-seq]`.
+func runCheck(args []string) {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	chunks := fs.Bool("chunks", false, "also print every file's chunk zone maps")
+	seq := fs.Bool("seq", false, "also print sequence analysis per (publisher, instrument) and where anomalies occurred")
+	fs.Parse(args)
+
 	fmt.Println(">>> DATA FORENSICS: QuantDev Binary Check (Smart TBBO) <<<")
 
 	files, _ := filepath.Glob("*.quantdev")
@@ -24,28 +33,106 @@ func runCheck() {
 		return
 	}
 
+	if err := Instruments.Load(CatalogFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+	events, err := LoadAllEventLogs()
+	if err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
-	fmt.Fprintln(w, "FILE\tTICKS\tGAP>1s%\tGAP>60s%\tMAX_GAP\tBAD_PX\tSTATUS")
-	fmt.Fprintln(w, "----\t-----\t--------\t---------\t-------\t------\t------")
+	fmt.Fprintln(w, "FILE\tSYMBOL\tTICKS\tGAP>1s%\tGAP>60s%\tMAX_GAP\tBAD_PX\tHALTS\tSEQ_GAPS\tSEQ_MISSING\tMAX_SEQ_GAP\tOUT_OF_ORDER\tDUP_SEQ\tSTATUS")
+	fmt.Fprintln(w, "----\t------\t-----\t--------\t---------\t-------\t------\t-----\t--------\t-----------\t-----------\t------------\t-------\t------")
 
-	for _, path := range files {
-		checkBinaryFile(path, w)
+	reports := make([]*seqReport, len(files))
+	for i, path := range files {
+		reports[i] = checkBinaryFile(path, w, events)
 	}
 	w.Flush()
+
+	if *seq {
+		for i, path := range files {
+			if reports[i] != nil {
+				printSequenceDetail(path, reports[i])
+			}
+		}
+	}
+	if *chunks {
+		for _, path := range files {
+			printChunkSummary(path)
+		}
+	}
+	printEventSummary(events)
 }
 
-func checkBinaryFile(path string, w *tabwriter.Writer) {
-	cols, err := LoadQuantDev(path)
+// printChunkSummary lists a file's chunks from its footer alone.
+func printChunkSummary(path string) {
+	idx, err := ReadQuantDevIndex(path)
 	if err != nil {
-		fmt.Fprintf(w, "%s\tERR\t-\t-\t-\t-\t%v\n", filepath.Base(path), err)
+		fmt.Printf("\n[err] %s: %v\n", filepath.Base(path), err)
 		return
 	}
+	fmt.Printf("\n>>> CHUNKS: %s (%s, format %d, %d columns, %d rows, %d chunks) <<<\n",
+		filepath.Base(path), idx.Header.LayoutName(), idx.Header.Version,
+		len(idx.Columns.Columns), idx.Header.Rows, len(idx.Chunks))
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "CHUNK\tROWS\tTS_MIN\tTS_MAX\tPX_MIN\tPX_MAX\tINSTRUMENTS")
+	for i := range idx.Chunks {
+		c := &idx.Chunks[i]
+		px := "-\t-"
+		instr := "-"
+		if c.Zoned {
+			if c.PxMin == c.PxMin {
+				px = fmt.Sprintf("%.9g\t%.9g", c.PxMin, c.PxMax)
+			}
+			instr = chunkSymbols(c)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%s\t%s\t%s\n",
+			i, c.Rows, fmtNanos(c.TsMin), fmtNanos(c.TsMax), px, instr)
+	}
+	w.Flush()
+}
+
+// chunkSymbols names the first few instruments of a chunk.
+func chunkSymbols(c *ChunkInfo) string {
+	const maxShown = 3
+	var names []string
+	for i, id := range c.Instruments {
+		if i == maxShown {
+			names = append(names, fmt.Sprintf("+%d", len(c.Instruments)-maxShown))
+			break
+		}
+		names = append(names, Instruments.Symbol(id, c.TsMin))
+	}
+	return strings.Join(names, ",")
+}
+
+// checkColumns are the only columns checkBinaryFile reads.
+const checkColumns = ColTsEvent | ColPrices | ColFlags | ColSequences | ColPublisherID | ColInstrumentID
+
+func checkBinaryFile(path string, w *tabwriter.Writer, events []MarketEvent) *seqReport {
+	name := filepath.Base(path)
+	cols, err := LoadQuantDevTBBO(path, TBBOQuery{Columns: checkColumns})
+	if err != nil {
+		fmt.Fprintf(w, "%s\t-\tERR\t-\t-\t-\t-\t-\t-\t-\t-\t-\t-\t%v\n", name, err)
+		return nil
+	}
 	defer TBBOPool.Put(cols)
 
 	n := cols.Count
+	sym, _ := resolveAsset(path, instrumentIDs(cols), minTsEvent(cols))
 	if n == 0 {
-		fmt.Fprintf(w, "%s\t0\t-\t-\t-\t-\tEMPTY\n", filepath.Base(path))
-		return
+		fmt.Fprintf(w, "%s\t%s\t0\t-\t-\t-\t-\t-\t-\t-\t-\t-\t-\tEMPTY\n", name, sym)
+		return nil
+	}
+
+	halts := 0
+	for _, h := range HaltWindows(events, instrumentKeys(cols)...) {
+		if h.overlaps(cols.TsEvent[0], cols.TsEvent[n-1]) {
+			halts++
+		}
 	}
 
 	var (
@@ -91,20 +178,301 @@ func checkBinaryFile(path string, w *tabwriter.Writer) {
 	frac1s := float64(gaps1s) / float64(n) * 100.0
 	frac60s := float64(gaps60s) / float64(n) * 100.0
 
+	seq := analyzeSequences(cols)
+	seqCols := "-\t-\t-\t-\t-"
+	if t := seq.total; t.rows > 0 {
+		seqCols = fmt.Sprintf("%d\t%d\t%d\t%d\t%d", t.gaps, t.missing, t.maxGap, t.outOfOrder, t.dups)
+	}
+
 	status := "OK"
-	if badPx > 0 || frac60s > WarnBigGapFrac*100.0 {
+	if badPx > 0 || frac60s > WarnBigGapFrac*100.0 || seq.total.outOfOrder > 0 || seq.total.dups > 0 {
 		status = "WARN"
 	}
 
 	fmt.Fprintf(
 		w,
-		"%s\t%d\t%.3f\t%.3f\t%s\t%d\t%s\n",
-		filepath.Base(path),
+		"%s\t%s\t%d\t%.3f\t%.3f\t%s\t%d\t%d\t%s\t%s\n",
+		name,
+		sym,
 		n,
 		frac1s,
 		frac60s,
 		maxGap.Round(time.Millisecond),
 		badPx,
+		halts,
+		seqCols,
 		status,
 	)
+	return seq
+}
+
+// -----------------------------------------------------------------------------
+// Sequence forensics: venue sequence numbers per (publisher, instrument).
+//
+// A row whose sequence is above the stream's highest so far by more than one
+// opens a gap; one below it is out of order; one equal to it with a
+// different ts_event is a duplicate (a message repeated, e.g. after a
+// feed recovery). Equal sequence and ts_event is one venue message with
+// several fills and is not counted. Sequence 0 means the source had none.
+//
+// Venues number every message of a channel, so trade-only rows (TBBO, or
+// the trades kept from MBP-10/MBO) skip the book updates between trades and
+// show gaps without any loss. Gaps therefore do not change STATUS; out of
+// order and duplicate sequences do.
+// -----------------------------------------------------------------------------
+
+// maxSeqEvents bounds the anomalies kept per file for printSequenceDetail:
+// the first out-of-order and duplicate rows and the largest gaps.
+const maxSeqEvents = 20
+
+// Sequence anomaly kinds.
+const (
+	SeqGap        = 'G'
+	SeqOutOfOrder = 'O'
+	SeqDuplicate  = 'D'
+)
+
+// seqKey is a (publisher, instrument) stream.
+type seqKey struct {
+	pub   uint16
+	instr uint32
+}
+
+// seqEvent is one sequence anomaly.
+type seqEvent struct {
+	kind byte
+	key  seqKey
+	ts   uint64 // ts_event of the row
+	seq  uint32
+	prev uint32 // the stream's highest sequence before the row
+}
+
+// seqCounts are the sequence statistics of one stream or a whole file.
+type seqCounts struct {
+	rows       int // rows with a sequence
+	gaps       int
+	missing    uint64 // sequence numbers skipped by the gaps
+	maxGap     uint32
+	maxGapTs   uint64
+	outOfOrder int
+	dups       int
+}
+
+func (c *seqCounts) add(o *seqCounts) {
+	c.rows += o.rows
+	c.gaps += o.gaps
+	c.missing += o.missing
+	if o.maxGap > c.maxGap {
+		c.maxGap, c.maxGapTs = o.maxGap, o.maxGapTs
+	}
+	c.outOfOrder += o.outOfOrder
+	c.dups += o.dups
+}
+
+// seqStream is the analysis state of one (publisher, instrument).
+type seqStream struct {
+	seqCounts
+	last   uint32 // highest sequence so far
+	lastTs uint64 // ts_event of the row that set last
+}
+
+// seqReport is the sequence analysis of one file.
+type seqReport struct {
+	total   seqCounts
+	streams map[seqKey]*seqStream
+	events  []seqEvent // out of order and duplicates, first maxSeqEvents
+	gaps    []seqEvent // largest maxSeqEvents gaps, largest first
+}
+
+// analyzeSequences runs the sequence checks over the rows of cols in file
+// order.
+func analyzeSequences(cols *TBBOColumns) *seqReport {
+	r := &seqReport{streams: make(map[seqKey]*seqStream)}
+	var s *seqStream
+	var cur seqKey
+	for i := 0; i < cols.Count; i++ {
+		seq := cols.Sequences[i]
+		if seq == 0 {
+			continue
+		}
+		key := seqKey{cols.PublisherID[i], cols.InstrumentID[i]}
+		if s == nil || key != cur {
+			if s = r.streams[key]; s == nil {
+				s = &seqStream{}
+				r.streams[key] = s
+			}
+			cur = key
+		}
+		ts := cols.TsEvent[i]
+		s.rows++
+		if s.rows == 1 {
+			s.last, s.lastTs = seq, ts
+			continue
+		}
+
+		ev := seqEvent{key: key, ts: ts, seq: seq, prev: s.last}
+		switch {
+		case seq > s.last+1:
+			gap := seq - s.last - 1
+			s.gaps++
+			s.missing += uint64(gap)
+			if gap > s.maxGap {
+				s.maxGap, s.maxGapTs = gap, ts
+			}
+			ev.kind = SeqGap
+			r.keepGap(ev)
+		case seq < s.last:
+			s.outOfOrder++
+			ev.kind = SeqOutOfOrder
+		case seq == s.last && ts != s.lastTs:
+			s.dups++
+			ev.kind = SeqDuplicate
+		}
+		if ev.kind != 0 && ev.kind != SeqGap && len(r.events) < maxSeqEvents {
+			r.events = append(r.events, ev)
+		}
+		if seq > s.last {
+			s.last, s.lastTs = seq, ts
+		}
+	}
+	for _, s := range r.streams {
+		r.total.add(&s.seqCounts)
+	}
+	return r
+}
+
+// keepGap keeps ev if it is among the largest maxSeqEvents gaps so far.
+func (r *seqReport) keepGap(ev seqEvent) {
+	size := func(e seqEvent) uint32 { return e.seq - e.prev }
+	if len(r.gaps) == maxSeqEvents && size(ev) <= size(r.gaps[len(r.gaps)-1]) {
+		return
+	}
+	// Largest first; a gap goes after those of equal size, so ties stay in
+	// the order the rows came.
+	i, _ := slices.BinarySearchFunc(r.gaps, ev, func(a, b seqEvent) int {
+		if size(a) >= size(b) {
+			return -1
+		}
+		return 1
+	})
+	r.gaps = slices.Insert(r.gaps, i, ev)
+	if len(r.gaps) > maxSeqEvents {
+		r.gaps = r.gaps[:maxSeqEvents]
+	}
+}
+
+// printSequenceDetail lists a file's streams and the anomalies kept by
+// analyzeSequences with their timestamps.
+func printSequenceDetail(path string, r *seqReport) {
+	fmt.Printf("\n>>> SEQUENCES: %s (%d streams) <<<\n", filepath.Base(path), len(r.streams))
+	if len(r.streams) == 0 {
+		fmt.Println("   no sequence numbers in this file")
+		return
+	}
+
+	keys := make([]seqKey, 0, len(r.streams))
+	for k := range r.streams {
+		keys = append(keys, k)
+	}
+	slices.SortFunc(keys, func(a, b seqKey) int {
+		if a.pub != b.pub {
+			return int(a.pub) - int(b.pub)
+		}
+		return int(int64(a.instr) - int64(b.instr))
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PUBLISHER\tINSTRUMENT\tROWS\tSEQ_GAPS\tSEQ_MISSING\tMAX_SEQ_GAP\tMAX_GAP_AT\tOUT_OF_ORDER\tDUP_SEQ")
+	for _, k := range keys {
+		s := r.streams[k]
+		at := "-"
+		if s.maxGap > 0 {
+			at = fmtNanosFull(s.maxGapTs)
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%d\t%d\t%s\t%d\t%d\n",
+			k.pub, Instruments.Symbol(k.instr, s.lastTs), s.rows,
+			s.gaps, s.missing, s.maxGap, at, s.outOfOrder, s.dups)
+	}
+	w.Flush()
+
+	events := append(slices.Clone(r.events), r.gaps...)
+	if len(events) == 0 {
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KIND\tPUBLISHER\tINSTRUMENT\tTS_EVENT\tSEQUENCE\tPREV_MAX\tMISSING")
+	for _, e := range events {
+		kind, missing := "out-of-order", "-"
+		switch e.kind {
+		case SeqGap:
+			kind, missing = "gap", fmt.Sprint(e.seq-e.prev-1)
+		case SeqDuplicate:
+			kind = "duplicate"
+		}
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%d\t%d\t%s\n",
+			kind, e.key.pub, Instruments.Symbol(e.key.instr, e.ts), fmtNanosFull(e.ts), e.seq, e.prev, missing)
+	}
+	w.Flush()
+	if n := r.total.outOfOrder + r.total.dups; n > len(r.events) {
+		fmt.Printf("   ... %d more out-of-order/duplicate rows\n", n-len(r.events))
+	}
+	if r.total.gaps > len(r.gaps) {
+		fmt.Printf("   ... %d more gaps\n", r.total.gaps-len(r.gaps))
+	}
+}
+
+// fmtNanosFull is fmtNanos with nanoseconds.
+func fmtNanosFull(ns uint64) string {
+	return time.Unix(0, int64(ns)).UTC().Format("2006-01-02T15:04:05.000000000Z")
+}
+
+// printEventSummary reports what the side-channel event logs recorded:
+// counts per kind, every halt window and the gateway error/system messages.
+func printEventSummary(events []MarketEvent) {
+	if len(events) == 0 {
+		return
+	}
+
+	var nStatus, nErr, nSys int
+	for i := range events {
+		switch events[i].Kind {
+		case EventStatus:
+			nStatus++
+		case EventError:
+			nErr++
+		case EventSystem:
+			nSys++
+		}
+	}
+	fmt.Printf("\n>>> EVENTS: %d status, %d error, %d system <<<\n", nStatus, nErr, nSys)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	halts := HaltWindows(events)
+	if len(halts) > 0 {
+		fmt.Fprintln(w, "INSTRUMENT\tHALT_START\tDURATION")
+		for _, h := range halts {
+			dur := "open"
+			if h.End != ^uint64(0) {
+				dur = time.Duration(h.End - h.Start).Round(time.Second).String()
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", Instruments.Symbol(h.InstrID, h.Start), fmtNanos(h.Start), dur)
+		}
+		w.Flush()
+	}
+
+	const maxMessages = 20
+	shown := 0
+	for i := range events {
+		e := &events[i]
+		if e.Kind == EventStatus || e.Text == "" {
+			continue
+		}
+		if shown == maxMessages {
+			fmt.Printf("   ... %d more\n", nErr+nSys-shown)
+			break
+		}
+		fmt.Printf("   [%s] %s code=%d %s\n", e.Kind, fmtNanos(e.TsEvent), e.Code, e.Text)
+		shown++
+	}
 }
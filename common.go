@@ -13,9 +13,15 @@ const (
 	// 4–8 tends to be near-optimal for NVMe; tune if needed.
 	IOThreads = 8
 
-	// Memory-heavy backtest parallelism (runTest)
+	// Memory-heavy backtest parallelism (runTest whole-file loads)
 	TestMaxParallel = 4
 
+	// Streaming backtests hold about one chunk each, so they only need CPU.
+	TestStreamParallel = CPUThreads
+
+	// Memory for whole files kept by LoadQuantDevShared (cache.go).
+	SharedCacheBytes = 8 << 30
+
 	PxScale = 1e-9
 )
 
@@ -126,9 +132,30 @@ type TBBOColumns struct {
 	AskSz []float64 // best ask size
 	BidCt []uint32  // best bid order count
 	AskCt []uint32  // best ask order count
+
+	// Exact prices, filled only for files written by NewEncoderExact:
+	// Prices[i] == float64(PricesRaw[i]) * PriceScale, same for BidPx/AskPx.
+	// PriceScale is 0 when the file stores float64 prices.
+	PriceScale float64
+	PricesRaw  []int64
+	BidPxRaw   []int64
+	AskPxRaw   []int64
+
+	// mapped is set while the slices may alias a read-only file mapping
+	// (MappedQuantDev.Chunk); Reset then drops them instead of reusing them.
+	mapped bool
+}
+
+// Exact reports whether the int64 price views are populated.
+func (c *TBBOColumns) Exact() bool {
+	return c.PriceScale != 0
 }
 
 func (c *TBBOColumns) Reset() {
+	if c.mapped {
+		*c = TBBOColumns{}
+		return
+	}
 	c.Count = 0
 
 	c.PublisherID = c.PublisherID[:0]
@@ -152,6 +179,95 @@ func (c *TBBOColumns) Reset() {
 	c.AskSz = c.AskSz[:0]
 	c.BidCt = c.BidCt[:0]
 	c.AskCt = c.AskCt[:0]
+
+	c.PriceScale = 0
+	c.PricesRaw = c.PricesRaw[:0]
+	c.BidPxRaw = c.BidPxRaw[:0]
+	c.AskPxRaw = c.AskPxRaw[:0]
+}
+
+// trimTime drops the leading rows before tsStart and the trailing rows at or
+// after tsEnd. Used after loading whole chunks for a time window.
+func (c *TBBOColumns) trimTime(tsStart, tsEnd uint64) {
+	i0, i1 := 0, c.Count
+	for i0 < i1 && c.TsEvent[i0] < tsStart {
+		i0++
+	}
+	for i1 > i0 && c.TsEvent[i1-1] >= tsEnd {
+		i1--
+	}
+	c.keepRows(i0, i1)
+}
+
+// keepRows moves rows [i0:i1] to the front and drops the rest.
+func (c *TBBOColumns) keepRows(i0, i1 int) {
+	if i0 == 0 && i1 == c.Count {
+		return
+	}
+
+	c.PublisherID = window(c.PublisherID, i0, i1)
+	c.InstrumentID = window(c.InstrumentID, i0, i1)
+
+	c.TsEvent = window(c.TsEvent, i0, i1)
+	c.TsRecv = window(c.TsRecv, i0, i1)
+	c.TsInDelta = window(c.TsInDelta, i0, i1)
+
+	c.Prices = window(c.Prices, i0, i1)
+	c.Sizes = window(c.Sizes, i0, i1)
+	c.Sides = window(c.Sides, i0, i1)
+	c.Actions = window(c.Actions, i0, i1)
+	c.Flags = window(c.Flags, i0, i1)
+	c.Depth = window(c.Depth, i0, i1)
+	c.Sequences = window(c.Sequences, i0, i1)
+
+	c.BidPx = window(c.BidPx, i0, i1)
+	c.AskPx = window(c.AskPx, i0, i1)
+	c.BidSz = window(c.BidSz, i0, i1)
+	c.AskSz = window(c.AskSz, i0, i1)
+	c.BidCt = window(c.BidCt, i0, i1)
+	c.AskCt = window(c.AskCt, i0, i1)
+
+	if c.Exact() {
+		c.PricesRaw = window(c.PricesRaw, i0, i1)
+		c.BidPxRaw = window(c.BidPxRaw, i0, i1)
+		c.AskPxRaw = window(c.AskPxRaw, i0, i1)
+	}
+	c.Count = i1 - i0
+}
+
+// appendRows appends all rows of src. Columns src lacks stay empty in c.
+func (c *TBBOColumns) appendRows(src *TBBOColumns) {
+	if c.Count == 0 {
+		c.PriceScale = src.PriceScale
+	}
+
+	c.PublisherID = append(c.PublisherID, src.PublisherID...)
+	c.InstrumentID = append(c.InstrumentID, src.InstrumentID...)
+
+	c.TsEvent = append(c.TsEvent, src.TsEvent...)
+	c.TsRecv = append(c.TsRecv, src.TsRecv...)
+	c.TsInDelta = append(c.TsInDelta, src.TsInDelta...)
+
+	c.Prices = append(c.Prices, src.Prices...)
+	c.Sizes = append(c.Sizes, src.Sizes...)
+	c.Sides = append(c.Sides, src.Sides...)
+	c.Actions = append(c.Actions, src.Actions...)
+	c.Flags = append(c.Flags, src.Flags...)
+	c.Depth = append(c.Depth, src.Depth...)
+	c.Sequences = append(c.Sequences, src.Sequences...)
+
+	c.BidPx = append(c.BidPx, src.BidPx...)
+	c.AskPx = append(c.AskPx, src.AskPx...)
+	c.BidSz = append(c.BidSz, src.BidSz...)
+	c.AskSz = append(c.AskSz, src.AskSz...)
+	c.BidCt = append(c.BidCt, src.BidCt...)
+	c.AskCt = append(c.AskCt, src.AskCt...)
+
+	c.PricesRaw = append(c.PricesRaw, src.PricesRaw...)
+	c.BidPxRaw = append(c.BidPxRaw, src.BidPxRaw...)
+	c.AskPxRaw = append(c.AskPxRaw, src.AskPxRaw...)
+
+	c.Count += src.Count
 }
 
 // Still useful for non-decoder paths if you ever have them.
@@ -208,3 +324,12 @@ func resize[T any](s []T, n int) []T {
 	}
 	return s[:n]
 }
+
+// window moves s[i0:i1] to the front of s, keeping the backing array.
+// Columns that were not loaded stay empty.
+func window[T any](s []T, i0, i1 int) []T {
+	if len(s) == 0 {
+		return s
+	}
+	return s[:copy(s, s[i0:i1])]
+}
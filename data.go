@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -8,26 +9,54 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 )
 
 const (
-	DBNMagic  = "DBN"
-	RTypeTBBO = 1 // TBBO is MBP-1-on-trade in Databento's schema; rtype==1 for MBP-1/TBBO
+	DBNMagic = "DBN"
+
+	RTypeMBP0     = 0x00 // trades schema
+	RTypeTBBO     = 1    // TBBO is MBP-1-on-trade in Databento's schema; rtype==1 for MBP-1/TBBO
+	RTypeMBP10    = 0x0A
+	RTypeOHLCV1S  = 0x20
+	RTypeOHLCV1M  = 0x21
+	RTypeOHLCV1H  = 0x22
+	RTypeOHLCV1D  = 0x23
+	RTypeOHLCVEOD = 0x24
+
+	// Record sizes in bytes (without the optional 8-byte ts_out suffix).
+	RecSizeMBP0  = 48
+	RecSizeMBP1  = 80
+	RecSizeMBP10 = 368
+	RecSizeOHLCV = 56
+
+	NullPrice = 9223372036854775807 // i64::MAX sentinel
 )
 
-func runData() {
-	fmt.Println(">>> INGESTION: DBN (TBBO) -> QuantDev Binary <<<")
+func runData(opts IngestOptions) {
+	fmt.Println(">>> INGESTION: DBN -> QuantDev Binary <<<")
 
 	files, _ := filepath.Glob("*.dbn")
+	zstFiles, _ := filepath.Glob("*.dbn.zst")
+	files = append(files, zstFiles...)
 	if len(files) == 0 {
-		fmt.Println("[warn] No .dbn files found.")
+		fmt.Println("[warn] No .dbn or .dbn.zst files found.")
 		return
 	}
 
+	if err := Instruments.Load(CatalogFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+	if err := Manifest.Load(ManifestFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+	removeStaleTemps()
+
 	var wg sync.WaitGroup
 	// Use I/O-specific concurrency instead of full CPUThreads to avoid
 	// thrashing the filesystem and NVMe queue.
 	sem := make(chan struct{}, IOThreads)
+	var skipped atomic.Int64
 
 	for _, f := range files {
 		wg.Add(1)
@@ -35,180 +64,647 @@ func runData() {
 		go func(path string) {
 			defer wg.Done()
 			defer func() { <-sem }()
-			convertDBNToQuantDev(path)
+
+			st, err := os.Stat(path)
+			if err != nil {
+				fmt.Printf("   [err] %v\n", err)
+				return
+			}
+			if !opts.Force && Manifest.upToDate(path, st, opts) {
+				skipped.Add(1)
+				return
+			}
+
+			// Until the new outputs are complete the old entry is wrong.
+			Manifest.Forget(path)
+			res := convertDBNToQuantDev(path, opts)
+			if res.ok {
+				Manifest.Record(path, st, res, opts)
+			}
+			if err := Manifest.Save(); err != nil {
+				fmt.Printf("   [err] %s: %v\n", ManifestFile, err)
+			}
 		}(f)
 	}
 	wg.Wait()
+
+	// Skipped files whose mtime changed were rehashed; record the new mtime
+	// so the next run does not hash them again.
+	if Manifest.Dirty() {
+		if err := Manifest.Save(); err != nil {
+			fmt.Printf("   [err] %s: %v\n", ManifestFile, err)
+		}
+	}
+
+	if n := skipped.Load(); n > 0 {
+		fmt.Printf("[sys] %d unchanged files skipped (see %s, -force to redo)\n", n, ManifestFile)
+	}
+
+	if Instruments.Len() > 0 {
+		if err := Instruments.Save(CatalogFile); err != nil {
+			fmt.Printf("[err] %s: %v\n", CatalogFile, err)
+		} else {
+			fmt.Printf("[sys] %d instruments in %s\n", Instruments.Len(), CatalogFile)
+		}
+	}
+}
+
+// ingestResult is what runData records in the manifest for one input.
+type ingestResult struct {
+	ok      bool   // outputs are complete and kept
+	hash    uint64 // xxh64 of the source file bytes
+	rows    int
+	outputs []string
 }
 
-func convertDBNToQuantDev(path string) {
+func convertDBNToQuantDev(path string, opts IngestOptions) ingestResult {
+	var res ingestResult
 	f, err := os.Open(path)
 	if err != nil {
 		fmt.Printf("Err %s: %v\n", path, err)
-		return
+		return res
 	}
 	defer f.Close()
 
-	outPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".quantdev"
+	// The source bytes are hashed on the way in for the manifest.
+	var hash xxh64
+	hash.reset()
+	raw := io.TeeReader(f, &hash)
+
+	// .dbn.zst is decompressed on the fly; everything below sees plain DBN.
+	var src io.Reader = raw
+	base := path
+	if strings.HasSuffix(path, ".zst") {
+		src = NewZstdReader(raw)
+		base = strings.TrimSuffix(path, ".zst")
+	}
+
+	outPath := strings.TrimSuffix(base, filepath.Ext(base)) + ".quantdev"
 	fmt.Printf(" -> Converting %s...\n", filepath.Base(path))
 
-	enc, err := NewEncoder(outPath)
+	// 1. Metadata block (dataset, schema, date range, symbology).
+	// Files without the DBN magic are treated as bare record streams.
+	br := bufio.NewReaderSize(src, 64*1024)
+	meta, err := ReadDBNMetadata(br)
 	if err != nil {
-		fmt.Printf("encoder init failed %s: %v\n", outPath, err)
-		return
+		fmt.Printf("   [err] %s: %v\n", filepath.Base(path), err)
+		return res
+	}
+	if meta != nil {
+		fmt.Printf("    %s\n", meta.Summary())
+		Instruments.AddMappings(meta)
 	}
-	defer enc.Close()
 
-	// 1. Read Header (DBN metadata prefix)
-	headerBuf := make([]byte, 8)
-	startOffset := int64(0)
-	if n, _ := f.Read(headerBuf); n == 8 {
-		if string(headerBuf[0:3]) == DBNMagic {
-			metaLen := binary.LittleEndian.Uint32(headerBuf[4:8])
-			startOffset = int64(8 + metaLen)
+	// 2. Schema-specific sink. A known schema opens its output up front;
+	// bare or mixed-schema streams take the schema of their first record.
+	// Definition records only feed the instrument catalog; status, error and
+	// system records only feed the event log.
+	var sink recordSink
+	if meta != nil && meta.Schema != DBNNullSchema && meta.Schema != SchemaDefinition && meta.Schema != SchemaStatus {
+		rtype, ok := schemaRType(meta.Schema)
+		if ok {
+			sink, err = openSink(outPath, rtype, opts)
 		}
+		if err != nil {
+			fmt.Printf("encoder init failed %s: %v\n", outPath, err)
+			return res
+		}
+		if sink == nil {
+			fmt.Printf("   [warn] %s: schema %s not supported\n", filepath.Base(path), meta.SchemaName())
+			return res
+		}
+		sink.SetSource(meta)
+		sink.SetMeta(MetaSource, filepath.Base(path))
 	}
-	f.Seek(startOffset, io.SeekStart)
+	// Until the sink is closed, any return is a failure: Abort discards the
+	// partial outputs instead of renaming them into place.
+	defer func() {
+		if sink != nil {
+			sink.Abort()
+		}
+	}()
 
-	// 2. Streaming Loop
-	const BufSize = 64 * 1024
-	buf := make([]byte, BufSize)
-	leftover := make([]byte, 0, 256)
+	// 3. Streaming Loop
+	rr := NewDBNRecordReader(br, meta)
 	count := 0
+	skipped := 0
+	updates := 0 // MBO messages applied to the book without a row
+	defs := 0
+	var events []MarketEvent
 
 	for {
-		n, err := f.Read(buf)
-		if n == 0 {
+		rec, err := rr.Next()
+		if err != nil {
 			break
 		}
 
-		data := buf[:n]
-		if len(leftover) > 0 {
-			data = append(leftover, buf[:n]...)
-			leftover = leftover[:0]
+		// rtype at byte 1
+		if rec[1] == RTypeDefinition {
+			if Instruments.AddDefinition(rec) {
+				defs++
+			} else {
+				skipped++
+			}
+			continue
 		}
-
-		offset := 0
-		lenData := len(data)
-
-		for offset < lenData {
-			if lenData-offset < 1 {
-				leftover = append(leftover, data[offset:]...)
-				break
+		switch rec[1] {
+		case RTypeStatus, RTypeError, RTypeSystem:
+			if ev, ok := decodeMarketEvent(rec); ok {
+				events = append(events, ev)
+			} else {
+				skipped++
 			}
-
-			lengthWords := int(data[offset])
-			if lengthWords == 0 {
-				offset++
+			continue
+		}
+		if sink == nil {
+			var err error
+			if sink, err = openSink(outPath, rec[1], opts); err != nil {
+				fmt.Printf("encoder init failed %s: %v\n", outPath, err)
+				return res
+			}
+			if sink == nil {
+				skipped++
 				continue
 			}
-			recSize := lengthWords * 4
+			sink.SetSource(meta)
+			sink.SetMeta(MetaSource, filepath.Base(path))
+		}
+		if rec[1] != sink.RType() {
+			skipped++
+			continue
+		}
 
-			if lenData-offset < recSize {
-				leftover = append(leftover, data[offset:]...)
-				break
-			}
+		ok, err := sink.Write(rec)
+		if err != nil {
+			fmt.Printf("   [err] %s: %v\n", outPath, err)
+			return res
+		}
+		switch {
+		case ok:
+			count++
+		case rec[1] == RTypeMBO && len(rec) >= RecSizeMBO:
+			updates++
+		default:
+			skipped++
+		}
+	}
 
-			rec := data[offset : offset+recSize]
-			offset += recSize
+	// 4. Framing verdict, decided before anything replaces the previous
+	// outputs: Close leaves them complete under their temp names
+	// (gncFile.stage) and only commitOutputs renames them into place.
+	keep := withinTolerance(path, &rr.Stats, opts)
+	var outputs []string
+	closeFailed := false
+	if sink != nil {
+		outputs = sink.Outputs()
+		if !keep && opts.OnCorrupt == CorruptFail {
+			sink.Abort()
+		} else if err := sink.Close(); err != nil {
+			fmt.Printf("   [err] %s: %v\n", outPath, err)
+			closeFailed = true
+		}
+		sink = nil
+	}
 
-			// rtype at byte 1
-			if rec[1] != RTypeTBBO {
-				continue
-			}
+	if skipped > 0 {
+		fmt.Printf("   [info] %s: skipped %d records\n", filepath.Base(path), skipped)
+	}
+	if updates > 0 {
+		fmt.Printf("   [info] %s: %d MBO book updates replayed\n", filepath.Base(path), updates)
+	}
+	if len(events) > 0 {
+		logPath := EventLogPath(outPath)
+		if err := WriteEventLog(logPath+TmpSuffix, events); err != nil {
+			os.Remove(logPath + TmpSuffix)
+			fmt.Printf("   [err] %s: %v\n", logPath, err)
+		} else {
+			outputs = append(outputs, logPath)
+			fmt.Printf("   [info] %s: %d status/error/system events -> %s\n",
+				filepath.Base(path), len(events), filepath.Base(logPath))
+		}
+	}
+	if defs > 0 {
+		fmt.Printf("   [info] %s: %d instrument definitions\n", filepath.Base(path), defs)
+	}
+	if count == 0 && defs == 0 && len(events) == 0 {
+		fmt.Printf("   [warn] no records written for %s\n", filepath.Base(path))
+	}
 
-			// Header area:
-			// [0]  len (u8)
-			// [1]  rtype (u8)
-			// [2:4] publisher_id (u16 LE)
-			// [4:8] instrument_id (u32 LE)
-			// [8:16] ts_event (u64 LE)
-
-			pubID := binary.LittleEndian.Uint16(rec[2:4])
-			instrID := binary.LittleEndian.Uint32(rec[4:8])
-			tsEvent := binary.LittleEndian.Uint64(rec[8:16])
-
-			// Body:
-			// [16:24] price (i64 fixed-9)
-			// [24:28] size (u32)
-			// [28]    action (char)
-			// [29]    side (char: 'B','A','N')
-			// [30]    flags (u8)
-			// [31]    depth (u8)
-			// [32:40] ts_recv (u64)
-			// [40:44] ts_in_delta (i32)
-			// [44:48] sequence (u32)
-			// [48:56] bid_px_00 (i64)
-			// [56:64] ask_px_00 (i64)
-			// [64:68] bid_sz_00 (u32)
-			// [68:72] ask_sz_00 (u32)
-			// [72:76] bid_ct_00 (u32)
-			// [76:80] ask_ct_00 (u32)
-
-			pRaw := int64(binary.LittleEndian.Uint64(rec[16:24]))
-			size := binary.LittleEndian.Uint32(rec[24:28])
-			actionChar := int8(rec[28])
-			sideChar := rec[29]
-			flags := rec[30]
-			depth := rec[31]
-
-			var s int8
-			switch sideChar {
-			case 'B':
-				s = 1
-			case 'A':
-				s = -1
-			case 'N':
-				s = 0
-			default:
-				s = 0
-			}
+	if !keep {
+		rejectOutputs(path, &rr.Stats, outputs, opts)
+		return res
+	}
+	if closeFailed {
+		discardOutputs(outputs)
+		return res
+	}
 
-			tsRecv := binary.LittleEndian.Uint64(rec[32:40])
-			tsDelta := int32(binary.LittleEndian.Uint32(rec[40:44]))
-			seq := binary.LittleEndian.Uint32(rec[44:48])
+	// Hash whatever the decoder did not need to read (e.g. zstd skippable
+	// frames) so it covers the whole file.
+	if _, err := io.Copy(io.Discard, raw); err != nil {
+		fmt.Printf("   [err] %s: %v\n", filepath.Base(path), err)
+		discardOutputs(outputs)
+		return res
+	}
+	if err := commitOutputs(outputs); err != nil {
+		fmt.Printf("   [err] %s: %v\n", filepath.Base(path), err)
+		return res
+	}
+	res.ok = true
+	res.hash = hash.Sum64()
+	res.rows = count
+	res.outputs = outputs
+	return res
+}
 
-			bpRaw := int64(binary.LittleEndian.Uint64(rec[48:56]))
-			apRaw := int64(binary.LittleEndian.Uint64(rec[56:64]))
-			bs := binary.LittleEndian.Uint32(rec[64:68])
-			as := binary.LittleEndian.Uint32(rec[68:72])
-			bc := binary.LittleEndian.Uint32(rec[72:76])
-			ac := binary.LittleEndian.Uint32(rec[76:80])
+// withinTolerance reports malformed records and whether the file's outputs
+// may be kept: at most opts.MaxBadFrac of the records are malformed and the
+// stream did not break off.
+func withinTolerance(path string, st *DBNReadStats, opts IngestOptions) bool {
+	name := filepath.Base(path)
+	if st.BadTotal() == 0 {
+		return true
+	}
+	fmt.Printf("   [warn] %s: %d malformed records (%.4f%%): %s\n",
+		name, st.BadTotal(), st.BadFrac()*100, st.Summary())
+	for i, b := range st.First {
+		if i == 5 {
+			fmt.Printf("          ... see report for more\n")
+			break
+		}
+		fmt.Printf("          %s\n", b)
+	}
+	if st.Err != nil {
+		fmt.Printf("   [err] %s: %v\n", name, st.Err)
+	}
+	return st.Err == nil && st.BadFrac() <= opts.MaxBadFrac
+}
 
-			// Skip Null/placeholder prices (Databento uses i64::MAX as sentinel)
-			if pRaw == 9223372036854775807 {
-				continue
-			}
+// rejectOutputs disposes of the staged outputs of a file over tolerance as
+// opts.OnCorrupt asks. The outputs of earlier runs stay in place.
+func rejectOutputs(path string, st *DBNReadStats, outputs []string, opts IngestOptions) {
+	name := filepath.Base(path)
+	switch opts.OnCorrupt {
+	case CorruptFail:
+		discardOutputs(outputs)
+		fmt.Printf("   [err] %s: over tolerance (%.4f%%), outputs discarded\n", name, opts.MaxBadFrac*100)
+	default:
+		if err := quarantine(path, st, outputs); err != nil {
+			discardOutputs(outputs)
+			fmt.Printf("   [err] %s: quarantine failed: %v\n", name, err)
+			return
+		}
+		fmt.Printf("   [warn] %s: over tolerance (%.4f%%), outputs moved to %s/\n", name, opts.MaxBadFrac*100, QuarantineDir)
+	}
+}
 
-			_ = enc.AddRow(
-				pubID,
-				instrID,
-				tsEvent,
-				tsRecv,
-				tsDelta,
-				pRaw,
-				size,
-				s,
-				actionChar,
-				flags,
-				depth,
-				seq,
-				bpRaw,
-				apRaw,
-				bs,
-				as,
-				bc,
-				ac,
-			)
-			count++
+// commitOutputs renames staged outputs to their final names. After a
+// failure the outputs not yet renamed are discarded.
+func commitOutputs(outputs []string) error {
+	for i, out := range outputs {
+		if err := os.Rename(out+TmpSuffix, out); err != nil {
+			discardOutputs(outputs[i:])
+			return err
 		}
+	}
+	return nil
+}
 
-		if err == io.EOF {
-			break
+// discardOutputs removes staged outputs.
+func discardOutputs(outputs []string) {
+	for _, out := range outputs {
+		os.Remove(out + TmpSuffix)
+	}
+}
+
+// quarantine moves staged outputs into QuarantineDir, out of reach of the
+// *.quantdev globs, next to a report listing the rejected records.
+func quarantine(path string, st *DBNReadStats, outputs []string) error {
+	if err := os.MkdirAll(QuarantineDir, 0o755); err != nil {
+		return err
+	}
+	for _, out := range outputs {
+		if err := os.Rename(out+TmpSuffix, filepath.Join(QuarantineDir, filepath.Base(out))); err != nil {
+			return err
 		}
 	}
 
-	if count == 0 {
-		fmt.Printf("   [warn] no TBBO records written for %s\n", filepath.Base(path))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "source: %s\nrecords: %d\nmalformed: %d (%s)\n", path, st.Records, st.BadTotal(), st.Summary())
+	if st.Err != nil {
+		fmt.Fprintf(&sb, "read error: %v\n", st.Err)
+	}
+	for _, b := range st.First {
+		fmt.Fprintf(&sb, "%s\n", b)
+	}
+	if st.BadTotal() > len(st.First) {
+		fmt.Fprintf(&sb, "... %d more\n", st.BadTotal()-len(st.First))
+	}
+	report := filepath.Join(QuarantineDir, filepath.Base(path)+".corrupt.txt")
+	return os.WriteFile(report, []byte(sb.String()), 0o644)
+}
+
+// schemaRType maps a DBN metadata schema to the rtype of its records, for
+// the schemas that have a .quantdev layout.
+func schemaRType(schema uint16) (uint8, bool) {
+	switch schema {
+	case SchemaTBBO, SchemaMBP1:
+		return RTypeTBBO, true
+	case SchemaTrades:
+		return RTypeMBP0, true
+	case SchemaMBP10:
+		return RTypeMBP10, true
+	case SchemaMBO:
+		return RTypeMBO, true
+	case SchemaOHLCV1S:
+		return RTypeOHLCV1S, true
+	case SchemaOHLCV1M:
+		return RTypeOHLCV1M, true
+	case SchemaOHLCV1H:
+		return RTypeOHLCV1H, true
+	case SchemaOHLCV1D:
+		return RTypeOHLCV1D, true
+	case SchemaOHLCVEOD:
+		return RTypeOHLCVEOD, true
+	}
+	return 0, false
+}
+
+// -----------------------------------------------------------------------------
+// Record sinks: one per .quantdev layout. Each decodes the raw DBN records of
+// its rtype and appends them to the matching encoder.
+// -----------------------------------------------------------------------------
+
+type recordSink interface {
+	RType() uint8
+	Write(rec []byte) (bool, error) // false: no row written (short, null price, MBO book update)
+	SetSource(m *DBNMetadata)
+	SetMeta(key, value string) // metadata section entry (info.go)
+	Outputs() []string         // files written so far
+	Close() error
+	Abort() // discard the outputs (gncFile.Abort)
+}
+
+// hasLayout reports whether records of rtype have a .quantdev layout.
+func hasLayout(rtype uint8) bool {
+	switch {
+	case rtype == RTypeTBBO, rtype == RTypeMBP0, rtype == RTypeMBP10, rtype == RTypeMBO:
+		return true
+	case rtype >= RTypeOHLCV1S && rtype <= RTypeOHLCVEOD:
+		return true
+	}
+	return false
+}
+
+// newRecordSink returns (nil, nil) for rtypes without a .quantdev layout.
+// Its encoders are staged: Close leaves the file under its temp name for
+// convertDBNToQuantDev to commit or reject.
+func newRecordSink(outPath string, rtype uint8, opts IngestOptions) (recordSink, error) {
+	switch {
+	case rtype == RTypeTBBO:
+		newEnc := NewEncoder
+		if opts.ExactPrices {
+			newEnc = NewEncoderExact
+		}
+		enc, err := newEnc(outPath)
+		if err != nil {
+			return nil, err
+		}
+		enc.SetCompact(opts.Compact)
+		enc.stage()
+		return tbboSink{enc}, nil
+	case rtype == RTypeMBP0:
+		enc, err := NewTradesEncoder(outPath)
+		if err != nil {
+			return nil, err
+		}
+		enc.SetCompact(opts.Compact)
+		enc.stage()
+		return tradesSink{enc}, nil
+	case rtype == RTypeMBP10:
+		enc, err := NewMBP10Encoder(outPath)
+		if err != nil {
+			return nil, err
+		}
+		enc.SetCompact(opts.Compact)
+		enc.stage()
+		return mbp10Sink{enc}, nil
+	case rtype == RTypeMBO:
+		enc, err := NewMBP10Encoder(outPath)
+		if err != nil {
+			return nil, err
+		}
+		enc.SetCompact(opts.Compact)
+		enc.stage()
+		return &mboSink{MBP10Encoder: enc, replay: NewMBOReplay()}, nil
+	case rtype >= RTypeOHLCV1S && rtype <= RTypeOHLCVEOD:
+		enc, err := NewOHLCVEncoder(outPath, rtype)
+		if err != nil {
+			return nil, err
+		}
+		enc.SetCompact(opts.Compact)
+		enc.stage()
+		return ohlcvSink{enc}, nil
+	}
+	return nil, nil
+}
+
+type tbboSink struct{ *Encoder }
+
+func (s tbboSink) Write(rec []byte) (bool, error) {
+	if len(rec) < RecSizeMBP1 {
+		return false, nil
+	}
+	ev := decodeTradeEvent(rec)
+	// Skip Null/placeholder prices (Databento uses i64::MAX as sentinel)
+	if ev.PxRaw == NullPrice {
+		return false, nil
+	}
+	lv := decodeBookLevel(rec[48:80])
+
+	return true, s.AddRow(
+		ev.PubID,
+		ev.InstrID,
+		ev.TsEvent,
+		ev.TsRecv,
+		ev.TsInDelta,
+		ev.PxRaw,
+		ev.Size,
+		ev.Side,
+		ev.Action,
+		ev.Flags,
+		ev.Depth,
+		ev.Seq,
+		lv.BidPxRaw,
+		lv.AskPxRaw,
+		lv.BidSz,
+		lv.AskSz,
+		lv.BidCt,
+		lv.AskCt,
+	)
+}
+
+type tradesSink struct{ *TradesEncoder }
+
+func (s tradesSink) Write(rec []byte) (bool, error) {
+	if len(rec) < RecSizeMBP0 {
+		return false, nil
+	}
+	ev := decodeTradeEvent(rec)
+	if ev.PxRaw == NullPrice {
+		return false, nil
+	}
+	return true, s.AddRow(&ev)
+}
+
+type mbp10Sink struct{ *MBP10Encoder }
+
+func (s mbp10Sink) Write(rec []byte) (bool, error) {
+	if len(rec) < RecSizeMBP10 {
+		return false, nil
+	}
+	ev := decodeTradeEvent(rec)
+	if ev.PxRaw == NullPrice {
+		return false, nil
+	}
+	var levels [MBP10Levels]BookLevel
+	for l := range levels {
+		off := 48 + l*32
+		levels[l] = decodeBookLevel(rec[off : off+32])
+	}
+	return true, s.AddRow(&ev, &levels)
+}
+
+// mboSink rebuilds the order books from MBO messages and writes one MBP-10
+// row per trade: the trade plus the ten best levels as it printed. The output
+// loads like any MBP-10 file, so LoadQuantDevTBBO feeds it to RunStrategy.
+type mboSink struct {
+	*MBP10Encoder
+	replay *MBOReplay
+	shared bool // replay belongs to a splitSink, which reports it
+	levels [MBP10Levels]BookLevel
+}
+
+func (s *mboSink) RType() uint8 {
+	return RTypeMBO
+}
+
+// Write reports true only for trades, which emit a row; other messages
+// update the book and report false like a skipped record (the caller tells
+// them apart by size).
+func (s *mboSink) Write(rec []byte) (bool, error) {
+	if len(rec) < RecSizeMBO {
+		return false, nil
+	}
+	m := decodeMBOMsg(rec)
+	if !s.replay.Apply(&m, s.levels[:]) {
+		return false, nil
+	}
+	ev := m.TradeEvent()
+	return true, s.AddRow(&ev, &s.levels)
+}
+
+func (s *mboSink) Close() error {
+	if !s.shared {
+		reportUnknown(s.replay)
+	}
+	return s.MBP10Encoder.Close()
+}
+
+func reportUnknown(r *MBOReplay) {
+	if n := r.Unknown(); n > 0 {
+		fmt.Printf("   [info] %d MBO updates referenced orders not on the book\n", n)
+	}
+}
+
+type ohlcvSink struct{ *OHLCVEncoder }
+
+func (s ohlcvSink) Write(rec []byte) (bool, error) {
+	if len(rec) < RecSizeOHLCV {
+		return false, nil
+	}
+	bar := decodeOHLCVBar(rec)
+	return true, s.AddRow(&bar)
+}
+
+// decodeTradeEvent reads the record header and the event body shared by
+// trades, MBP-1/TBBO and MBP-10 records.
+func decodeTradeEvent(rec []byte) TradeEvent {
+	// Header area:
+	// [0]  len (u8)
+	// [1]  rtype (u8)
+	// [2:4] publisher_id (u16 LE)
+	// [4:8] instrument_id (u32 LE)
+	// [8:16] ts_event (u64 LE)
+
+	// Body:
+	// [16:24] price (i64 fixed-9)
+	// [24:28] size (u32)
+	// [28]    action (char)
+	// [29]    side (char: 'B','A','N')
+	// [30]    flags (u8)
+	// [31]    depth (u8)
+	// [32:40] ts_recv (u64)
+	// [40:44] ts_in_delta (i32)
+	// [44:48] sequence (u32)
+	_ = rec[47]
+
+	var s int8
+	switch rec[29] {
+	case 'B':
+		s = 1
+	case 'A':
+		s = -1
+	case 'N':
+		s = 0
+	default:
+		s = 0
+	}
+
+	return TradeEvent{
+		PubID:     binary.LittleEndian.Uint16(rec[2:4]),
+		InstrID:   binary.LittleEndian.Uint32(rec[4:8]),
+		TsEvent:   binary.LittleEndian.Uint64(rec[8:16]),
+		PxRaw:     int64(binary.LittleEndian.Uint64(rec[16:24])),
+		Size:      binary.LittleEndian.Uint32(rec[24:28]),
+		Action:    int8(rec[28]),
+		Side:      s,
+		Flags:     rec[30],
+		Depth:     rec[31],
+		TsRecv:    binary.LittleEndian.Uint64(rec[32:40]),
+		TsInDelta: int32(binary.LittleEndian.Uint32(rec[40:44])),
+		Seq:       binary.LittleEndian.Uint32(rec[44:48]),
+	}
+}
+
+// decodeBookLevel reads one 32-byte book level:
+// [0:8] bid_px (i64), [8:16] ask_px (i64), [16:20] bid_sz, [20:24] ask_sz,
+// [24:28] bid_ct, [28:32] ask_ct (all u32).
+func decodeBookLevel(b []byte) BookLevel {
+	_ = b[31]
+	return BookLevel{
+		BidPxRaw: int64(binary.LittleEndian.Uint64(b[0:8])),
+		AskPxRaw: int64(binary.LittleEndian.Uint64(b[8:16])),
+		BidSz:    binary.LittleEndian.Uint32(b[16:20]),
+		AskSz:    binary.LittleEndian.Uint32(b[20:24]),
+		BidCt:    binary.LittleEndian.Uint32(b[24:28]),
+		AskCt:    binary.LittleEndian.Uint32(b[28:32]),
+	}
+}
+
+// decodeOHLCVBar reads an OHLCV record:
+// [16:24] open, [24:32] high, [32:40] low, [40:48] close (i64 fixed-9),
+// [48:56] volume (u64).
+func decodeOHLCVBar(rec []byte) OHLCVBar {
+	_ = rec[55]
+	return OHLCVBar{
+		PubID:    binary.LittleEndian.Uint16(rec[2:4]),
+		InstrID:  binary.LittleEndian.Uint32(rec[4:8]),
+		TsEvent:  binary.LittleEndian.Uint64(rec[8:16]),
+		OpenRaw:  int64(binary.LittleEndian.Uint64(rec[16:24])),
+		HighRaw:  int64(binary.LittleEndian.Uint64(rec[24:32])),
+		LowRaw:   int64(binary.LittleEndian.Uint64(rec[32:40])),
+		CloseRaw: int64(binary.LittleEndian.Uint64(rec[40:48])),
+		Volume:   binary.LittleEndian.Uint64(rec[48:56]),
 	}
 }
@@ -0,0 +1,533 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// DBN metadata block (v1, v2, v3).
+//
+// Layout after the 8-byte prefix ["DBN"][version u8][length u32 LE]:
+//
+//	[0:16]  dataset (char[16], NUL padded)
+//	[16:18] schema (u16, 0xFFFF = mixed)
+//	[18:26] start (u64 ns)
+//	[26:34] end (u64 ns, exclusive)
+//	[34:42] limit (u64, 0 = none)
+//	v1 only: record_count (u64, deprecated)
+//	        stype_in (u8, 0xFF = mixed), stype_out (u8), ts_out (u8)
+//	v2+ only: symbol_cstr_len (u16)
+//	        reserved padding up to byte 100
+//	[100:104] schema_definition_length (u32, always 0)
+//	then: symbols, partial, not_found (u32 count + fixed-width cstrs)
+//	then: mappings (u32 count + {raw_symbol, u32 n, n x {start u32, end u32, symbol}})
+//
+// v3 shares the v2 metadata layout; the differences are in record bodies.
+// -----------------------------------------------------------------------------
+
+const (
+	DBNMaxVersion = 3
+
+	dbnPrefixLen      = 8
+	dbnMetaFixedLen   = 100
+	dbnDatasetLen     = 16
+	dbnSymbolLenV1    = 22
+	dbnReservedLenV1  = 47
+	dbnReservedLenV2  = 53
+	DBNNullSchema     = 0xFFFF
+	DBNNullSType      = 0xFF
+	dbnMaxMetaListLen = 1 << 24 // sanity cap on repeated fields
+)
+
+// DBN schema IDs (metadata.schema).
+const (
+	SchemaMBO = iota
+	SchemaMBP1
+	SchemaMBP10
+	SchemaTBBO
+	SchemaTrades
+	SchemaOHLCV1S
+	SchemaOHLCV1M
+	SchemaOHLCV1H
+	SchemaOHLCV1D
+	SchemaDefinition
+	SchemaStatistics
+	SchemaStatus
+	SchemaImbalance
+	SchemaOHLCVEOD
+)
+
+var dbnSchemaNames = [...]string{
+	"mbo", "mbp-1", "mbp-10", "tbbo", "trades",
+	"ohlcv-1s", "ohlcv-1m", "ohlcv-1h", "ohlcv-1d",
+	"definition", "statistics", "status", "imbalance", "ohlcv-eod",
+}
+
+// DBN symbology types (metadata.stype_in / stype_out).
+var dbnSTypeNames = [...]string{
+	"instrument_id", "raw_symbol", "smart", "continuous", "parent",
+	"nasdaq_symbol", "cms_symbol", "isin", "us_code",
+	"bbg_comp_id", "bbg_comp_ticker", "figi", "figi_ticker",
+}
+
+// DBNMapping is one date interval of a symbol mapping. Dates are YYYYMMDD;
+// StartDate is inclusive, EndDate exclusive.
+type DBNMapping struct {
+	StartDate uint32
+	EndDate   uint32
+	Symbol    string // usually the instrument_id rendered as text
+}
+
+type DBNSymbolMapping struct {
+	RawSymbol string
+	Intervals []DBNMapping
+}
+
+// DBNMetadata is the decoded DBN file header.
+type DBNMetadata struct {
+	Version  uint8
+	Dataset  string
+	Schema   uint16 // DBNNullSchema for mixed-schema files
+	Start    uint64 // ns since UNIX epoch
+	End      uint64 // ns since UNIX epoch (exclusive)
+	Limit    uint64 // 0 = no limit
+	StypeIn  uint8  // DBNNullSType for mixed
+	StypeOut uint8
+	TsOut    bool // records carry a trailing 8-byte ts_out
+
+	SymbolLen int
+
+	Symbols  []string
+	Partial  []string
+	NotFound []string
+	Mappings []DBNSymbolMapping
+
+	streamLen int64 // prefix + metadata bytes, i.e. offset of the first record
+}
+
+func (m *DBNMetadata) SchemaName() string {
+	return dbnSchemaName(m.Schema)
+}
+
+func dbnSchemaName(s uint16) string {
+	if s == DBNNullSchema {
+		return "mixed"
+	}
+	if int(s) < len(dbnSchemaNames) {
+		return dbnSchemaNames[s]
+	}
+	return fmt.Sprintf("schema(%d)", s)
+}
+
+func dbnSTypeName(s uint8) string {
+	if s == DBNNullSType {
+		return "mixed"
+	}
+	if int(s) < len(dbnSTypeNames) {
+		return dbnSTypeNames[s]
+	}
+	return fmt.Sprintf("stype(%d)", s)
+}
+
+// Summary renders a one-line description used by the CLI.
+func (m *DBNMetadata) Summary() string {
+	return fmt.Sprintf("dbn v%d %s %s [%s, %s) stype %s->%s symbols=%d",
+		m.Version, m.Dataset, m.SchemaName(),
+		fmtNanos(m.Start), fmtNanos(m.End),
+		dbnSTypeName(m.StypeIn), dbnSTypeName(m.StypeOut), len(m.Symbols))
+}
+
+func fmtNanos(ns uint64) string {
+	if ns == 0 || ns == ^uint64(0) {
+		return "-"
+	}
+	return time.Unix(0, int64(ns)).UTC().Format(time.RFC3339)
+}
+
+// ReadDBNMetadata consumes the DBN prefix and metadata block from br.
+// Returns (nil, nil) when the stream does not start with the DBN magic so that
+// callers can fall back to treating the input as bare records.
+func ReadDBNMetadata(br *bufio.Reader) (*DBNMetadata, error) {
+	prefix, err := br.Peek(dbnPrefixLen)
+	if err != nil || string(prefix[0:3]) != DBNMagic {
+		return nil, nil
+	}
+
+	version := prefix[3]
+	metaLen := binary.LittleEndian.Uint32(prefix[4:8])
+	if version == 0 || version > DBNMaxVersion {
+		return nil, fmt.Errorf("unsupported DBN version %d", version)
+	}
+	if metaLen < dbnMetaFixedLen+4 {
+		return nil, fmt.Errorf("DBN metadata too short: %d bytes", metaLen)
+	}
+	if _, err := br.Discard(dbnPrefixLen); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, metaLen)
+	if _, err := io.ReadFull(br, buf); err != nil {
+		return nil, fmt.Errorf("reading DBN metadata: %w", err)
+	}
+	m, err := decodeDBNMetadata(version, buf)
+	if m != nil {
+		m.streamLen = dbnPrefixLen + int64(metaLen)
+	}
+	return m, err
+}
+
+func decodeDBNMetadata(version uint8, buf []byte) (*DBNMetadata, error) {
+	c := &dbnCursor{buf: buf}
+	m := &DBNMetadata{Version: version}
+
+	m.Dataset = c.cstr(dbnDatasetLen)
+	m.Schema = c.u16()
+	m.Start = c.u64()
+	m.End = c.u64()
+	m.Limit = c.u64()
+	if version == 1 {
+		c.skip(8) // record_count
+	}
+	m.StypeIn = c.u8()
+	m.StypeOut = c.u8()
+	m.TsOut = c.u8() != 0
+
+	if version == 1 {
+		m.SymbolLen = dbnSymbolLenV1
+		c.skip(dbnReservedLenV1)
+	} else {
+		m.SymbolLen = int(c.u16())
+		c.skip(dbnReservedLenV2)
+	}
+	if c.err == nil && m.SymbolLen == 0 {
+		return nil, fmt.Errorf("DBN metadata: zero symbol_cstr_len")
+	}
+
+	if schemaDefLen := c.u32(); schemaDefLen != 0 {
+		return nil, fmt.Errorf("DBN metadata: schema definitions not supported (len=%d)", schemaDefLen)
+	}
+
+	m.Symbols = c.symbolList(m.SymbolLen)
+	m.Partial = c.symbolList(m.SymbolLen)
+	m.NotFound = c.symbolList(m.SymbolLen)
+
+	nMap := c.count()
+	if nMap > 0 {
+		m.Mappings = make([]DBNSymbolMapping, 0, nMap)
+	}
+	for i := 0; i < nMap && c.err == nil; i++ {
+		sm := DBNSymbolMapping{RawSymbol: c.cstr(m.SymbolLen)}
+		nIv := c.count()
+		if nIv > 0 {
+			sm.Intervals = make([]DBNMapping, 0, nIv)
+		}
+		for j := 0; j < nIv && c.err == nil; j++ {
+			sm.Intervals = append(sm.Intervals, DBNMapping{
+				StartDate: c.u32(),
+				EndDate:   c.u32(),
+				Symbol:    c.cstr(m.SymbolLen),
+			})
+		}
+		m.Mappings = append(m.Mappings, sm)
+	}
+
+	if c.err != nil {
+		return nil, fmt.Errorf("DBN metadata: %w", c.err)
+	}
+	return m, nil
+}
+
+// dbnCursor is a bounds-checked little-endian reader over a byte slice.
+// The first out-of-range access latches err; later reads return zero values.
+type dbnCursor struct {
+	buf []byte
+	pos int
+	err error
+}
+
+func (c *dbnCursor) take(n int) []byte {
+	if c.err != nil {
+		return nil
+	}
+	if n < 0 || c.pos+n > len(c.buf) {
+		c.err = fmt.Errorf("truncated at byte %d (need %d, have %d)", c.pos, n, len(c.buf)-c.pos)
+		return nil
+	}
+	b := c.buf[c.pos : c.pos+n]
+	c.pos += n
+	return b
+}
+
+func (c *dbnCursor) skip(n int) { c.take(n) }
+
+func (c *dbnCursor) u8() uint8 {
+	if b := c.take(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (c *dbnCursor) u16() uint16 {
+	if b := c.take(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (c *dbnCursor) u32() uint32 {
+	if b := c.take(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (c *dbnCursor) u64() uint64 {
+	if b := c.take(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+// cstr reads a fixed-width NUL-padded C string.
+func (c *dbnCursor) cstr(n int) string {
+	b := c.take(n)
+	for i, ch := range b {
+		if ch == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// count reads a u32 list length and rejects absurd values before allocation.
+func (c *dbnCursor) count() int {
+	n := c.u32()
+	if c.err == nil && n > dbnMaxMetaListLen {
+		c.err = fmt.Errorf("list length %d at byte %d exceeds limit", n, c.pos-4)
+		return 0
+	}
+	return int(n)
+}
+
+func (c *dbnCursor) symbolList(symLen int) []string {
+	n := c.count()
+	if n == 0 {
+		return nil
+	}
+	out := make([]string, 0, n)
+	for i := 0; i < n && c.err == nil; i++ {
+		out = append(out, c.cstr(symLen))
+	}
+	return out
+}
+
+// -----------------------------------------------------------------------------
+// Record framing. Every DBN record starts with a 16-byte header whose first
+// byte is the record length in 4-byte words and whose second is the rtype.
+// DBNRecordReader checks that length against the sizes each rtype can have,
+// skips what does not fit and keeps the byte offset of every rejected record.
+// -----------------------------------------------------------------------------
+
+// Reasons a record is dropped by the framing layer.
+const (
+	BadZeroLength = iota // length byte 0: stray padding or garbage
+	BadSize              // length does not match the rtype
+	BadTruncated         // stream ends inside a record
+	BadReadError         // the underlying reader failed (e.g. corrupt .zst)
+	numBadReasons
+)
+
+var badReasonNames = [numBadReasons]string{"zero_length", "bad_size", "truncated", "read_error"}
+
+const dbnRecordHeaderLen = 16
+
+// dbnRecordSizes lists the valid record sizes per rtype across DBN v1-v3,
+// without the optional ts_out suffix. Rtypes not listed are passed through
+// after the header check.
+var dbnRecordSizes = map[uint8][]int{
+	RTypeMBP0:       {RecSizeMBP0},
+	RTypeTBBO:       {RecSizeMBP1},
+	RTypeMBP10:      {RecSizeMBP10},
+	0x11:            {RecSizeOHLCV}, // deprecated OHLCV rtype
+	RTypeOHLCV1S:    {RecSizeOHLCV},
+	RTypeOHLCV1M:    {RecSizeOHLCV},
+	RTypeOHLCV1H:    {RecSizeOHLCV},
+	RTypeOHLCV1D:    {RecSizeOHLCV},
+	RTypeOHLCVEOD:   {RecSizeOHLCV},
+	RTypeStatus:     {RecSizeStatus},
+	RTypeDefinition: {360, 400, 520},
+	0x14:            {112},     // imbalance
+	RTypeError:      {80, 320}, // v1, v2+
+	0x16:            {80, 176}, // symbol mapping v1, v2+
+	RTypeSystem:     {80, 320}, // v1, v2+
+	0x18:            {64, 80},  // statistics v1/v2, v3
+	RTypeMBO:        {RecSizeMBO},
+}
+
+// BadRecord locates one rejected record. Offset counts bytes of the
+// decompressed stream, metadata included.
+type BadRecord struct {
+	Offset int64
+	RType  uint8
+	Size   int
+	Reason int
+}
+
+func (b BadRecord) String() string {
+	return fmt.Sprintf("@%d %s (rtype 0x%02X, %d bytes)", b.Offset, badReasonNames[b.Reason], b.RType, b.Size)
+}
+
+// DBNReadStats summarises what the framing layer saw.
+type DBNReadStats struct {
+	Records int                // well-formed records returned by Next
+	Bad     [numBadReasons]int // rejected records by reason
+	First   []BadRecord        // the first MaxBadRecords rejections
+	Err     error              // read error that ended the stream, if any
+}
+
+// MaxBadRecords caps DBNReadStats.First.
+const MaxBadRecords = 32
+
+func (s *DBNReadStats) BadTotal() int {
+	n := 0
+	for _, c := range s.Bad {
+		n += c
+	}
+	return n
+}
+
+// BadFrac is the share of rejected records among all framed records.
+func (s *DBNReadStats) BadFrac() float64 {
+	bad := s.BadTotal()
+	if bad == 0 {
+		return 0
+	}
+	return float64(bad) / float64(s.Records+bad)
+}
+
+// Summary renders the rejection counts, e.g. "bad_size=3 truncated=1".
+func (s *DBNReadStats) Summary() string {
+	var parts []string
+	for r, c := range s.Bad {
+		if c > 0 {
+			parts = append(parts, fmt.Sprintf("%s=%d", badReasonNames[r], c))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+type DBNRecordReader struct {
+	br     *bufio.Reader
+	tsOut  int   // 8 when records carry ts_out, -1 when unknown (no metadata)
+	offset int64 // stream offset of the next byte
+	inZero bool  // inside a run of zero length bytes
+	Stats  DBNReadStats
+}
+
+// NewDBNRecordReader frames the records following meta (nil for bare record
+// streams, in which case either ts_out variant is accepted).
+func NewDBNRecordReader(br *bufio.Reader, meta *DBNMetadata) *DBNRecordReader {
+	r := &DBNRecordReader{br: br, tsOut: -1}
+	if meta != nil {
+		r.offset = meta.streamLen
+		r.tsOut = 0
+		if meta.TsOut {
+			r.tsOut = 8
+		}
+	}
+	return r
+}
+
+func (r *DBNRecordReader) reject(rtype uint8, size, reason int) {
+	r.Stats.Bad[reason]++
+	if len(r.Stats.First) < MaxBadRecords {
+		r.Stats.First = append(r.Stats.First, BadRecord{Offset: r.offset, RType: rtype, Size: size, Reason: reason})
+	}
+}
+
+func (r *DBNRecordReader) validSize(rtype uint8, size int) bool {
+	sizes, known := dbnRecordSizes[rtype]
+	if !known {
+		return size >= dbnRecordHeaderLen
+	}
+	for _, want := range sizes {
+		switch r.tsOut {
+		case -1:
+			if size == want || size == want+8 {
+				return true
+			}
+		default:
+			if size == want+r.tsOut {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next returns the next well-formed record. The slice is only valid until the
+// following call. At the end of the stream it returns io.EOF; a read error is
+// recorded in Stats.Err and also ends the stream.
+func (r *DBNRecordReader) Next() ([]byte, error) {
+	for {
+		head, err := r.br.Peek(2)
+		if len(head) == 0 || (len(head) == 1 && head[0] != 0) {
+			return nil, r.end(err, head)
+		}
+
+		size := int(head[0]) * 4
+		if size == 0 {
+			// Count a run of zero bytes once, at its first offset.
+			if !r.inZero {
+				r.reject(0, 0, BadZeroLength)
+				r.inZero = true
+			}
+			r.br.Discard(1)
+			r.offset++
+			continue
+		}
+		r.inZero = false
+		if len(head) < 2 {
+			return nil, r.end(err, head)
+		}
+
+		rtype := head[1]
+		rec, err := r.br.Peek(size)
+		if len(rec) < size {
+			return nil, r.end(err, rec)
+		}
+		if !r.validSize(rtype, size) {
+			r.reject(rtype, size, BadSize)
+			r.br.Discard(size)
+			r.offset += int64(size)
+			continue
+		}
+
+		r.br.Discard(size)
+		r.offset += int64(size)
+		r.Stats.Records++
+		return rec, nil
+	}
+}
+
+// end classifies the bytes left over when the stream stops.
+func (r *DBNRecordReader) end(err error, tail []byte) error {
+	if err != nil && err != io.EOF {
+		r.Stats.Err = err
+		r.reject(0, len(tail), BadReadError)
+		return io.EOF
+	}
+	if len(tail) > 0 {
+		rtype := uint8(0)
+		if len(tail) > 1 {
+			rtype = tail[1]
+		}
+		r.reject(rtype, len(tail), BadTruncated)
+	}
+	return io.EOF
+}
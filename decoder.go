@@ -2,47 +2,76 @@ package main
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"iter"
+	"math"
+	"math/bits"
 	"os"
-	"sync"
-	"weak" // Go 1.24+ feature
+	"slices"
+	"strings"
 )
 
-var (
-	sharedCacheMu sync.Mutex
-	// Use weak.Pointer to allow GC to reclaim cache if pressure is high
-	sharedCache = make(map[string]weak.Pointer[TBBOColumns])
-)
-
-func LoadQuantDevShared(path string) (*TBBOColumns, error) {
-	sharedCacheMu.Lock()
-	defer sharedCacheMu.Unlock()
-
-	if wp, ok := sharedCache[path]; ok {
-		if ptr := wp.Value(); ptr != nil {
-			return ptr, nil
-		}
-		delete(sharedCache, path) // GC collected it
-	}
-
-	cols := &TBBOColumns{}
+func LoadQuantDev(path string) (*TBBOColumns, error) {
+	cols := TBBOPool.Get().(*TBBOColumns)
 	if err := loadFromFile(path, cols); err != nil {
+		TBBOPool.Put(cols)
 		return nil, err
 	}
-	sharedCache[path] = weak.Make(cols)
 	return cols, nil
 }
 
-func LoadQuantDev(path string) (*TBBOColumns, error) {
+// LoadQuantDevRange loads only the rows of a TBBO file with TsEvent in
+// [tsStart, tsEnd). Chunks outside the window are never read.
+func LoadQuantDevRange(path string, tsStart, tsEnd uint64) (*TBBOColumns, error) {
+	return LoadQuantDevQuery(path, TBBOQuery{TsStart: tsStart, TsEnd: tsEnd})
+}
+
+// LoadQuantDevQuery loads the rows and columns of a TBBO file that q selects.
+func LoadQuantDevQuery(path string, q TBBOQuery) (*TBBOColumns, error) {
 	cols := TBBOPool.Get().(*TBBOColumns)
-	if err := loadFromFile(path, cols); err != nil {
+	if err := loadQueryFromFile(path, cols, q); err != nil {
 		TBBOPool.Put(cols)
 		return nil, err
 	}
 	return cols, nil
 }
 
+// LoadQuantDevTBBO loads any layout that carries a top of book as TBBO
+// columns: TBBO files directly, MBP-10 files through their level 0. MBP-10
+// files are read whole; q's time window still applies but its column set
+// does not.
+func LoadQuantDevTBBO(path string, q TBBOQuery) (*TBBOColumns, error) {
+	hdr, err := ReadQuantDevHeader(path)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Layout != LayoutMBP10 {
+		return LoadQuantDevQuery(path, q)
+	}
+	book, err := LoadMBP10(path)
+	if err != nil {
+		return nil, err
+	}
+	cols := TBBOPool.Get().(*TBBOColumns)
+	book.TopOfBook(cols)
+	if tsStart, tsEnd, ok := q.window(); ok {
+		cols.trimTime(tsStart, tsEnd)
+	}
+	return cols, nil
+}
+
+// ReadQuantDevHeader reads only the fixed header of a .quantdev file.
+func ReadQuantDevHeader(path string) (gncHeader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return gncHeader{}, err
+	}
+	defer f.Close()
+	return readGNCHeader(f)
+}
+
 // readFullInto reads exactly len(buf) elements of type T into buf.
 func readFullInto[T any](r io.Reader, buf []T) error {
 	if len(buf) == 0 {
@@ -52,188 +81,822 @@ func readFullInto[T any](r io.Reader, buf []T) error {
 	return err
 }
 
-func loadFromFile(path string, cols *TBBOColumns) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
+// gncHeader is the decoded 64-byte .quantdev header (layout in encoder.go).
+type gncHeader struct {
+	Layout     uint16
+	RType      uint8
+	PriceEnc   uint8
+	PriceScale float64
+	Rows       int
+	FooterPos  uint64
+	SourcePos  uint64
+	ZonePos    uint64
+	DirPos     uint64
+	MetaPos    uint64 // metadata section (info.go), 0 = none
+	Version    uint16 // FormatImplicit .. FormatVersion
+}
 
+func readGNCHeader(r io.Reader) (gncHeader, error) {
 	header := make([]byte, 64)
-	if _, err := io.ReadFull(f, header); err != nil {
-		return fmt.Errorf("bad header: %w", err)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return gncHeader{}, fmt.Errorf("bad header: %w", err)
 	}
 
-	if string(header[0:4]) != MagicGNC {
-		return fmt.Errorf("unsupported quantdev magic %q (expected %q); re-run data conversion",
+	switch string(header[0:4]) {
+	case MagicGNC:
+	case "GNC3":
+		return gncHeader{}, fmt.Errorf("GNC3 file (dictionary/delta format) is no longer readable; re-run data conversion")
+	default:
+		return gncHeader{}, fmt.Errorf("unsupported quantdev magic %q (expected %q); re-run data conversion",
 			header[0:4], MagicGNC)
 	}
 
+	// Files before the version field have zeros there.
+	version := binary.LittleEndian.Uint16(header[56:58])
+	if version == 0 {
+		version = FormatImplicit
+	}
+	if version > FormatVersion {
+		return gncHeader{}, fmt.Errorf("quantdev format %d is newer than this build reads (%d)", version, FormatVersion)
+	}
+
 	totalRows := binary.LittleEndian.Uint64(header[8:16])
-	// footerPos := binary.LittleEndian.Uint64(header[24:32]) // currently unused
 
 	// Defensive: avoid overflowing int on weird files.
 	maxInt := uint64(^uint(0) >> 1)
 	if totalRows > maxInt {
-		return fmt.Errorf("quantdev file too large: %d rows", totalRows)
+		return gncHeader{}, fmt.Errorf("quantdev file too large: %d rows", totalRows)
 	}
-	nRows := int(totalRows)
 
-	cols.Reset()
+	return gncHeader{
+		Layout:     binary.LittleEndian.Uint16(header[4:6]),
+		RType:      header[6],
+		PriceEnc:   header[7],
+		PriceScale: math.Float64frombits(binary.LittleEndian.Uint64(header[16:24])),
+		Rows:       int(totalRows),
+		FooterPos:  binary.LittleEndian.Uint64(header[24:32]),
+		SourcePos:  binary.LittleEndian.Uint64(header[32:40]),
+		ZonePos:    binary.LittleEndian.Uint64(header[40:48]),
+		DirPos:     binary.LittleEndian.Uint64(header[48:56]),
+		MetaPos:    getU48(header[58:64]),
+		Version:    version,
+	}, nil
+}
 
-	// -------------------------------------------------------------------------
-	// Critical change: reuse pooled backing arrays instead of allocating fresh.
-	// -------------------------------------------------------------------------
-	if nRows > 0 {
-		cols.PublisherID = resize(cols.PublisherID, nRows)
-		cols.InstrumentID = resize(cols.InstrumentID, nRows)
-
-		cols.TsEvent = resize(cols.TsEvent, nRows)
-		cols.TsRecv = resize(cols.TsRecv, nRows)
-		cols.TsInDelta = resize(cols.TsInDelta, nRows)
-
-		cols.Prices = resize(cols.Prices, nRows)
-		cols.Sizes = resize(cols.Sizes, nRows)
-		cols.Sides = resize(cols.Sides, nRows)
-		cols.Actions = resize(cols.Actions, nRows)
-		cols.Flags = resize(cols.Flags, nRows)
-		cols.Depth = resize(cols.Depth, nRows)
-		cols.Sequences = resize(cols.Sequences, nRows)
-
-		cols.BidPx = resize(cols.BidPx, nRows)
-		cols.AskPx = resize(cols.AskPx, nRows)
-		cols.BidSz = resize(cols.BidSz, nRows)
-		cols.AskSz = resize(cols.AskSz, nRows)
-		cols.BidCt = resize(cols.BidCt, nRows)
-		cols.AskCt = resize(cols.AskCt, nRows)
-	} else {
-		// Ensure zero-length slices if file is empty.
-		cols.PublisherID = cols.PublisherID[:0]
-		cols.InstrumentID = cols.InstrumentID[:0]
-		cols.TsEvent = cols.TsEvent[:0]
-		cols.TsRecv = cols.TsRecv[:0]
-		cols.TsInDelta = cols.TsInDelta[:0]
-		cols.Prices = cols.Prices[:0]
-		cols.Sizes = cols.Sizes[:0]
-		cols.Sides = cols.Sides[:0]
-		cols.Actions = cols.Actions[:0]
-		cols.Flags = cols.Flags[:0]
-		cols.Depth = cols.Depth[:0]
-		cols.Sequences = cols.Sequences[:0]
-		cols.BidPx = cols.BidPx[:0]
-		cols.AskPx = cols.AskPx[:0]
-		cols.BidSz = cols.BidSz[:0]
-		cols.AskSz = cols.AskSz[:0]
-		cols.BidCt = cols.BidCt[:0]
-		cols.AskCt = cols.AskCt[:0]
-	}
-
-	// After header, all chunks are laid out as:
-	// [u32 n][columns for n rows...], repeated, then footer index.
-	if _, err := f.Seek(64, io.SeekStart); err != nil {
-		return err
+// getU48 is the inverse of putU48 (encoder.go).
+func getU48(b []byte) uint64 {
+	var w [8]byte
+	copy(w[:6], b[:6])
+	return binary.LittleEndian.Uint64(w[:])
+}
+
+// sectionEnd returns where the footer section starting at pos ends: at the
+// next section the header points to, or at the end of the file.
+func (h gncHeader) sectionEnd(pos, size uint64) uint64 {
+	end := size
+	for _, p := range []uint64{h.FooterPos, h.ZonePos, h.SourcePos, h.DirPos, h.MetaPos} {
+		if p > pos && p < end {
+			end = p
+		}
 	}
+	return end
+}
 
-	var lenBuf [4]byte
-	pos := 0
+func (h gncHeader) LayoutName() string {
+	if int(h.Layout) < len(layoutNames) {
+		return layoutNames[h.Layout]
+	}
+	return fmt.Sprintf("layout(%d)", h.Layout)
+}
 
-	for pos < nRows {
-		if _, err := io.ReadFull(f, lenBuf[:]); err != nil {
-			return fmt.Errorf("reading chunk length: %w", err)
-		}
-		n := int(binary.LittleEndian.Uint32(lenBuf[:]))
-		if n == 0 {
-			continue
+func (h gncHeader) expectLayout(want uint16) error {
+	if h.Layout != want {
+		return fmt.Errorf("quantdev file holds %s rows, not %s", h.LayoutName(), layoutNames[want])
+	}
+	return nil
+}
+
+// openGNC opens a .quantdev file, validates its header against the expected
+// column layout and reads its chunk index and column directory.
+func openGNC(path string, layout uint16) (*os.File, *QuantDevIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	x, err := readQuantDevIndex(f)
+	if err == nil {
+		err = x.Header.expectLayout(layout)
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, x, nil
+}
+
+// =============================================================================
+//  Chunk index
+// =============================================================================
+
+// ChunkInfo describes one chunk of a .quantdev file. Files written with zone
+// maps describe every chunk in the footer; for older files the time bounds
+// are the TsEvent of the chunk's first and last row (every layout stores
+// TsEvent first, and rows are written in DBN order) and nothing else is known.
+type ChunkInfo struct {
+	Offset   uint64 // file offset of the chunk's u32 row count
+	FirstRow int    // index of the chunk's first row in the file
+	Rows     int
+
+	TsMin uint64
+	TsMax uint64
+
+	// Zone map only.
+	Zoned       bool
+	PxMin       float64  // NaN when the chunk has no priced rows
+	PxMax       float64  // (OHLCV: lowest low, highest high)
+	Instruments []uint32 // distinct, ascending
+}
+
+func (c *ChunkInfo) overlaps(tsStart, tsEnd uint64) bool {
+	return c.Rows > 0 && c.TsMax >= tsStart && c.TsMin < tsEnd
+}
+
+// HasInstrument reports whether the chunk may hold rows of instrument id.
+func (c *ChunkInfo) HasInstrument(id uint32) bool {
+	if !c.Zoned {
+		return true
+	}
+	_, found := slices.BinarySearch(c.Instruments, id)
+	return found
+}
+
+// PricesIn reports whether the chunk may hold prices in [lo, hi].
+func (c *ChunkInfo) PricesIn(lo, hi float64) bool {
+	if !c.Zoned {
+		return true
+	}
+	return c.PxMax >= lo && c.PxMin <= hi
+}
+
+// QuantDevIndex is the header, footer chunk index and column directory of a
+// .quantdev file.
+type QuantDevIndex struct {
+	Header  gncHeader
+	Chunks  []ChunkInfo
+	Columns *ColumnDir
+}
+
+// ReadQuantDevIndex reads the header, chunk index and column directory of
+// any .quantdev layout.
+func ReadQuantDevIndex(path string) (*QuantDevIndex, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readQuantDevIndex(f)
+}
+
+func readQuantDevIndex(f *os.File) (*QuantDevIndex, error) {
+	hdr, err := readGNCHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	chunks, err := readGNCIndex(f, hdr)
+	if err != nil {
+		return nil, err
+	}
+	dir, err := readColumnDir(f, hdr, chunks)
+	if err != nil {
+		return nil, err
+	}
+	return &QuantDevIndex{Header: hdr, Chunks: chunks, Columns: dir}, nil
+}
+
+// Overlapping returns the contiguous run of chunks that may hold rows with
+// TsEvent in [tsStart, tsEnd).
+func (x *QuantDevIndex) Overlapping(tsStart, tsEnd uint64) []ChunkInfo {
+	lo, hi := chunkSpan(x.Chunks, tsStart, tsEnd)
+	return x.Chunks[lo:hi]
+}
+
+// TimeRange returns the bounds of the file's TsEvent from its first and last
+// non-empty chunk.
+func (x *QuantDevIndex) TimeRange() (first, last uint64) {
+	for i := range x.Chunks {
+		if x.Chunks[i].Rows > 0 {
+			first = x.Chunks[i].TsMin
+			break
 		}
-		if pos+n > nRows {
-			return fmt.Errorf("corrupt chunk length: pos=%d, n=%d, total=%d", pos, n, nRows)
+	}
+	for i := len(x.Chunks) - 1; i >= 0; i-- {
+		if x.Chunks[i].Rows > 0 {
+			last = x.Chunks[i].TsMax
+			break
 		}
+	}
+	return first, last
+}
 
-		// Window for this chunk
-		i0, i1 := pos, pos+n
-
-		// Order must match encoder.go
+// MinTsEvent returns the earliest ts_event of the file (0 when empty). It
+// differs from TimeRange's first when ts_event steps back across chunks.
+func (x *QuantDevIndex) MinTsEvent() uint64 {
+	var ts uint64
+	seen := false
+	for i := range x.Chunks {
+		c := &x.Chunks[i]
+		if c.Rows > 0 && (!seen || c.TsMin < ts) {
+			ts, seen = c.TsMin, true
+		}
+	}
+	return ts
+}
 
-		// 1. Event TS
-		if err := readFullInto(f, cols.TsEvent[i0:i1]); err != nil {
-			return err
+// Instruments returns the distinct instrument ids of the file, ascending,
+// from its zone maps; ok is false for files written without them.
+func (x *QuantDevIndex) Instruments() (ids []uint32, ok bool) {
+	for i := range x.Chunks {
+		c := &x.Chunks[i]
+		if !c.Zoned {
+			return nil, false
 		}
-		// 2. Recv TS
-		if err := readFullInto(f, cols.TsRecv[i0:i1]); err != nil {
-			return err
+		for _, id := range c.Instruments {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
 		}
-		// 3. Delta
-		if err := readFullInto(f, cols.TsInDelta[i0:i1]); err != nil {
-			return err
+	}
+	slices.Sort(ids)
+	return ids, true
+}
+
+func chunkSpan(chunks []ChunkInfo, tsStart, tsEnd uint64) (lo, hi int) {
+	lo, hi = -1, -1
+	for i := range chunks {
+		if chunks[i].overlaps(tsStart, tsEnd) {
+			if lo < 0 {
+				lo = i
+			}
+			hi = i + 1
 		}
-		// 4. Prices (float64)
-		if err := readFullInto(f, cols.Prices[i0:i1]); err != nil {
-			return err
+	}
+	if lo < 0 {
+		return 0, 0
+	}
+	return lo, hi
+}
+
+// readGNCIndex reads the footer chunk index ([u32 count][u64 offsets...] at
+// hdr.FooterPos) and the zone map of each chunk, or for files without zone
+// maps its row count and time bounds from the chunk itself.
+func readGNCIndex(f *os.File, hdr gncHeader) ([]ChunkInfo, error) {
+	if hdr.FooterPos < 64 {
+		return nil, fmt.Errorf("no chunk index (footer position %d)", hdr.FooterPos)
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	size := uint64(st.Size())
+
+	var b [4]byte
+	if _, err := f.ReadAt(b[:], int64(hdr.FooterPos)); err != nil {
+		return nil, fmt.Errorf("reading chunk index: %w", err)
+	}
+	n := uint64(binary.LittleEndian.Uint32(b[:]))
+	if hdr.FooterPos+4+8*n > size {
+		return nil, fmt.Errorf("corrupt chunk index: %d chunks past end of file", n)
+	}
+	offsets := make([]uint64, n)
+	idx := io.NewSectionReader(f, int64(hdr.FooterPos)+4, int64(8*n))
+	if err := readFullInto(idx, offsets); err != nil {
+		return nil, fmt.Errorf("reading chunk index: %w", err)
+	}
+
+	chunks := make([]ChunkInfo, n)
+	for i, off := range offsets {
+		if off < 64 || off+4 > hdr.FooterPos {
+			return nil, fmt.Errorf("corrupt chunk index: chunk %d at %d", i, off)
 		}
-		// 5. Sizes (float64)
-		if err := readFullInto(f, cols.Sizes[i0:i1]); err != nil {
-			return err
+		chunks[i].Offset = off
+	}
+	if hdr.ZonePos != 0 {
+		if err := readZones(f, hdr, size, chunks); err != nil {
+			return nil, err
 		}
-		// 6. Side (int8)
-		if err := readFullInto(f, cols.Sides[i0:i1]); err != nil {
-			return err
+	} else if err := readChunkBounds(f, hdr, chunks); err != nil {
+		return nil, err
+	}
+
+	row := 0
+	for i := range chunks {
+		chunks[i].FirstRow = row
+		row += chunks[i].Rows
+	}
+	if row != hdr.Rows {
+		return nil, fmt.Errorf("chunk index covers %d rows, header says %d", row, hdr.Rows)
+	}
+	return chunks, nil
+}
+
+// readZones parses the zone map block (layout in encoder.go writeFooter).
+func readZones(f *os.File, hdr gncHeader, size uint64, chunks []ChunkInfo) error {
+	if hdr.ZonePos >= size {
+		return fmt.Errorf("zone maps at %d past end of file", hdr.ZonePos)
+	}
+	buf := make([]byte, hdr.sectionEnd(hdr.ZonePos, size)-hdr.ZonePos)
+	if _, err := f.ReadAt(buf, int64(hdr.ZonePos)); err != nil {
+		return fmt.Errorf("reading zone maps: %w", err)
+	}
+
+	c := &dbnCursor{buf: buf}
+	if n := int(c.u32()); n != len(chunks) {
+		return fmt.Errorf("zone maps describe %d chunks, index has %d", n, len(chunks))
+	}
+	for i := range chunks {
+		ch := &chunks[i]
+		ch.Zoned = true
+		ch.Rows = int(c.u32())
+		ch.TsMin = c.u64()
+		ch.TsMax = c.u64()
+		ch.PxMin = math.Float64frombits(c.u64())
+		ch.PxMax = math.Float64frombits(c.u64())
+		k := c.count()
+		ch.Instruments = make([]uint32, 0, k)
+		for j := 0; j < k && c.err == nil; j++ {
+			ch.Instruments = append(ch.Instruments, c.u32())
 		}
-		// 7. Action (int8)
-		if err := readFullInto(f, cols.Actions[i0:i1]); err != nil {
-			return err
+		if c.err != nil {
+			return fmt.Errorf("zone map %d: %w", i, c.err)
 		}
-		// 8. Flags (u8)
-		if err := readFullInto(f, cols.Flags[i0:i1]); err != nil {
-			return err
+	}
+	return nil
+}
+
+// readChunkBounds fills Rows, TsMin and TsMax of each chunk from its row
+// count and the first and last value of its TsEvent column.
+func readChunkBounds(f *os.File, hdr gncHeader, chunks []ChunkInfo) error {
+	var b [12]byte
+	for i := range chunks {
+		c := &chunks[i]
+		off := c.Offset
+
+		if _, err := f.ReadAt(b[:], int64(off)); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
 		}
-		// 9. Depth (u8)
-		if err := readFullInto(f, cols.Depth[i0:i1]); err != nil {
-			return err
+		c.Rows = int(binary.LittleEndian.Uint32(b[:4]))
+		if c.Rows == 0 {
+			continue
 		}
-		// 10. Sequences (u32)
-		if err := readFullInto(f, cols.Sequences[i0:i1]); err != nil {
-			return err
+		lastPos := off + 4 + 8*uint64(c.Rows-1)
+		if lastPos+8 > hdr.FooterPos {
+			return fmt.Errorf("corrupt chunk %d: %d rows overrun the footer", i, c.Rows)
 		}
-		// 11. BidPx (float64)
-		if err := readFullInto(f, cols.BidPx[i0:i1]); err != nil {
-			return err
+		c.TsMin = binary.LittleEndian.Uint64(b[4:12])
+		var last [8]byte
+		if _, err := f.ReadAt(last[:], int64(lastPos)); err != nil {
+			return fmt.Errorf("chunk %d: %w", i, err)
 		}
-		// 12. AskPx (float64)
-		if err := readFullInto(f, cols.AskPx[i0:i1]); err != nil {
-			return err
+		c.TsMax = binary.LittleEndian.Uint64(last[:])
+	}
+	return nil
+}
+
+// =============================================================================
+//  TBBO loader
+// =============================================================================
+
+// ColumnSet selects TBBO columns to load; bit i is column i in file order.
+type ColumnSet uint32
+
+const (
+	ColTsEvent ColumnSet = 1 << iota
+	ColTsRecv
+	ColTsInDelta
+	ColPrices
+	ColSizes
+	ColSides
+	ColActions
+	ColFlags
+	ColDepth
+	ColSequences
+	ColBidPx
+	ColAskPx
+	ColBidSz
+	ColAskSz
+	ColBidCt
+	ColAskCt
+	ColPublisherID
+	ColInstrumentID
+
+	AllColumns = ColInstrumentID<<1 - 1
+)
+
+// TBBO column names in file order, as accepted by ParseColumns.
+var tbboColumnNames = [...]string{
+	"ts_event", "ts_recv", "ts_in_delta", "price", "size", "side", "action",
+	"flags", "depth", "sequence", "bid_px", "ask_px", "bid_sz", "ask_sz",
+	"bid_ct", "ask_ct", "publisher_id", "instrument_id",
+}
+
+// name is the column name of a single-column set.
+func (c ColumnSet) name() string {
+	return tbboColumnNames[bits.TrailingZeros32(uint32(c))]
+}
+
+// ParseColumns turns a comma-separated list of column names into a set.
+func ParseColumns(list string) (ColumnSet, error) {
+	var set ColumnSet
+	for _, name := range strings.Split(list, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
 		}
-		// 13. BidSz (float64)
-		if err := readFullInto(f, cols.BidSz[i0:i1]); err != nil {
-			return err
+		i := slices.Index(tbboColumnNames[:], name)
+		if i < 0 {
+			return 0, fmt.Errorf("unknown column %q", name)
 		}
-		// 14. AskSz (float64)
-		if err := readFullInto(f, cols.AskSz[i0:i1]); err != nil {
-			return err
+		set |= 1 << i
+	}
+	return set, nil
+}
+
+// TBBOQuery narrows what a TBBO load reads. The zero value loads everything.
+type TBBOQuery struct {
+	// Rows with TsEvent in [TsStart, TsEnd); TsEnd 0 means no upper bound.
+	TsStart uint64
+	TsEnd   uint64
+
+	// Columns to materialize; 0 means AllColumns. The others are seeked
+	// past and left empty. TsEvent is always loaded for a time window.
+	Columns ColumnSet
+
+	// What to do with a chunk that fails its checksum.
+	OnBadChunk int // BadChunkFail (default) or BadChunkSkip
+}
+
+const (
+	BadChunkFail = iota // return a *ChecksumError
+	BadChunkSkip        // drop the chunk's rows with a warning
+)
+
+// skipBad reports whether err is a checksum failure q lets a load of path
+// skip, warning if so.
+func (q TBBOQuery) skipBad(path string, err error) bool {
+	var ce *ChecksumError
+	if q.OnBadChunk != BadChunkSkip || !errors.As(err, &ce) {
+		return false
+	}
+	fmt.Printf("[warn] %s: chunk %d: %v; skipping its rows\n", path, ce.Chunk, ce)
+	return true
+}
+
+func (q TBBOQuery) window() (tsStart, tsEnd uint64, ok bool) {
+	tsEnd = q.TsEnd
+	if tsEnd == 0 {
+		tsEnd = math.MaxUint64
+	}
+	return q.TsStart, tsEnd, q.TsStart != 0 || tsEnd != math.MaxUint64
+}
+
+func (q TBBOQuery) columns() ColumnSet {
+	set := q.Columns
+	if set == 0 {
+		set = AllColumns
+	}
+	if _, _, ok := q.window(); ok {
+		set |= ColTsEvent
+	}
+	return set
+}
+
+func loadFromFile(path string, cols *TBBOColumns) error {
+	return loadQueryFromFile(path, cols, TBBOQuery{})
+}
+
+// loadQueryFromFile loads the rows and columns q selects. A time window
+// selects a contiguous run of chunks; the rows of its edge chunks that fall
+// outside are trimmed after loading.
+func loadQueryFromFile(path string, cols *TBBOColumns, q TBBOQuery) error {
+	f, x, err := openGNC(path, LayoutTBBO)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if err := x.Header.checkPriceEnc(); err != nil {
+		return err
+	}
+	want := q.columns()
+
+	lo, hi := 0, len(x.Chunks)
+	tsStart, tsEnd, windowed := q.window()
+	if windowed {
+		lo, hi = chunkSpan(x.Chunks, tsStart, tsEnd)
+	}
+	nRows := 0
+	if lo < hi {
+		nRows = x.Chunks[hi-1].FirstRow + x.Chunks[hi-1].Rows - x.Chunks[lo].FirstRow
+	}
+
+	cols.Reset()
+	sizeTBBO(cols, want, x.Header.fixedScale(), nRows)
+
+	// A skipped chunk's rows are overwritten by the next one.
+	row := 0
+	for k := lo; k < hi; k++ {
+		n := x.Chunks[k].Rows
+		if err := readTBBOColumns(f, x.Columns, k, cols, want, row, row+n); err != nil {
+			if q.skipBad(path, err) {
+				continue
+			}
+			return fmt.Errorf("chunk %d: %w", k, err)
 		}
-		// 15. BidCt (u32)
-		if err := readFullInto(f, cols.BidCt[i0:i1]); err != nil {
-			return err
+		row += n
+	}
+
+	cols.Count = nRows
+	cols.keepRows(0, row)
+	if windowed {
+		cols.trimTime(tsStart, tsEnd)
+	}
+	return nil
+}
+
+// checkPriceEnc validates the price encoding of a TBBO header.
+func (h gncHeader) checkPriceEnc() error {
+	switch h.PriceEnc {
+	case PriceFloat64:
+	case PriceFixedInt64:
+		if h.PriceScale <= 0 {
+			return fmt.Errorf("fixed-point prices with invalid scale %g", h.PriceScale)
 		}
-		// 16. AskCt (u32)
-		if err := readFullInto(f, cols.AskCt[i0:i1]); err != nil {
-			return err
+	default:
+		return fmt.Errorf("unknown price encoding %d", h.PriceEnc)
+	}
+	return nil
+}
+
+// fixedScale is the price scale of fixed-point files and 0 otherwise.
+func (h gncHeader) fixedScale() float64 {
+	if h.PriceEnc == PriceFixedInt64 {
+		return h.PriceScale
+	}
+	return 0
+}
+
+// sizeTBBO sizes the requested columns of cols (just Reset) for n rows,
+// reusing pooled backing arrays. Unrequested columns end up zero-length.
+// pxScale is the header's price scale, 0 for float64 prices.
+func sizeTBBO(cols *TBBOColumns, want ColumnSet, pxScale float64, n int) {
+	if pxScale != 0 {
+		cols.PriceScale = pxScale
+		cols.PricesRaw = sized(cols.PricesRaw, want&ColPrices, n)
+		cols.BidPxRaw = sized(cols.BidPxRaw, want&ColBidPx, n)
+		cols.AskPxRaw = sized(cols.AskPxRaw, want&ColAskPx, n)
+	}
+
+	cols.PublisherID = sized(cols.PublisherID, want&ColPublisherID, n)
+	cols.InstrumentID = sized(cols.InstrumentID, want&ColInstrumentID, n)
+
+	cols.TsEvent = sized(cols.TsEvent, want&ColTsEvent, n)
+	cols.TsRecv = sized(cols.TsRecv, want&ColTsRecv, n)
+	cols.TsInDelta = sized(cols.TsInDelta, want&ColTsInDelta, n)
+
+	cols.Prices = sized(cols.Prices, want&ColPrices, n)
+	cols.Sizes = sized(cols.Sizes, want&ColSizes, n)
+	cols.Sides = sized(cols.Sides, want&ColSides, n)
+	cols.Actions = sized(cols.Actions, want&ColActions, n)
+	cols.Flags = sized(cols.Flags, want&ColFlags, n)
+	cols.Depth = sized(cols.Depth, want&ColDepth, n)
+	cols.Sequences = sized(cols.Sequences, want&ColSequences, n)
+
+	cols.BidPx = sized(cols.BidPx, want&ColBidPx, n)
+	cols.AskPx = sized(cols.AskPx, want&ColAskPx, n)
+	cols.BidSz = sized(cols.BidSz, want&ColBidSz, n)
+	cols.AskSz = sized(cols.AskSz, want&ColAskSz, n)
+	cols.BidCt = sized(cols.BidCt, want&ColBidCt, n)
+	cols.AskCt = sized(cols.AskCt, want&ColAskCt, n)
+}
+
+// readTBBOColumns reads chunk k's columns into rows [i0:i1] of cols (sized
+// by sizeTBBO), skipping the columns want leaves out.
+func readTBBOColumns(f *os.File, d *ColumnDir, k int, cols *TBBOColumns, want ColumnSet, i0, i1 int) error {
+	// Price columns are float64 or, in exact files, int64 nanounits that
+	// also get a float64 view.
+	prices := func(name string, dst []float64, raw []int64) error {
+		if !cols.Exact() {
+			return readColumnInto(f, d, k, name, dst[i0:i1])
 		}
-		// 17. Publisher IDs (u16)
-		if err := readFullInto(f, cols.PublisherID[i0:i1]); err != nil {
+		if err := readColumnInto(f, d, k, name, raw[i0:i1]); err != nil {
 			return err
 		}
-		// 18. Instrument IDs (u32)
-		if err := readFullInto(f, cols.InstrumentID[i0:i1]); err != nil {
+		scaleInto(dst[i0:i1], raw[i0:i1], cols.PriceScale)
+		return nil
+	}
+
+	for set := want & AllColumns; set != 0; set &= set - 1 {
+		on := set & -set
+		name := on.name()
+		var err error
+		switch on {
+		case ColTsEvent:
+			err = readColumnInto(f, d, k, name, cols.TsEvent[i0:i1])
+		case ColTsRecv:
+			err = readColumnInto(f, d, k, name, cols.TsRecv[i0:i1])
+		case ColTsInDelta:
+			err = readColumnInto(f, d, k, name, cols.TsInDelta[i0:i1])
+		case ColPrices:
+			err = prices(name, cols.Prices, cols.PricesRaw)
+		case ColSizes:
+			err = readColumnInto(f, d, k, name, cols.Sizes[i0:i1])
+		case ColSides:
+			err = readColumnInto(f, d, k, name, cols.Sides[i0:i1])
+		case ColActions:
+			err = readColumnInto(f, d, k, name, cols.Actions[i0:i1])
+		case ColFlags:
+			err = readColumnInto(f, d, k, name, cols.Flags[i0:i1])
+		case ColDepth:
+			err = readColumnInto(f, d, k, name, cols.Depth[i0:i1])
+		case ColSequences:
+			err = readColumnInto(f, d, k, name, cols.Sequences[i0:i1])
+		case ColBidPx:
+			err = prices(name, cols.BidPx, cols.BidPxRaw)
+		case ColAskPx:
+			err = prices(name, cols.AskPx, cols.AskPxRaw)
+		case ColBidSz:
+			err = readColumnInto(f, d, k, name, cols.BidSz[i0:i1])
+		case ColAskSz:
+			err = readColumnInto(f, d, k, name, cols.AskSz[i0:i1])
+		case ColBidCt:
+			err = readColumnInto(f, d, k, name, cols.BidCt[i0:i1])
+		case ColAskCt:
+			err = readColumnInto(f, d, k, name, cols.AskCt[i0:i1])
+		case ColPublisherID:
+			err = readColumnInto(f, d, k, name, cols.PublisherID[i0:i1])
+		case ColInstrumentID:
+			err = readColumnInto(f, d, k, name, cols.InstrumentID[i0:i1])
+		}
+		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
+
+// =============================================================================
+//  Streaming
+// =============================================================================
+
+// ChunkReader streams a TBBO file chunk by chunk, so memory stays at one
+// chunk (ChunkSize rows) whatever the file size.
+type ChunkReader struct {
+	Index *QuantDevIndex
+
+	f      *os.File
+	q      TBBOQuery
+	chunks []ChunkInfo // the chunks q selects
+	first  int         // index of chunks[0] in the file
+	buf    TBBOColumns
+	err    error
+}
+
+// OpenChunkReader opens a TBBO file for streaming the rows and columns q
+// selects. Close it when done.
+func OpenChunkReader(path string, q TBBOQuery) (*ChunkReader, error) {
+	f, x, err := openGNC(path, LayoutTBBO)
+	if err != nil {
+		return nil, err
+	}
+	if err := x.Header.checkPriceEnc(); err != nil {
+		f.Close()
+		return nil, err
+	}
 
-		pos += n
+	r := &ChunkReader{Index: x, f: f, q: q}
+	r.chunks = x.Chunks
+	if tsStart, tsEnd, ok := q.window(); ok {
+		lo, hi := chunkSpan(x.Chunks, tsStart, tsEnd)
+		r.chunks, r.first = x.Chunks[lo:hi], lo
 	}
+	return r, nil
+}
 
-	if pos != nRows {
-		return fmt.Errorf("row count mismatch: loaded=%d, expected=%d", pos, nRows)
+// All yields (chunk index, rows) for every selected chunk in file order.
+// The columns are one reused buffer: they are only valid until the next
+// iteration. A read error ends the sequence; check Err afterwards.
+func (r *ChunkReader) All() iter.Seq2[int, *TBBOColumns] {
+	return func(yield func(int, *TBBOColumns) bool) {
+		tsStart, tsEnd, windowed := r.q.window()
+		want := r.q.columns()
+		for k, c := range r.chunks {
+			if err := r.readChunk(r.first+k, &c, want); err != nil {
+				if r.q.skipBad(r.f.Name(), err) {
+					continue
+				}
+				r.err = fmt.Errorf("chunk %d: %w", r.first+k, err)
+				return
+			}
+			if windowed {
+				r.buf.trimTime(tsStart, tsEnd)
+				if r.buf.Count == 0 {
+					continue
+				}
+			}
+			if !yield(r.first+k, &r.buf) {
+				return
+			}
+		}
 	}
+}
 
-	cols.Count = nRows
+func (r *ChunkReader) readChunk(k int, c *ChunkInfo, want ColumnSet) error {
+	r.buf.Reset()
+	sizeTBBO(&r.buf, want, r.Index.Header.fixedScale(), c.Rows)
+	if err := readTBBOColumns(r.f, r.Index.Columns, k, &r.buf, want, 0, c.Rows); err != nil {
+		return err
+	}
+	r.buf.Count = c.Rows
 	return nil
 }
+
+// Err reports the error that ended All early, if any.
+func (r *ChunkReader) Err() error {
+	return r.err
+}
+
+func (r *ChunkReader) Close() error {
+	return r.f.Close()
+}
+
+// sized is resize for a requested column and an empty view otherwise.
+func sized[T any](s []T, on ColumnSet, n int) []T {
+	if on == 0 {
+		return s[:0]
+	}
+	return resize(s, n)
+}
+
+func scaleInto(dst []float64, raw []int64, scale float64) {
+	dst = dst[:len(raw)]
+	for i, v := range raw {
+		dst[i] = float64(v) * scale
+	}
+}
+
+// ReadQuantDevSource returns the DBN provenance stored in a .quantdev footer,
+// or (nil, nil) if the file was written without one.
+func ReadQuantDevSource(path string) (*DBNMetadata, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr, err := readGNCHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.SourcePos == 0 {
+		return nil, nil
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := hdr.sectionEnd(hdr.SourcePos, uint64(st.Size()))
+	if hdr.SourcePos >= end {
+		return nil, fmt.Errorf("source block at %d past end of file", hdr.SourcePos)
+	}
+	buf := make([]byte, end-hdr.SourcePos)
+	if _, err := f.ReadAt(buf, int64(hdr.SourcePos)); err != nil {
+		return nil, err
+	}
+	return parseSourceBlock(buf)
+}
+
+// parseSourceBlock is the inverse of appendSourceBlock (encoder.go).
+func parseSourceBlock(buf []byte) (*DBNMetadata, error) {
+	c := &dbnCursor{buf: buf}
+	m := &DBNMetadata{}
+
+	m.Dataset = c.cstr(dbnDatasetLen)
+	m.Schema = c.u16()
+	m.StypeIn = c.u8()
+	m.StypeOut = c.u8()
+	m.Version = c.u8()
+	c.skip(3)
+	m.Start = c.u64()
+	m.End = c.u64()
+
+	n := c.count()
+	for i := 0; i < n && c.err == nil; i++ {
+		l := int(c.u16())
+		m.Symbols = append(m.Symbols, string(c.take(l)))
+	}
+
+	if c.err != nil {
+		return nil, fmt.Errorf("source block: %w", c.err)
+	}
+	return m, nil
+}
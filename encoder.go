@@ -2,8 +2,12 @@ package main
 
 import (
 	"encoding/binary"
+	"fmt"
+	"hash/crc32"
 	"io"
+	"math"
 	"os"
+	"slices"
 )
 
 const (
@@ -40,24 +44,43 @@ type Encoder struct {
 	pubBuffer  []uint16
 	instBuffer []uint32
 
-	totalRows    uint64
-	chunkOffsets []uint64
-	outFile      *os.File
+	// Appends (OpenEncoderAppend): the latest ts_event in the file so far,
+	// which the next row may not precede.
+	tsFloor uint64
+
+	// Exact mode: prices stay in DBN fixed-9 instead of px/bp/apBuffer.
+	pxRaw []int64
+	bpRaw []int64
+	apRaw []int64
+
+	gncFile
 }
 
 func NewEncoder(path string) (*Encoder, error) {
-	f, err := os.Create(path)
+	g, err := createGNC(path, LayoutTBBO, RTypeTBBO)
 	if err != nil {
 		return nil, err
 	}
+	return newEncoder(g), nil
+}
 
-	// Reserve header space with zeros.
-	zeroHeader := make([]byte, 64)
-	if _, err := f.Write(zeroHeader); err != nil {
-		f.Close()
+// NewEncoderExact writes Prices, BidPx and AskPx as int64 DBN nanounits
+// (scale PxScale) so loaders can compare prices exactly. The file has the
+// same size and column order as a float64 one; only the header differs.
+func NewEncoderExact(path string) (*Encoder, error) {
+	g, err := createGNC(path, LayoutTBBO, RTypeTBBO)
+	if err != nil {
 		return nil, err
 	}
+	g.pxScale = PxScale
+	e := newEncoder(g)
+	e.pxRaw = make([]int64, 0, ChunkSize)
+	e.bpRaw = make([]int64, 0, ChunkSize)
+	e.apRaw = make([]int64, 0, ChunkSize)
+	return e, nil
+}
 
+func newEncoder(g gncFile) *Encoder {
 	return &Encoder{
 		tsEvent:   make([]uint64, 0, ChunkSize),
 		tsRecv:    make([]uint64, 0, ChunkSize),
@@ -84,13 +107,14 @@ func NewEncoder(path string) (*Encoder, error) {
 		pubBuffer:  make([]uint16, 0, ChunkSize),
 		instBuffer: make([]uint32, 0, ChunkSize),
 
-		outFile: f,
-	}, nil
+		gncFile: g,
+	}
 }
 
 // AddRow ingests a single TBBO record into the current chunk.
 // All price/size fields are converted once to float64 here and
-// written as raw float64s on disk (no fixed-9 round-trip).
+// written as raw float64s on disk (no fixed-9 round-trip), except prices in
+// exact mode, which are kept as fixed-9.
 func (e *Encoder) AddRow(
 	pubID uint16,
 	instrID uint32,
@@ -111,12 +135,26 @@ func (e *Encoder) AddRow(
 	bc uint32,
 	ac uint32,
 ) error {
+	if e.orig != nil {
+		if tsE < e.tsFloor {
+			return fmt.Errorf("append: ts_event %d is before the previous row (%d)", tsE, e.tsFloor)
+		}
+		e.tsFloor = tsE
+	}
 	e.tsEvent = append(e.tsEvent, tsE)
 	e.tsRecv = append(e.tsRecv, tsR)
 	e.tsInDelta = append(e.tsInDelta, tsD)
 
 	// Convert once from fixed-9 to float64.
-	e.pxBuffer = append(e.pxBuffer, float64(pxRaw)*PxScale)
+	if e.pxScale != 0 {
+		e.pxRaw = append(e.pxRaw, pxRaw)
+		e.bpRaw = append(e.bpRaw, bpRaw)
+		e.apRaw = append(e.apRaw, apRaw)
+	} else {
+		e.pxBuffer = append(e.pxBuffer, float64(pxRaw)*PxScale)
+		e.bpBuffer = append(e.bpBuffer, float64(bpRaw)*PxScale)
+		e.apBuffer = append(e.apBuffer, float64(apRaw)*PxScale)
+	}
 	e.szBuffer = append(e.szBuffer, float64(sz))
 
 	e.sdBuffer = append(e.sdBuffer, side)
@@ -126,9 +164,6 @@ func (e *Encoder) AddRow(
 
 	e.sqBuffer = append(e.sqBuffer, seq)
 
-	e.bpBuffer = append(e.bpBuffer, float64(bpRaw)*PxScale)
-	e.apBuffer = append(e.apBuffer, float64(apRaw)*PxScale)
-
 	e.bsBuffer = append(e.bsBuffer, float64(bs))
 	e.asBuffer = append(e.asBuffer, float64(as))
 	e.bcBuffer = append(e.bcBuffer, bc)
@@ -150,83 +185,52 @@ func (e *Encoder) flushChunk() error {
 		return nil
 	}
 
-	offset, _ := e.outFile.Seek(0, io.SeekCurrent)
-	e.chunkOffsets = append(e.chunkOffsets, uint64(offset))
-
-	// Chunk length header (uint32)
-	var scratch [4]byte
-	binary.LittleEndian.PutUint32(scratch[:], uint32(n))
-	if _, err := e.outFile.Write(scratch[:]); err != nil {
-		return err
-	}
-
-	// Order must match decoder.go
-
-	// Timing
-	if _, err := e.outFile.Write(asBytes(e.tsEvent)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.tsRecv)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.tsInDelta)); err != nil {
-		return err
-	}
-
-	// Prices and sizes (raw float64)
-	if _, err := e.outFile.Write(asBytes(e.pxBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.szBuffer)); err != nil {
-		return err
-	}
-
-	// Side, Action, Flags, Depth
-	if _, err := e.outFile.Write(asBytes(e.sdBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.acBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.flBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.depthBuffer)); err != nil {
-		return err
-	}
-
-	// Sequence
-	if _, err := e.outFile.Write(asBytes(e.sqBuffer)); err != nil {
-		return err
-	}
-
-	// BBO prices (float64)
-	if _, err := e.outFile.Write(asBytes(e.bpBuffer)); err != nil {
-		return err
+	if e.pxScale != 0 {
+		lo, hi := minMaxRaw(e.pxRaw)
+		e.addZone(e.tsEvent, e.instBuffer, lo, hi, e.pxScale)
+	} else {
+		lo, hi := minMaxPx(e.pxBuffer)
+		e.addZone(e.tsEvent, e.instBuffer, lo, hi, 1)
 	}
-	if _, err := e.outFile.Write(asBytes(e.apBuffer)); err != nil {
+	if err := e.beginChunk(n); err != nil {
 		return err
 	}
 
-	// BBO sizes and counts
-	if _, err := e.outFile.Write(asBytes(e.bsBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.asBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.bcBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.acCBuffer)); err != nil {
-		return err
-	}
-
-	// Identity: publisher / instrument
-	if _, err := e.outFile.Write(asBytes(e.pubBuffer)); err != nil {
-		return err
-	}
-	if _, err := e.outFile.Write(asBytes(e.instBuffer)); err != nil {
+	// Order must match layoutSchema (format.go)
+	err := e.writeColumns(
+		// Timing
+		asBytes(e.tsEvent),
+		asBytes(e.tsRecv),
+		asBytes(e.tsInDelta),
+
+		// Prices and sizes (raw float64; prices int64 fixed-9 in exact mode)
+		e.priceBytes(e.pxBuffer, e.pxRaw),
+		asBytes(e.szBuffer),
+
+		// Side, Action, Flags, Depth
+		asBytes(e.sdBuffer),
+		asBytes(e.acBuffer),
+		asBytes(e.flBuffer),
+		asBytes(e.depthBuffer),
+
+		// Sequence
+		asBytes(e.sqBuffer),
+
+		// BBO prices (float64, or int64 in exact mode)
+		e.priceBytes(e.bpBuffer, e.bpRaw),
+		e.priceBytes(e.apBuffer, e.apRaw),
+
+		// BBO sizes and counts
+		asBytes(e.bsBuffer),
+		asBytes(e.asBuffer),
+		asBytes(e.bcBuffer),
+		asBytes(e.acCBuffer),
+
+		// Identity: publisher / instrument
+		asBytes(e.pubBuffer),
+		asBytes(e.instBuffer),
+	)
+	if err != nil {
 		return err
 	}
 
@@ -256,45 +260,461 @@ func (e *Encoder) flushChunk() error {
 	e.pubBuffer = e.pubBuffer[:0]
 	e.instBuffer = e.instBuffer[:0]
 
+	e.pxRaw = e.pxRaw[:0]
+	e.bpRaw = e.bpRaw[:0]
+	e.apRaw = e.apRaw[:0]
+
 	return nil
 }
 
+// priceBytes picks the buffer that holds a price column in this mode.
+func (e *Encoder) priceBytes(f []float64, raw []int64) []byte {
+	if e.pxScale != 0 {
+		return asBytes(raw)
+	}
+	return asBytes(f)
+}
+
 func (e *Encoder) Close() error {
 	if len(e.tsEvent) > 0 {
 		if err := e.flushChunk(); err != nil {
 			return err
 		}
 	}
-	if err := e.writeFooter(); err != nil {
+	return e.finish()
+}
+
+// -----------------------------------------------------------------------------
+// gncFile: the schema-independent half of every .quantdev writer.
+// Owns header reservation, the chunk index, the provenance block and the final
+// header rewrite; schema encoders embed it and only deal with columns.
+// -----------------------------------------------------------------------------
+
+// Column layouts (header[4:6]). Zero is the original TBBO layout so files
+// written before the field existed keep decoding.
+const (
+	LayoutTBBO uint16 = iota
+	LayoutTrades
+	LayoutMBP10
+	LayoutOHLCV
+)
+
+var layoutNames = [...]string{"tbbo", "trades", "mbp-10", "ohlcv"}
+
+// Price column encodings (header[7]).
+const (
+	PriceFloat64    = 0
+	PriceFixedInt64 = 1
+)
+
+type gncFile struct {
+	path   string
+	layout uint16
+	rtype  uint8
+
+	// Price column encoding: 0 = float64, else int64 with this scale.
+	pxScale float64
+
+	totalRows    uint64
+	chunkOffsets []uint64
+	zones        []chunkZone      // one per chunk offset
+	extents      [][]ColumnExtent // column directory, one per chunk offset
+	outFile      *os.File
+
+	compact bool         // encode columns per compactEncoding
+	columns []ColumnDesc // see schema()
+	scratch []byte       // encoded column being written
+
+	// Optional DBN provenance, written after the chunk index.
+	source *DBNMetadata
+	// Extra metadata section entries (SetMeta, info.go).
+	meta FileMeta
+
+	// Set when extending an existing file in place (append.go).
+	orig *appendState
+
+	// finish leaves the complete file under path+TmpSuffix (stage).
+	staged bool
+}
+
+// createGNC opens path+TmpSuffix for writing; finish renames it to path, so
+// an interrupted conversion never leaves a truncated file under the real name.
+func createGNC(path string, layout uint16, rtype uint8) (gncFile, error) {
+	f, err := os.Create(path + TmpSuffix)
+	if err != nil {
+		return gncFile{}, err
+	}
+
+	// Reserve header space with zeros.
+	zeroHeader := make([]byte, 64)
+	if _, err := f.Write(zeroHeader); err != nil {
+		f.Close()
+		return gncFile{}, err
+	}
+	return gncFile{path: path, layout: layout, rtype: rtype, outFile: f}, nil
+}
+
+// SetSource attaches the DBN metadata of the input file. It is persisted in the
+// footer on Close so readers can recover dataset, schema, date range and
+// symbols without parsing the filename. nil clears it.
+func (g *gncFile) SetSource(m *DBNMetadata) {
+	g.source = m
+}
+
+// RType is the DBN rtype of the rows this file holds.
+func (g *gncFile) RType() uint8 {
+	return g.rtype
+}
+
+// Outputs lists the file being written, for callers that may discard it.
+func (g *gncFile) Outputs() []string {
+	return []string{g.path}
+}
+
+// beginChunk records the chunk offset and writes its row-count prefix.
+func (g *gncFile) beginChunk(n int) error {
+	offset, _ := g.outFile.Seek(0, io.SeekCurrent)
+
+	// Pad so the column data after the row count starts 8-byte aligned,
+	// which lets MmapQuantDev view full chunks in place. The pad is a zero
+	// u32 that older, sequential readers skip as an empty chunk.
+	if offset%8 == 0 {
+		var pad [4]byte
+		if _, err := g.outFile.Write(pad[:]); err != nil {
+			return err
+		}
+		offset += 4
+	}
+	g.chunkOffsets = append(g.chunkOffsets, uint64(offset))
+
+	// Chunk length header (uint32)
+	var scratch [4]byte
+	binary.LittleEndian.PutUint32(scratch[:], uint32(n))
+	_, err := g.outFile.Write(scratch[:])
+	return err
+}
+
+// chunkZone is the zone map of one chunk: enough to decide from the footer
+// alone whether a chunk can hold rows a query wants.
+type chunkZone struct {
+	rows         uint32
+	tsMin, tsMax uint64
+	pxMin, pxMax float64  // NaN when the chunk has no priced rows
+	instruments  []uint32 // distinct, ascending
+}
+
+// addZone records the zone map of the chunk about to be written. Prices
+// arrive as min/max in their column's units times scale (1 for float64
+// columns, the fixed-point scale for int64 ones).
+func (g *gncFile) addZone(ts []uint64, instr []uint32, pxMin, pxMax, scale float64) {
+	z := chunkZone{rows: uint32(len(ts)), pxMin: pxMin * scale, pxMax: pxMax * scale}
+	if len(ts) > 0 {
+		z.tsMin, z.tsMax = ts[0], ts[0]
+		for _, t := range ts {
+			z.tsMin = min(z.tsMin, t)
+			z.tsMax = max(z.tsMax, t)
+		}
+	}
+	z.instruments = distinctIDs(instr)
+	g.zones = append(g.zones, z)
+}
+
+// distinctIDs returns the sorted distinct values of ids without modifying it.
+func distinctIDs(ids []uint32) []uint32 {
+	if len(ids) == 0 {
+		return nil
+	}
+	single := true
+	for _, id := range ids {
+		if id != ids[0] {
+			single = false
+			break
+		}
+	}
+	if single {
+		return []uint32{ids[0]}
+	}
+	out := slices.Clone(ids)
+	slices.Sort(out)
+	return slices.Compact(out)
+}
+
+// minMaxPx returns the range of the real prices in px, skipping the DBN
+// null price; NaN, NaN if there are none.
+func minMaxPx(px []float64) (lo, hi float64) {
+	lo, hi = math.NaN(), math.NaN()
+	null := float64(NullPrice) * PxScale
+	for _, v := range px {
+		if v != v || v >= null {
+			continue
+		}
+		if lo != lo || v < lo {
+			lo = v
+		}
+		if hi != hi || v > hi {
+			hi = v
+		}
+	}
+	return lo, hi
+}
+
+// minMaxRaw is minMaxPx for fixed-point prices.
+func minMaxRaw(px []int64) (lo, hi float64) {
+	var mn, mx int64
+	found := false
+	for _, v := range px {
+		if v == NullPrice {
+			continue
+		}
+		if !found {
+			mn, mx, found = v, v, true
+			continue
+		}
+		mn = min(mn, v)
+		mx = max(mx, v)
+	}
+	if !found {
+		return math.NaN(), math.NaN()
+	}
+	return float64(mn), float64(mx)
+}
+
+// SetCompact turns on the column encodings of compactEncoding (encoding.go)
+// for this file. It must be called before the first row.
+func (g *gncFile) SetCompact(on bool) {
+	g.compact = on
+}
+
+// schema is the column list this file's chunks hold, fixed on first use.
+func (g *gncFile) schema() []ColumnDesc {
+	if g.columns == nil {
+		g.columns = layoutSchema(g.layout, g.pxScale != 0)
+		if g.compact {
+			for i := range g.columns {
+				g.columns[i].Encoding = compactEncoding(g.columns[i])
+			}
+		}
+	}
+	return g.columns
+}
+
+// writeColumns writes one chunk's column payloads (plain bytes, in schema
+// order), encoding those the schema says to, and records where each one
+// went and its checksum.
+func (g *gncFile) writeColumns(cols ...[]byte) error {
+	schema := g.schema()
+	if len(cols) != len(schema) {
+		return fmt.Errorf("%s chunk with %d columns, schema has %d", layoutNames[g.layout], len(cols), len(schema))
+	}
+	pos, err := g.outFile.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	ext := make([]ColumnExtent, len(cols))
+	for i, c := range cols {
+		if enc := schema[i].Encoding; enc != EncodingPlain {
+			if g.scratch, err = encodeColumn(g.scratch[:0], enc, schema[i].DType, c); err != nil {
+				return fmt.Errorf("column %s: %w", schema[i].Name, err)
+			}
+			c = g.scratch
+		} else if g.compact {
+			// Encoded columns have arbitrary lengths; keep the plain
+			// ones aligned for MmapQuantDev.
+			if pad := int(-pos) & (schema[i].DType.Size() - 1); pad > 0 {
+				var zeros [8]byte
+				if _, err := g.outFile.Write(zeros[:pad]); err != nil {
+					return err
+				}
+				pos += int64(pad)
+			}
+		}
+		ext[i] = ColumnExtent{Offset: uint64(pos), Bytes: uint64(len(c)), CRC: crc32.Checksum(c, crc32c)}
+		if _, err := g.outFile.Write(c); err != nil {
+			return err
+		}
+		pos += int64(len(c))
+	}
+	g.extents = append(g.extents, ext)
+	return nil
+}
+
+func (g *gncFile) finish() error {
+	if g.orig != nil {
+		err := g.writeFooter()
+		if err != nil {
+			g.orig.restore(g.outFile)
+		}
+		if cerr := g.outFile.Close(); err == nil {
+			err = cerr
+		}
 		return err
 	}
-	return e.outFile.Close()
+
+	tmp := g.outFile.Name()
+	err := g.writeFooter()
+	if cerr := g.outFile.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if g.staged {
+		return nil
+	}
+	// Only a complete file ever appears under the final name.
+	return os.Rename(tmp, g.path)
+}
+
+// stage makes Close leave the finished file under its temp name, for a
+// caller that decides afterwards whether it replaces path.
+func (g *gncFile) stage() {
+	g.staged = true
 }
 
-func (e *Encoder) writeFooter() error {
-	footerPos, _ := e.outFile.Seek(0, io.SeekCurrent)
+// Abort discards the file being written; nothing appears under its name.
+// An append restores the file as it was.
+func (g *gncFile) Abort() {
+	if g.orig != nil {
+		g.orig.restore(g.outFile)
+		g.outFile.Close()
+		return
+	}
+	tmp := g.outFile.Name()
+	g.outFile.Close()
+	os.Remove(tmp)
+}
+
+func (g *gncFile) writeFooter() error {
+	footerPos, _ := g.outFile.Seek(0, io.SeekCurrent)
 
 	// Chunk index: [u32 count][u64 offsets...]
 	var scratch [4]byte
-	binary.LittleEndian.PutUint32(scratch[:], uint32(len(e.chunkOffsets)))
-	if _, err := e.outFile.Write(scratch[:]); err != nil {
+	binary.LittleEndian.PutUint32(scratch[:], uint32(len(g.chunkOffsets)))
+	if _, err := g.outFile.Write(scratch[:]); err != nil {
 		return err
 	}
-	if len(e.chunkOffsets) > 0 {
-		if _, err := e.outFile.Write(asBytes(e.chunkOffsets)); err != nil {
+	if len(g.chunkOffsets) > 0 {
+		if _, err := g.outFile.Write(asBytes(g.chunkOffsets)); err != nil {
+			return err
+		}
+	}
+
+	// Zone maps: [u32 count] then per chunk
+	//   [u32 rows][u64 ts min][u64 ts max][f64 px min][f64 px max]
+	//   [u32 n instruments][u32 ids...]
+	zonePos, _ := g.outFile.Seek(0, io.SeekCurrent)
+	if _, err := g.outFile.Write(appendZones(nil, g.zones)); err != nil {
+		return err
+	}
+
+	// Source block (optional)
+	var sourcePos int64
+	if g.source != nil {
+		sourcePos, _ = g.outFile.Seek(0, io.SeekCurrent)
+		if _, err := g.outFile.Write(appendSourceBlock(nil, g.source)); err != nil {
+			return err
+		}
+	}
+
+	// Column directory with checksums (format.go)
+	dirPos, _ := g.outFile.Seek(0, io.SeekCurrent)
+	dir := &ColumnDir{Columns: g.schema(), Extents: g.extents}
+	if _, err := g.outFile.Write(appendColumnDir(nil, dir)); err != nil {
+		return err
+	}
+
+	// Metadata section (info.go)
+	metaPos, _ := g.outFile.Seek(0, io.SeekCurrent)
+	if _, err := g.outFile.Write(appendFileMeta(nil, g.fileMeta())); err != nil {
+		return err
+	}
+
+	// An append may leave the end of a longer old footer behind.
+	if g.orig != nil {
+		end, _ := g.outFile.Seek(0, io.SeekCurrent)
+		if err := g.outFile.Truncate(end); err != nil {
 			return err
 		}
 	}
 
 	// Rewrite Header
-	if _, err := e.outFile.Seek(0, io.SeekStart); err != nil {
+	//  [0:4]   magic
+	//  [4:6]   column layout
+	//  [6]     DBN rtype of the rows
+	//  [7]     price encoding (PriceFloat64 / PriceFixedInt64)
+	//  [8:16]  total rows
+	//  [16:24] price scale (float64; fixed-point files only)
+	//  [24:32] footer position
+	//  [32:40] source block position (0 = none)
+	//  [40:48] zone map position (0 = none, files before zone maps)
+	//  [48:56] column directory position (0 = none, format 1)
+	//  [56:58] format version (0 = format 1)
+	//  [58:64] metadata section position (u48; 0 = none)
+	if _, err := g.outFile.Seek(0, io.SeekStart); err != nil {
 		return err
 	}
 	header := make([]byte, 64)
 	copy(header[0:4], MagicGNC)
-	binary.LittleEndian.PutUint64(header[8:16], e.totalRows)
+	binary.LittleEndian.PutUint16(header[4:6], g.layout)
+	header[6] = g.rtype
+	binary.LittleEndian.PutUint64(header[8:16], g.totalRows)
+	if g.pxScale != 0 {
+		header[7] = PriceFixedInt64
+		binary.LittleEndian.PutUint64(header[16:24], math.Float64bits(g.pxScale))
+	}
 	binary.LittleEndian.PutUint64(header[24:32], uint64(footerPos))
+	binary.LittleEndian.PutUint64(header[32:40], uint64(sourcePos))
+	binary.LittleEndian.PutUint64(header[40:48], uint64(zonePos))
+	binary.LittleEndian.PutUint64(header[48:56], uint64(dirPos))
+	binary.LittleEndian.PutUint16(header[56:58], FormatVersion)
+	putU48(header[58:64], uint64(metaPos))
 
-	_, err := e.outFile.Write(header)
+	_, err := g.outFile.Write(header)
 	return err
 }
+
+// putU48 stores the low 48 bits of v little-endian in b[:6].
+func putU48(b []byte, v uint64) {
+	var w [8]byte
+	binary.LittleEndian.PutUint64(w[:], v)
+	copy(b[:6], w[:6])
+}
+
+// appendSourceBlock serializes the subset of DBN metadata kept in .quantdev:
+//
+//	[dataset 16][schema u16][stype_in u8][stype_out u8][dbn version u8][pad 3]
+//	[start u64][end u64][u32 nsym] then nsym x [u16 len][bytes]
+func appendSourceBlock(dst []byte, m *DBNMetadata) []byte {
+	var ds [dbnDatasetLen]byte
+	copy(ds[:], m.Dataset)
+	dst = append(dst, ds[:]...)
+	dst = binary.LittleEndian.AppendUint16(dst, m.Schema)
+	dst = append(dst, m.StypeIn, m.StypeOut, m.Version, 0, 0, 0)
+	dst = binary.LittleEndian.AppendUint64(dst, m.Start)
+	dst = binary.LittleEndian.AppendUint64(dst, m.End)
+	dst = binary.LittleEndian.AppendUint32(dst, uint32(len(m.Symbols)))
+	for _, sym := range m.Symbols {
+		dst = binary.LittleEndian.AppendUint16(dst, uint16(len(sym)))
+		dst = append(dst, sym...)
+	}
+	return dst
+}
+
+// appendZones serializes the zone map block (layout in writeFooter).
+func appendZones(b []byte, zones []chunkZone) []byte {
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(zones)))
+	for i := range zones {
+		z := &zones[i]
+		b = binary.LittleEndian.AppendUint32(b, z.rows)
+		b = binary.LittleEndian.AppendUint64(b, z.tsMin)
+		b = binary.LittleEndian.AppendUint64(b, z.tsMax)
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(z.pxMin))
+		b = binary.LittleEndian.AppendUint64(b, math.Float64bits(z.pxMax))
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(z.instruments)))
+		for _, id := range z.instruments {
+			b = binary.LittleEndian.AppendUint32(b, id)
+		}
+	}
+	return b
+}
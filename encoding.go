@@ -0,0 +1,358 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/bits"
+	"unsafe"
+)
+
+// -----------------------------------------------------------------------------
+// Column encodings (ColumnDesc.Encoding), for integer columns only. Each
+// chunk's copy of a column is encoded on its own; the row count comes from
+// the chunk.
+//
+//	delta-varint  zigzag(v[i]-v[i-1]) as uvarints, v[-1] = 0
+//	delta-for     [uvarint zigzag(v[0])], then the deltas of v[1:] in blocks
+//	              of forBlock:
+//	              [uvarint zigzag(min)][u8 width][offsets from min, bit-packed]
+//	rle           runs of [uvarint length][uvarint zigzag(value)]
+//	dict          [uvarint k][k x uvarint zigzag(value)][u8 width]
+//	              [indices into the k values, bit-packed]
+//
+// Bit-packed values are LSB-first and padded to a whole byte. Values go
+// through uint64 (sign-extended for signed columns), so deltas wrap and
+// every dtype round-trips.
+//
+// `data -compact` picks the encodings in compactEncoding; everything else
+// stays plain so price and size columns keep their zero-copy path.
+// -----------------------------------------------------------------------------
+
+// forBlock is the delta-for block length.
+const forBlock = 128
+
+// compactEncoding is the encoding `data -compact` uses for a column:
+// monotone timestamps and sequences as deltas, near-constant ids as a
+// dictionary, low-cardinality event fields as runs.
+func compactEncoding(c ColumnDesc) ColumnEncoding {
+	switch c.Name {
+	case "ts_event", "ts_recv":
+		return EncodingDeltaFOR
+	case "sequence":
+		return EncodingDeltaVarint
+	case "publisher_id", "instrument_id":
+		return EncodingDict
+	case "side", "action", "flags", "depth":
+		return EncodingRLE
+	}
+	return EncodingPlain
+}
+
+var errEncodedFloat = errors.New("encodings apply to integer columns only")
+
+type integer interface {
+	~uint8 | ~int8 | ~uint16 | ~uint32 | ~int32 | ~uint64 | ~int64
+}
+
+// viewAs reinterprets b, which must come from a []T or an 8-byte aligned
+// buffer, as []T.
+func viewAs[T any](b []byte) []T {
+	if len(b) == 0 {
+		return nil
+	}
+	var zero T
+	return unsafe.Slice((*T)(unsafe.Pointer(&b[0])), len(b)/int(unsafe.Sizeof(zero)))
+}
+
+// encodeColumn appends the encoding of plain (values of dtype t) to dst.
+func encodeColumn(dst []byte, enc ColumnEncoding, t DType, plain []byte) ([]byte, error) {
+	switch t {
+	case DTypeU8:
+		return encodeValues(dst, enc, viewAs[uint8](plain))
+	case DTypeI8:
+		return encodeValues(dst, enc, viewAs[int8](plain))
+	case DTypeU16:
+		return encodeValues(dst, enc, viewAs[uint16](plain))
+	case DTypeU32:
+		return encodeValues(dst, enc, viewAs[uint32](plain))
+	case DTypeI32:
+		return encodeValues(dst, enc, viewAs[int32](plain))
+	case DTypeU64:
+		return encodeValues(dst, enc, viewAs[uint64](plain))
+	case DTypeI64:
+		return encodeValues(dst, enc, viewAs[int64](plain))
+	}
+	return dst, errEncodedFloat
+}
+
+// decodeColumn decodes src into dst, which holds the column's plain bytes
+// for the chunk's row count and must be aligned for dtype t.
+func decodeColumn(enc ColumnEncoding, t DType, src, dst []byte) error {
+	switch t {
+	case DTypeU8:
+		return decodeValues(enc, src, viewAs[uint8](dst))
+	case DTypeI8:
+		return decodeValues(enc, src, viewAs[int8](dst))
+	case DTypeU16:
+		return decodeValues(enc, src, viewAs[uint16](dst))
+	case DTypeU32:
+		return decodeValues(enc, src, viewAs[uint32](dst))
+	case DTypeI32:
+		return decodeValues(enc, src, viewAs[int32](dst))
+	case DTypeU64:
+		return decodeValues(enc, src, viewAs[uint64](dst))
+	case DTypeI64:
+		return decodeValues(enc, src, viewAs[int64](dst))
+	}
+	return errEncodedFloat
+}
+
+func encodeValues[T integer](dst []byte, enc ColumnEncoding, v []T) ([]byte, error) {
+	switch enc {
+	case EncodingDeltaVarint:
+		var prev uint64
+		for _, x := range v {
+			u := uint64(x)
+			dst = binary.AppendUvarint(dst, zigzag(int64(u-prev)))
+			prev = u
+		}
+	case EncodingDeltaFOR:
+		// The first value stands alone: as a delta from 0 it would widen
+		// the whole first block to the width of an absolute timestamp.
+		if len(v) == 0 {
+			break
+		}
+		prev := uint64(v[0])
+		dst = binary.AppendUvarint(dst, zigzag(int64(prev)))
+		var offs [forBlock]uint64
+		for b := 1; b < len(v); b += forBlock {
+			block := v[b:min(b+forBlock, len(v))]
+			lo := int64(uint64(block[0]) - prev)
+			for j, x := range block {
+				u := uint64(x)
+				d := int64(u - prev)
+				offs[j] = uint64(d) // d for now; offsets below
+				lo = min(lo, d)
+				prev = u
+			}
+			var width int
+			for j := range block {
+				offs[j] -= uint64(lo)
+				width = max(width, bits.Len64(offs[j]))
+			}
+			dst = binary.AppendUvarint(dst, zigzag(lo))
+			dst = append(dst, uint8(width))
+			dst = appendPacked(dst, offs[:len(block)], uint(width))
+		}
+	case EncodingRLE:
+		for i := 0; i < len(v); {
+			j := i + 1
+			for j < len(v) && v[j] == v[i] {
+				j++
+			}
+			dst = binary.AppendUvarint(dst, uint64(j-i))
+			dst = binary.AppendUvarint(dst, zigzag(int64(v[i])))
+			i = j
+		}
+	case EncodingDict:
+		var dict []T
+		idx := make([]uint64, len(v))
+		pos := make(map[T]uint64)
+		for i, x := range v {
+			p, ok := pos[x]
+			if !ok {
+				p = uint64(len(dict))
+				pos[x] = p
+				dict = append(dict, x)
+			}
+			idx[i] = p
+		}
+		dst = binary.AppendUvarint(dst, uint64(len(dict)))
+		for _, x := range dict {
+			dst = binary.AppendUvarint(dst, zigzag(int64(x)))
+		}
+		width := 0
+		if len(dict) > 1 {
+			width = bits.Len64(uint64(len(dict) - 1))
+		}
+		dst = append(dst, uint8(width))
+		dst = appendPacked(dst, idx, uint(width))
+	default:
+		return dst, fmt.Errorf("cannot encode as %s", enc)
+	}
+	return dst, nil
+}
+
+var errEncodedShort = errors.New("encoded column ends early")
+
+func decodeValues[T integer](enc ColumnEncoding, src []byte, v []T) error {
+	// p past len(src) marks a truncated column; reads then return zeros.
+	p := 0
+	uvarint := func() uint64 {
+		if p >= len(src) {
+			p = len(src) + 1
+			return 0
+		}
+		u, n := binary.Uvarint(src[p:])
+		if n <= 0 {
+			p = len(src) + 1
+			return 0
+		}
+		p += n
+		return u
+	}
+
+	switch enc {
+	case EncodingDeltaVarint:
+		var prev uint64
+		for i := range v {
+			var u uint64
+			if p < len(src) && src[p] < 0x80 { // one-byte fast path
+				u = uint64(src[p])
+				p++
+			} else {
+				u = uvarint()
+			}
+			prev += uint64(unzigzag(u))
+			v[i] = T(prev)
+		}
+	case EncodingDeltaFOR:
+		if len(v) == 0 {
+			break
+		}
+		prev := uint64(unzigzag(uvarint()))
+		v[0] = T(prev)
+		for b := 1; b < len(v); b += forBlock {
+			block := v[b:min(b+forBlock, len(v))]
+			lo := uint64(unzigzag(uvarint()))
+			if p >= len(src) {
+				return errEncodedShort
+			}
+			width := uint(src[p])
+			p++
+			nb := (len(block)*int(width) + 7) / 8
+			if width > 64 || p+nb > len(src) {
+				return errEncodedShort
+			}
+			packed := src[p : p+nb]
+			p += nb
+			for j := range block {
+				prev += lo + unpackBits(packed, uint(j)*width, width)
+				block[j] = T(prev)
+			}
+		}
+	case EncodingRLE:
+		for i := 0; i < len(v); {
+			n := uvarint()
+			x := T(unzigzag(uvarint()))
+			if p > len(src) || n == 0 || n > uint64(len(v)-i) {
+				return fmt.Errorf("bad run of %d at row %d", n, i)
+			}
+			run := v[i : i+int(n)]
+			for j := range run {
+				run[j] = x
+			}
+			i += int(n)
+		}
+	case EncodingDict:
+		k := uvarint()
+		if k > uint64(len(src)) {
+			return fmt.Errorf("dictionary of %d values in %d bytes", k, len(src))
+		}
+		dict := make([]T, k)
+		for i := range dict {
+			dict[i] = T(unzigzag(uvarint()))
+		}
+		if p >= len(src) {
+			return errEncodedShort
+		}
+		width := uint(src[p])
+		p++
+		nb := (len(v)*int(width) + 7) / 8
+		if width > 64 || p+nb > len(src) {
+			return errEncodedShort
+		}
+		packed := src[p : p+nb]
+		p += nb
+		if len(v) > 0 && k == 0 {
+			return errors.New("empty dictionary")
+		}
+		for i := range v {
+			j := unpackBits(packed, uint(i)*width, width)
+			if j >= k {
+				return fmt.Errorf("dictionary index %d of %d", j, k)
+			}
+			v[i] = dict[j]
+		}
+	default:
+		return fmt.Errorf("unsupported encoding %s", enc)
+	}
+
+	if p != len(src) {
+		if p > len(src) {
+			return errEncodedShort
+		}
+		return fmt.Errorf("%d trailing bytes after %d values", len(src)-p, len(v))
+	}
+	return nil
+}
+
+func zigzag(d int64) uint64 {
+	return uint64(d<<1) ^ uint64(d>>63)
+}
+
+func unzigzag(u uint64) int64 {
+	return int64(u>>1) ^ -int64(u&1)
+}
+
+// appendPacked appends vals, width bits each, LSB-first.
+func appendPacked(dst []byte, vals []uint64, width uint) []byte {
+	if width == 0 {
+		return dst
+	}
+	var acc uint64
+	var n uint // bits in acc
+	for _, v := range vals {
+		acc |= v << n
+		if n+width < 64 {
+			n += width
+			continue
+		}
+		dst = binary.LittleEndian.AppendUint64(dst, acc)
+		spill := n + width - 64
+		acc, n = 0, spill
+		if spill > 0 {
+			acc = v >> (width - spill)
+		}
+	}
+	for ; n > 0; n -= min(n, 8) {
+		dst = append(dst, byte(acc))
+		acc >>= 8
+	}
+	return dst
+}
+
+// unpackBits returns the width-bit value at bit pos of packed.
+func unpackBits(packed []byte, pos, width uint) uint64 {
+	if width == 0 {
+		return 0
+	}
+	i, sh := pos>>3, pos&7
+	var w uint64
+	if int(i)+8 <= len(packed) {
+		w = binary.LittleEndian.Uint64(packed[i:])
+	} else {
+		var b [8]byte
+		copy(b[:], packed[i:])
+		w = binary.LittleEndian.Uint64(b[:])
+	}
+	v := w >> sh
+	if sh+width > 64 {
+		v |= uint64(packed[i+8]) << (64 - sh)
+	}
+	if width < 64 {
+		v &= 1<<width - 1
+	}
+	return v
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// Side-channel event log: DBN status (0x12), error (0x15) and system (0x17)
+// records. They carry no prices, so instead of a .quantdev column layout they
+// go to "<name>.events.tsv" next to the .quantdev output.
+//
+// Status body (40 bytes total):
+//
+//	[16:24] ts_recv (u64)
+//	[24:26] action (u16, StatusAction*)
+//	[26:28] reason (u16)
+//	[28:30] trading_event (u16)
+//	[30]    is_trading (char: 'Y','N','~')
+//	[31]    is_quoting (char)
+//	[32]    is_short_sell_restricted (char)
+//
+// Error / system body: v1 is a 64-byte message (80 bytes total); v2+ is a
+// 302-byte error text + code + is_last, or a 303-byte system text + code
+// (320 bytes total).
+// -----------------------------------------------------------------------------
+
+const (
+	RTypeStatus = 0x12
+	RTypeError  = 0x15
+	RTypeSystem = 0x17
+
+	RecSizeStatus = 40
+
+	EventLogSuffix = ".events.tsv"
+)
+
+// DBN status actions.
+const (
+	StatusActionNone uint16 = iota
+	StatusActionPreOpen
+	StatusActionPreCross
+	StatusActionQuoting
+	StatusActionCross
+	StatusActionRotation
+	StatusActionNewPriceIndication
+	StatusActionTrading
+	StatusActionHalt
+	StatusActionPause
+	StatusActionSuspend
+	StatusActionPreClose
+	StatusActionClose
+	StatusActionPostClose
+	StatusActionSsrChange
+	StatusActionNotAvailableForTrading
+)
+
+var statusActionNames = [...]string{
+	"none", "pre_open", "pre_cross", "quoting", "cross", "rotation",
+	"new_price_indication", "trading", "halt", "pause", "suspend",
+	"pre_close", "close", "post_close", "ssr_change", "not_available",
+}
+
+type EventKind uint8
+
+const (
+	EventStatus EventKind = iota + 1
+	EventError
+	EventSystem
+)
+
+var eventKindNames = [...]string{EventStatus: "status", EventError: "error", EventSystem: "system"}
+
+func (k EventKind) String() string {
+	if int(k) < len(eventKindNames) && eventKindNames[k] != "" {
+		return eventKindNames[k]
+	}
+	return fmt.Sprintf("kind(%d)", k)
+}
+
+// MarketEvent is one decoded status, error or system record. Status fields
+// are zero for error/system events and vice versa.
+type MarketEvent struct {
+	Kind    EventKind
+	TsEvent uint64
+	TsRecv  uint64
+	PubID   uint16
+	InstrID uint32
+
+	Action       uint16
+	Reason       uint16
+	TradingEvent uint16
+	IsTrading    byte // 'Y', 'N', '~' (not reported), 0 for non-status
+
+	Code uint8
+	Text string
+}
+
+func (e *MarketEvent) ActionName() string {
+	if int(e.Action) < len(statusActionNames) {
+		return statusActionNames[e.Action]
+	}
+	return fmt.Sprintf("action(%d)", e.Action)
+}
+
+// Halted reports whether a status event stops trading.
+func (e *MarketEvent) Halted() bool {
+	if e.Kind != EventStatus {
+		return false
+	}
+	switch e.Action {
+	case StatusActionHalt, StatusActionPause, StatusActionSuspend,
+		StatusActionClose, StatusActionNotAvailableForTrading:
+		return true
+	}
+	return e.IsTrading == 'N'
+}
+
+// Resumed reports whether a status event (re)opens continuous trading.
+func (e *MarketEvent) Resumed() bool {
+	if e.Kind != EventStatus {
+		return false
+	}
+	return e.Action == StatusActionTrading || e.IsTrading == 'Y'
+}
+
+// decodeMarketEvent decodes a status, error or system record. ok is false for
+// other rtypes and for records too short for their rtype.
+func decodeMarketEvent(rec []byte) (ev MarketEvent, ok bool) {
+	if len(rec) < 16 {
+		return ev, false
+	}
+	ev.PubID = binary.LittleEndian.Uint16(rec[2:4])
+	ev.InstrID = binary.LittleEndian.Uint32(rec[4:8])
+	ev.TsEvent = binary.LittleEndian.Uint64(rec[8:16])
+
+	switch rec[1] {
+	case RTypeStatus:
+		if len(rec) < RecSizeStatus {
+			return ev, false
+		}
+		ev.Kind = EventStatus
+		ev.TsRecv = binary.LittleEndian.Uint64(rec[16:24])
+		ev.Action = binary.LittleEndian.Uint16(rec[24:26])
+		ev.Reason = binary.LittleEndian.Uint16(rec[26:28])
+		ev.TradingEvent = binary.LittleEndian.Uint16(rec[28:30])
+		ev.IsTrading = rec[30]
+	case RTypeError, RTypeSystem:
+		ev.Kind = EventError
+		textLen := 302
+		if rec[1] == RTypeSystem {
+			ev.Kind = EventSystem
+			textLen = 303
+		}
+		switch {
+		case len(rec) >= 16+textLen+1:
+			ev.Text = cstrAt(rec, 16, textLen)
+			ev.Code = rec[16+textLen]
+		case len(rec) >= 80:
+			ev.Text = cstrAt(rec, 16, 64) // v1
+		default:
+			return ev, false
+		}
+	default:
+		return ev, false
+	}
+	return ev, true
+}
+
+// EventLogPath returns the side-channel log path for a .quantdev file.
+func EventLogPath(quantdevPath string) string {
+	return strings.TrimSuffix(quantdevPath, filepath.Ext(quantdevPath)) + EventLogSuffix
+}
+
+// -----------------------------------------------------------------------------
+// Persistence
+// -----------------------------------------------------------------------------
+
+const eventLogHeader = "ts_event\tts_recv\tkind\tpublisher_id\tinstrument_id\taction\treason\ttrading_event\tis_trading\tcode\ttext"
+
+// WriteEventLog writes events to path. `data` writes the log under its temp
+// name and renames it with the file's other outputs (commitOutputs).
+func WriteEventLog(path string, events []MarketEvent) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	fmt.Fprintln(w, eventLogHeader)
+	for i := range events {
+		e := &events[i]
+		isTrading := ""
+		if e.IsTrading != 0 {
+			isTrading = string(e.IsTrading)
+		}
+		fmt.Fprintf(w, "%d\t%d\t%s\t%d\t%d\t%d\t%d\t%d\t%s\t%d\t%s\n",
+			e.TsEvent, e.TsRecv, e.Kind, e.PubID, e.InstrID,
+			e.Action, e.Reason, e.TradingEvent, isTrading, e.Code,
+			strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(e.Text))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// ReadEventLog reads a log written by WriteEventLog. A missing file yields
+// no events and no error.
+func ReadEventLog(path string) ([]MarketEvent, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var events []MarketEvent
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if text == "" || strings.HasPrefix(text, "ts_event\t") {
+			continue
+		}
+		fields := strings.SplitN(text, "\t", 11)
+		if len(fields) != 11 {
+			return nil, fmt.Errorf("%s:%d: want 11 fields, got %d", path, line, len(fields))
+		}
+
+		var e MarketEvent
+		e.TsEvent, _ = strconv.ParseUint(fields[0], 10, 64)
+		e.TsRecv, _ = strconv.ParseUint(fields[1], 10, 64)
+		switch fields[2] {
+		case "status":
+			e.Kind = EventStatus
+		case "error":
+			e.Kind = EventError
+		case "system":
+			e.Kind = EventSystem
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown event kind %q", path, line, fields[2])
+		}
+		pub, _ := strconv.ParseUint(fields[3], 10, 16)
+		instr, _ := strconv.ParseUint(fields[4], 10, 32)
+		action, _ := strconv.ParseUint(fields[5], 10, 16)
+		reason, _ := strconv.ParseUint(fields[6], 10, 16)
+		tev, _ := strconv.ParseUint(fields[7], 10, 16)
+		code, _ := strconv.ParseUint(fields[9], 10, 8)
+		e.PubID, e.InstrID = uint16(pub), uint32(instr)
+		e.Action, e.Reason, e.TradingEvent = uint16(action), uint16(reason), uint16(tev)
+		if fields[8] != "" {
+			e.IsTrading = fields[8][0]
+		}
+		e.Code = uint8(code)
+		e.Text = fields[10]
+		events = append(events, e)
+	}
+	return events, sc.Err()
+}
+
+// LoadAllEventLogs reads every event log in the working directory. Status
+// data often comes as its own DBN file, so halts are matched to data files
+// by publisher, instrument and time rather than by filename.
+func LoadAllEventLogs() ([]MarketEvent, error) {
+	files, _ := filepath.Glob("*" + EventLogSuffix)
+	var all []MarketEvent
+	for _, f := range files {
+		events, err := ReadEventLog(f)
+		if err != nil {
+			return all, err
+		}
+		all = append(all, events...)
+	}
+	sort.SliceStable(all, func(i, j int) bool { return all[i].TsEvent < all[j].TsEvent })
+	return all, nil
+}
+
+// -----------------------------------------------------------------------------
+// Halt windows
+// -----------------------------------------------------------------------------
+
+// InstrumentKey identifies an instrument across venues: instrument ids are
+// only unique within a publisher.
+type InstrumentKey struct {
+	PubID   uint16
+	InstrID uint32
+}
+
+// HaltWindow is a [Start, End) period in which an instrument was not trading.
+// End is ^uint64(0) when the log ends before trading resumed.
+type HaltWindow struct {
+	PubID   uint16
+	InstrID uint32
+	Start   uint64
+	End     uint64
+}
+
+// HaltWindows turns status events into halt periods for the instruments in
+// keys (all instruments when keys is empty). Status events for instrument 0
+// apply to every instrument of their publisher. events must be sorted by
+// TsEvent.
+func HaltWindows(events []MarketEvent, keys ...InstrumentKey) []HaltWindow {
+	want := func(k InstrumentKey) bool {
+		if len(keys) == 0 {
+			return true
+		}
+		for _, x := range keys {
+			if x.PubID == k.PubID && (k.InstrID == 0 || x.InstrID == k.InstrID) {
+				return true
+			}
+		}
+		return false
+	}
+
+	open := make(map[InstrumentKey]uint64) // -> halt start
+	var out []HaltWindow
+	for i := range events {
+		e := &events[i]
+		k := InstrumentKey{e.PubID, e.InstrID}
+		if e.Kind != EventStatus || !want(k) {
+			continue
+		}
+		start, halted := open[k]
+		switch {
+		case e.Halted() && !halted:
+			open[k] = e.TsEvent
+		case e.Resumed() && halted:
+			out = append(out, HaltWindow{PubID: k.PubID, InstrID: k.InstrID, Start: start, End: e.TsEvent})
+			delete(open, k)
+		}
+	}
+	for k, start := range open {
+		out = append(out, HaltWindow{PubID: k.PubID, InstrID: k.InstrID, Start: start, End: ^uint64(0)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Start < out[j].Start })
+	return out
+}
+
+// overlaps reports whether the halt intersects [t0, t1].
+func (h HaltWindow) overlaps(t0, t1 uint64) bool {
+	return h.Start <= t1 && h.End > t0
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// export: TBBO .quantdev files to Arrow IPC (arrow.go) and Parquet
+// (parquet.go) for notebooks.
+//
+// TBBO files stream chunk by chunk and each chunk becomes one Arrow record
+// batch or Parquet row group, written from the column slices without a row
+// loop. MBP-10 files export their top of book as one batch. Fixed-point
+// files export their float64 price views. The file's metadata section goes
+// into the output's key-value metadata.
+// -----------------------------------------------------------------------------
+
+// Export formats.
+const (
+	ExportArrowFile   = "arrow"  // Arrow IPC file (.arrow / Feather v2)
+	ExportArrowStream = "arrows" // Arrow IPC stream (.arrows)
+	ExportParquet     = "parquet"
+)
+
+// exportColumn is one TBBO column as the exporters see it.
+type exportColumn struct {
+	name  string
+	set   ColumnSet
+	dtype DType
+	time  bool // ns since the UNIX epoch
+}
+
+// exportColumns lists the columns of want in file order.
+func exportColumns(want ColumnSet) []exportColumn {
+	schema := layoutSchema(LayoutTBBO, false)
+	var cols []exportColumn
+	for set := want & AllColumns; set != 0; set &= set - 1 {
+		on := set & -set
+		c := schema[bits.TrailingZeros32(uint32(on))]
+		cols = append(cols, exportColumn{
+			name:  c.Name,
+			set:   on,
+			dtype: c.DType,
+			time:  on == ColTsEvent || on == ColTsRecv,
+		})
+	}
+	return cols
+}
+
+// column returns the bytes of one loaded column of a single-column set.
+func (c *TBBOColumns) column(on ColumnSet) []byte {
+	switch on {
+	case ColTsEvent:
+		return asBytes(c.TsEvent)
+	case ColTsRecv:
+		return asBytes(c.TsRecv)
+	case ColTsInDelta:
+		return asBytes(c.TsInDelta)
+	case ColPrices:
+		return asBytes(c.Prices)
+	case ColSizes:
+		return asBytes(c.Sizes)
+	case ColSides:
+		return asBytes(c.Sides)
+	case ColActions:
+		return asBytes(c.Actions)
+	case ColFlags:
+		return asBytes(c.Flags)
+	case ColDepth:
+		return asBytes(c.Depth)
+	case ColSequences:
+		return asBytes(c.Sequences)
+	case ColBidPx:
+		return asBytes(c.BidPx)
+	case ColAskPx:
+		return asBytes(c.AskPx)
+	case ColBidSz:
+		return asBytes(c.BidSz)
+	case ColAskSz:
+		return asBytes(c.AskSz)
+	case ColBidCt:
+		return asBytes(c.BidCt)
+	case ColAskCt:
+		return asBytes(c.AskCt)
+	case ColPublisherID:
+		return asBytes(c.PublisherID)
+	case ColInstrumentID:
+		return asBytes(c.InstrumentID)
+	}
+	return nil
+}
+
+// batchWriter is what ExportQuantDev writes to.
+type batchWriter interface {
+	WriteBatch(c *TBBOColumns) error
+	Close() error
+}
+
+// ExportQuantDev writes the rows and columns of path that q selects to out
+// in format and returns the number of rows written.
+func ExportQuantDev(path, out, format string, q TBBOQuery) (int, error) {
+	hdr, err := ReadQuantDevHeader(path)
+	if err != nil {
+		return 0, err
+	}
+	if hdr.Layout != LayoutTBBO && hdr.Layout != LayoutMBP10 {
+		return 0, fmt.Errorf("cannot export %s files", hdr.LayoutName())
+	}
+	meta, err := ReadQuantDevMeta(path)
+	if err != nil {
+		return 0, err
+	}
+	cols := exportColumns(q.Columns)
+	if len(cols) == 0 {
+		cols = exportColumns(AllColumns)
+	}
+
+	f, err := os.Create(out + TmpSuffix)
+	if err != nil {
+		return 0, err
+	}
+	bw := bufio.NewWriterSize(f, 1<<20)
+	var w batchWriter
+	switch format {
+	case ExportArrowFile, ExportArrowStream:
+		w, err = NewArrowWriter(bw, cols, format == ExportArrowFile, meta)
+	case ExportParquet:
+		w, err = NewParquetWriter(bw, cols, meta)
+	default:
+		err = fmt.Errorf("unknown export format %q (want arrow, arrows, parquet)", format)
+	}
+
+	rows := 0
+	if err == nil {
+		rows, err = exportRows(path, hdr, q, w)
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		err = bw.Flush()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return 0, err
+	}
+	return rows, os.Rename(f.Name(), out)
+}
+
+// exportRows feeds w the selected rows of path, a chunk at a time for TBBO
+// files.
+func exportRows(path string, hdr gncHeader, q TBBOQuery, w batchWriter) (int, error) {
+	if hdr.Layout == LayoutMBP10 {
+		cols, err := LoadQuantDevTBBO(path, q)
+		if err != nil {
+			return 0, err
+		}
+		defer TBBOPool.Put(cols)
+		return cols.Count, w.WriteBatch(cols)
+	}
+
+	r, err := OpenChunkReader(path, q)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	rows := 0
+	for _, c := range r.All() {
+		if err := w.WriteBatch(c); err != nil {
+			return rows, err
+		}
+		rows += c.Count
+	}
+	return rows, r.Err()
+}
+
+// parseTimeArg accepts ns since the UNIX epoch, RFC 3339 or YYYY-MM-DD (UTC).
+func parseTimeArg(s string) (uint64, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if ns, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return ns, nil
+	}
+	for _, layout := range []string{time.RFC3339Nano, "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return uint64(t.UnixNano()), nil
+		}
+	}
+	return 0, fmt.Errorf("bad time %q (want ns, RFC 3339 or YYYY-MM-DD)", s)
+}
+
+// runExport implements
+// `export [-format arrow|arrows|parquet] [-from t] [-to t] [-columns list] [-o out] files...`
+// and reports whether every file was exported.
+func runExport(args []string) bool {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	format := fs.String("format", ExportParquet, "`arrow` (IPC file), arrows (IPC stream) or parquet")
+	from := fs.String("from", "", "first ts_event to export (ns, RFC 3339 or YYYY-MM-DD)")
+	to := fs.String("to", "", "ts_event to stop before")
+	columns := fs.String("columns", "", "comma-separated column names (default: all)")
+	out := fs.String("o", "", "output file (single input only; default: input name with the format's extension)")
+	fs.Parse(args)
+
+	fmt.Println(">>> EXPORT: QuantDev -> Arrow / Parquet <<<")
+
+	var q TBBOQuery
+	var err error
+	if q.TsStart, err = parseTimeArg(*from); err == nil {
+		q.TsEnd, err = parseTimeArg(*to)
+	}
+	if err == nil {
+		q.Columns, err = ParseColumns(*columns)
+	}
+	if err != nil {
+		fmt.Printf("[err] %v\n", err)
+		return false
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files, _ = filepath.Glob("*.quantdev")
+	}
+	if len(files) == 0 {
+		fmt.Println("No .quantdev files found.")
+		return true
+	}
+	if *out != "" && len(files) > 1 {
+		fmt.Println("[err] -o needs exactly one input file")
+		return false
+	}
+
+	ext := map[string]string{ExportArrowFile: ".arrow", ExportArrowStream: ".arrows", ExportParquet: ".parquet"}[*format]
+	ok := true
+	for _, path := range files {
+		dst := *out
+		if dst == "" {
+			dst = strings.TrimSuffix(path, ".quantdev") + ext
+		}
+		rows, err := ExportQuantDev(path, dst, *format, q)
+		if err != nil {
+			fmt.Printf("   [err] %s: %v\n", filepath.Base(path), err)
+			ok = false
+			continue
+		}
+		fmt.Printf(" -> %s: %d rows -> %s\n", filepath.Base(path), rows, dst)
+	}
+	return ok
+}
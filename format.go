@@ -0,0 +1,461 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"os"
+	"slices"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// Format versions and the column directory.
+//
+// Every .quantdev file starts with the GNC4 header (encoder.go writeFooter).
+// header[56:58] holds the format version:
+//
+//	1 (stored as 0)  implicit columns: each layout's columns in the fixed order
+//	                 of layoutSchema, packed after each chunk's row count.
+//	2                column directory in the footer (position at header[48:56]).
+//	3                directory extents carry the CRC32C of their bytes.
+//
+// The directory names every column with its dtype and encoding, and records
+// where each chunk stores it:
+//
+//	[u16 ncols] then ncols x [u8 name len][name][u8 dtype][u8 encoding]
+//	[u32 nchunks] then nchunks x ncols x [u64 offset][u64 bytes][u32 crc32c]
+//
+// (no crc32c before version 3). Loaders check the checksum of every column
+// they read; `verify` checks them all.
+//
+// Readers look columns up by name: columns a reader does not know are never
+// read, and columns a file lacks load as zeros. Adding a column therefore
+// does not change the version; it only changes when existing bytes change
+// meaning. Version 1 files get a directory synthesized from layoutSchema, so
+// every reader goes through the same lookup.
+//
+// GNC3 files (the dictionary/delta format before GNC4) cannot be read and
+// must be converted again from their DBN source.
+// -----------------------------------------------------------------------------
+
+const (
+	FormatImplicit  = 1
+	FormatColumnDir = 2
+	FormatChecksums = 3
+
+	// FormatVersion is what the encoder writes.
+	FormatVersion = FormatChecksums
+)
+
+// crc32c is the Castagnoli table; Go uses the SSE4.2 / ARMv8 CRC
+// instructions for it where available.
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// DType is the element type of a column.
+type DType uint8
+
+const (
+	DTypeU8 DType = iota + 1
+	DTypeI8
+	DTypeU16
+	DTypeU32
+	DTypeI32
+	DTypeU64
+	DTypeI64
+	DTypeF64
+)
+
+var dtypeNames = [...]string{
+	DTypeU8: "u8", DTypeI8: "i8", DTypeU16: "u16", DTypeU32: "u32",
+	DTypeI32: "i32", DTypeU64: "u64", DTypeI64: "i64", DTypeF64: "f64",
+}
+
+var dtypeSizes = [...]int{
+	DTypeU8: 1, DTypeI8: 1, DTypeU16: 2, DTypeU32: 4,
+	DTypeI32: 4, DTypeU64: 8, DTypeI64: 8, DTypeF64: 8,
+}
+
+func (t DType) String() string {
+	if int(t) < len(dtypeNames) && dtypeNames[t] != "" {
+		return dtypeNames[t]
+	}
+	return fmt.Sprintf("dtype(%d)", t)
+}
+
+// Size is the width of one value in bytes, 0 for unknown dtypes.
+func (t DType) Size() int {
+	if int(t) < len(dtypeSizes) {
+		return dtypeSizes[t]
+	}
+	return 0
+}
+
+// dtypeOf maps a Go element type to its DType.
+func dtypeOf[T any]() DType {
+	var zero T
+	switch any(zero).(type) {
+	case uint8:
+		return DTypeU8
+	case int8:
+		return DTypeI8
+	case uint16:
+		return DTypeU16
+	case uint32:
+		return DTypeU32
+	case int32:
+		return DTypeI32
+	case uint64:
+		return DTypeU64
+	case int64:
+		return DTypeI64
+	case float64:
+		return DTypeF64
+	}
+	panic(fmt.Sprintf("no dtype for %T", zero))
+}
+
+// ColumnEncoding is how a column's values are stored within a chunk.
+type ColumnEncoding uint8
+
+// EncodingPlain is n little-endian values of the column's dtype; the others
+// are described in encoding.go.
+const (
+	EncodingPlain ColumnEncoding = iota
+	EncodingDeltaVarint
+	EncodingDeltaFOR
+	EncodingRLE
+	EncodingDict
+)
+
+var encodingNames = [...]string{
+	EncodingPlain:       "plain",
+	EncodingDeltaVarint: "delta-varint",
+	EncodingDeltaFOR:    "delta-for",
+	EncodingRLE:         "rle",
+	EncodingDict:        "dict",
+}
+
+func (e ColumnEncoding) String() string {
+	if int(e) < len(encodingNames) {
+		return encodingNames[e]
+	}
+	return fmt.Sprintf("encoding(%d)", e)
+}
+
+// ColumnDesc describes one column of a file.
+type ColumnDesc struct {
+	Name     string
+	DType    DType
+	Encoding ColumnEncoding
+}
+
+// ColumnExtent is where one chunk stores one column.
+type ColumnExtent struct {
+	Offset uint64
+	Bytes  uint64
+	CRC    uint32 // CRC32C of the bytes, if ColumnDir.Checksums
+}
+
+// ColumnDir is the column directory of a file.
+type ColumnDir struct {
+	Columns []ColumnDesc
+	Extents [][]ColumnExtent // [chunk][column]
+
+	// Synthesized from layoutSchema for a version 1 file.
+	Implicit bool
+	// Extents carry checksums (version 3 on).
+	Checksums bool
+}
+
+// ChecksumError reports a column whose bytes do not match their CRC32C.
+type ChecksumError struct {
+	Chunk  int
+	Column string
+	Offset uint64
+}
+
+func (e *ChecksumError) Error() string {
+	return fmt.Sprintf("column %s at offset %d fails its checksum", e.Column, e.Offset)
+}
+
+// Index returns the position of the named column, or -1.
+func (d *ColumnDir) Index(name string) int {
+	for i := range d.Columns {
+		if d.Columns[i].Name == name {
+			return i
+		}
+	}
+	return -1
+}
+
+// =============================================================================
+//  Layout schemas
+// =============================================================================
+
+// layoutSchema returns the columns the encoder writes for a layout, in file
+// order (the order of the encoder's writeColumns call). exact selects int64
+// TBBO price columns. nil for unknown layouts.
+func layoutSchema(layout uint16, exact bool) []ColumnDesc {
+	switch layout {
+	case LayoutTBBO:
+		px := DTypeF64
+		if exact {
+			px = DTypeI64
+		}
+		dtypes := [len(tbboColumnNames)]DType{
+			DTypeU64, DTypeU64, DTypeI32, px, DTypeF64, DTypeI8, DTypeI8,
+			DTypeU8, DTypeU8, DTypeU32, px, px, DTypeF64, DTypeF64,
+			DTypeU32, DTypeU32, DTypeU16, DTypeU32,
+		}
+		s := make([]ColumnDesc, len(dtypes))
+		for i, t := range dtypes {
+			s[i] = ColumnDesc{Name: tbboColumnNames[i], DType: t}
+		}
+		return s
+	case LayoutTrades:
+		return tradesSchema()
+	case LayoutMBP10:
+		s := tradesSchema()
+		for _, field := range []struct {
+			name  string
+			dtype DType
+		}{
+			{"bid_px", DTypeF64}, {"ask_px", DTypeF64},
+			{"bid_sz", DTypeF64}, {"ask_sz", DTypeF64},
+			{"bid_ct", DTypeU32}, {"ask_ct", DTypeU32},
+		} {
+			for l := 0; l < MBP10Levels; l++ {
+				s = append(s, ColumnDesc{Name: fmt.Sprintf("%s_%02d", field.name, l), DType: field.dtype})
+			}
+		}
+		return s
+	case LayoutOHLCV:
+		return []ColumnDesc{
+			{Name: "ts_event", DType: DTypeU64},
+			{Name: "open", DType: DTypeF64},
+			{Name: "high", DType: DTypeF64},
+			{Name: "low", DType: DTypeF64},
+			{Name: "close", DType: DTypeF64},
+			{Name: "volume", DType: DTypeF64},
+			{Name: "publisher_id", DType: DTypeU16},
+			{Name: "instrument_id", DType: DTypeU32},
+		}
+	}
+	return nil
+}
+
+// tradesSchema matches TradesColumns.columnBytes.
+func tradesSchema() []ColumnDesc {
+	return []ColumnDesc{
+		{Name: "ts_event", DType: DTypeU64},
+		{Name: "ts_recv", DType: DTypeU64},
+		{Name: "ts_in_delta", DType: DTypeI32},
+		{Name: "price", DType: DTypeF64},
+		{Name: "size", DType: DTypeF64},
+		{Name: "side", DType: DTypeI8},
+		{Name: "action", DType: DTypeI8},
+		{Name: "flags", DType: DTypeU8},
+		{Name: "depth", DType: DTypeU8},
+		{Name: "sequence", DType: DTypeU32},
+		{Name: "publisher_id", DType: DTypeU16},
+		{Name: "instrument_id", DType: DTypeU32},
+	}
+}
+
+// =============================================================================
+//  Serialization
+// =============================================================================
+
+// appendColumnDir serializes a directory in the current format (layout at
+// the top of this file).
+func appendColumnDir(b []byte, d *ColumnDir) []byte {
+	b = binary.LittleEndian.AppendUint16(b, uint16(len(d.Columns)))
+	for _, c := range d.Columns {
+		b = append(b, uint8(len(c.Name)))
+		b = append(b, c.Name...)
+		b = append(b, uint8(c.DType), uint8(c.Encoding))
+	}
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(d.Extents)))
+	for _, chunk := range d.Extents {
+		for _, e := range chunk {
+			b = binary.LittleEndian.AppendUint64(b, e.Offset)
+			b = binary.LittleEndian.AppendUint64(b, e.Bytes)
+			b = binary.LittleEndian.AppendUint32(b, e.CRC)
+		}
+	}
+	return b
+}
+
+// parseColumnDir is the inverse of appendColumnDir for a file of the given
+// format version.
+func parseColumnDir(buf []byte, version uint16) (*ColumnDir, error) {
+	c := &dbnCursor{buf: buf}
+	d := &ColumnDir{Checksums: version >= FormatChecksums}
+
+	n := int(c.u16())
+	for i := 0; i < n && c.err == nil; i++ {
+		name := string(c.take(int(c.u8())))
+		d.Columns = append(d.Columns, ColumnDesc{
+			Name:     name,
+			DType:    DType(c.u8()),
+			Encoding: ColumnEncoding(c.u8()),
+		})
+	}
+	chunks := c.count()
+	for k := 0; k < chunks && c.err == nil; k++ {
+		ext := make([]ColumnExtent, n)
+		for i := range ext {
+			ext[i] = ColumnExtent{Offset: c.u64(), Bytes: c.u64()}
+			if d.Checksums {
+				ext[i].CRC = c.u32()
+			}
+		}
+		d.Extents = append(d.Extents, ext)
+	}
+
+	if c.err != nil {
+		return nil, fmt.Errorf("column directory: %w", c.err)
+	}
+	return d, nil
+}
+
+// readColumnDir reads the directory of a version 2 file, or synthesizes one
+// for an older file from its layout and chunk row counts, and checks every
+// extent lies in the chunk area.
+func readColumnDir(f *os.File, hdr gncHeader, chunks []ChunkInfo) (*ColumnDir, error) {
+	var d *ColumnDir
+	if hdr.DirPos != 0 {
+		st, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		end := hdr.sectionEnd(hdr.DirPos, uint64(st.Size()))
+		if hdr.DirPos >= end {
+			return nil, fmt.Errorf("column directory at %d past end of file", hdr.DirPos)
+		}
+		buf := make([]byte, end-hdr.DirPos)
+		if _, err := f.ReadAt(buf, int64(hdr.DirPos)); err != nil {
+			return nil, fmt.Errorf("reading column directory: %w", err)
+		}
+		if d, err = parseColumnDir(buf, hdr.Version); err != nil {
+			return nil, err
+		}
+		if len(d.Extents) != len(chunks) {
+			return nil, fmt.Errorf("column directory covers %d chunks, index has %d", len(d.Extents), len(chunks))
+		}
+	} else {
+		schema := layoutSchema(hdr.Layout, hdr.PriceEnc == PriceFixedInt64)
+		if schema == nil {
+			return nil, fmt.Errorf("no column directory and unknown layout %d", hdr.Layout)
+		}
+		d = implicitColumnDir(schema, chunks)
+	}
+
+	for k, chunk := range d.Extents {
+		for i, e := range chunk {
+			if e.Bytes != 0 && (e.Offset < 64 || e.Offset+e.Bytes > hdr.FooterPos) {
+				return nil, fmt.Errorf("corrupt chunk %d: column %s at [%d,%d) outside the chunk area",
+					k, d.Columns[i].Name, e.Offset, e.Offset+e.Bytes)
+			}
+		}
+	}
+	return d, nil
+}
+
+// implicitColumnDir lays schema out the way version 1 files store it: all
+// columns back to back after each chunk's u32 row count.
+func implicitColumnDir(schema []ColumnDesc, chunks []ChunkInfo) *ColumnDir {
+	d := &ColumnDir{Columns: slices.Clone(schema), Implicit: true}
+	d.Extents = make([][]ColumnExtent, len(chunks))
+	for k := range chunks {
+		pos := chunks[k].Offset + 4
+		ext := make([]ColumnExtent, len(schema))
+		for i, c := range schema {
+			n := uint64(chunks[k].Rows * c.DType.Size())
+			ext[i] = ColumnExtent{Offset: pos, Bytes: n}
+			pos += n
+		}
+		d.Extents[k] = ext
+	}
+	return d
+}
+
+// =============================================================================
+//  Column reads
+// =============================================================================
+
+// readColumnBytes reads chunk k's copy of column want into dst, which is
+// sized for the chunk's rows, checking it against its checksum and decoding
+// it if it is encoded. A column the file lacks reads as zeros.
+func readColumnBytes(f *os.File, d *ColumnDir, k int, want ColumnDesc, dst []byte) error {
+	i := d.Index(want.Name)
+	if i < 0 {
+		clear(dst)
+		return nil
+	}
+	e, err := d.extent(k, i, want.DType, len(dst))
+	if err != nil {
+		return err
+	}
+	enc := d.Columns[i].Encoding
+	buf := dst
+	if enc != EncodingPlain {
+		b := encodedBufs.Get().(*[]byte)
+		defer encodedBufs.Put(b)
+		*b = resize(*b, int(e.Bytes))
+		buf = *b
+	}
+	if len(buf) > 0 {
+		if _, err := f.ReadAt(buf, int64(e.Offset)); err != nil {
+			return fmt.Errorf("column %s: %w", want.Name, err)
+		}
+	}
+	if err := d.check(k, i, buf); err != nil {
+		return err
+	}
+	if enc != EncodingPlain {
+		if err := decodeColumn(enc, want.DType, buf, dst); err != nil {
+			return fmt.Errorf("column %s (%s): %w", want.Name, enc, err)
+		}
+	}
+	return nil
+}
+
+// encodedBufs holds read buffers for encoded columns.
+var encodedBufs = sync.Pool{New: func() any { return new([]byte) }}
+
+// check compares b, chunk k's bytes of column i, with the stored checksum.
+func (d *ColumnDir) check(k, i int, b []byte) error {
+	if !d.Checksums {
+		return nil
+	}
+	e := d.Extents[k][i]
+	if crc32.Checksum(b, crc32c) != e.CRC {
+		return &ChecksumError{Chunk: k, Column: d.Columns[i].Name, Offset: e.Offset}
+	}
+	return nil
+}
+
+// readColumnInto is readColumnBytes for a typed destination.
+func readColumnInto[T any](f *os.File, d *ColumnDir, k int, name string, dst []T) error {
+	return readColumnBytes(f, d, k, ColumnDesc{Name: name, DType: dtypeOf[T]()}, asBytes(dst))
+}
+
+// extent returns where chunk k stores column i after checking the column
+// is of dtype in an encoding this build reads, and for plain columns that it
+// holds n bytes.
+func (d *ColumnDir) extent(k, i int, dtype DType, n int) (ColumnExtent, error) {
+	c := &d.Columns[i]
+	if c.DType != dtype {
+		return ColumnExtent{}, fmt.Errorf("column %s is %s, want %s", c.Name, c.DType, dtype)
+	}
+	if int(c.Encoding) >= len(encodingNames) {
+		return ColumnExtent{}, fmt.Errorf("column %s: unsupported encoding %s", c.Name, c.Encoding)
+	}
+	e := d.Extents[k][i]
+	if c.Encoding == EncodingPlain && e.Bytes != uint64(n) {
+		return ColumnExtent{}, fmt.Errorf("column %s: chunk %d holds %d bytes, want %d", c.Name, k, e.Bytes, n)
+	}
+	return e, nil
+}
@@ -0,0 +1,597 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// -----------------------------------------------------------------------------
+// import: CSV/TSV files of TBBO-like rows (other vendors, our fills log) into
+// TBBO .quantdev files, so they go through the same test and check pipeline
+// as converted DBN.
+//
+// Rows stream from the reader into Encoder.AddRow; nothing is buffered
+// beyond the encoder's chunk. The first line is a header. A mapping spec
+// says which header feeds which TBBO column and how its text converts:
+//
+//	# TBBO column = header name
+//	ts_event      = exch_time
+//	price         = px
+//	size          = qty
+//	side          = aggressor
+//	instrument_id.default = 42      # constant when the column is absent or empty
+//	ts_event.format = 2006-01-02 15:04:05.999999999
+//	time_zone     = America/Chicago # for formats without a zone
+//	price_scale   = 1               # currency units per price unit (1e-9: DBN fixed-9)
+//	sides         = BUY:B, SELL:A   # extra side spellings -> B, A or N
+//	actions       = FILL:T          # extra action spellings -> DBN action char
+//	delimiter     = tab             # default: tab for .tsv/.tab, comma otherwise
+//	asset         = MES             # metadata: AssetConfigs key for test
+//	dataset       = VENDOR.X        # metadata
+//
+// Without a spec, headers are matched by TBBO column name or by the DBN field
+// names of raw.txt (bid_px_00 ...), timestamps and prices are read as DBN
+// integers, and sides/actions as DBN chars.
+// -----------------------------------------------------------------------------
+
+// Timestamp formats besides Go layouts.
+const (
+	TimeAuto    = "auto" // integer ns since the epoch, else RFC 3339
+	TimeNanos   = "ns"
+	TimeMicros  = "us"
+	TimeMillis  = "ms"
+	TimeSeconds = "s" // fractional seconds allowed
+	TimeRFC3339 = "rfc3339"
+)
+
+// ImportSpec maps the columns of a CSV/TSV file to TBBO columns.
+type ImportSpec struct {
+	Columns     map[string]string // TBBO column name -> header name
+	Defaults    map[string]string // TBBO column name -> text for absent or empty cells
+	TimeFormats map[string]string // ts_event / ts_recv -> format; TimeFormat if unset
+	TimeFormat  string            // TimeAuto, TimeNanos ... or a Go time layout
+	Location    *time.Location    // zone for layouts without one; UTC if nil
+	PriceScale  float64           // currency units per unit of the price columns
+	Sides       map[string]int8   // cell text -> side (+1 bid/buy, -1 ask/sell, 0 none)
+	Actions     map[string]int8   // cell text -> DBN action char
+	Delimiter   rune              // 0: by file extension
+	Dataset     string            // MetaDataset
+	Asset       string            // MetaAsset
+}
+
+// dbnFieldNames are raw.txt's names for the TBBO columns whose DBN name
+// differs from tbboColumnNames.
+var dbnFieldNames = map[string]string{
+	"bid_px": "bid_px_00", "ask_px": "ask_px_00",
+	"bid_sz": "bid_sz_00", "ask_sz": "ask_sz_00",
+	"bid_ct": "bid_ct_00", "ask_ct": "ask_ct_00",
+}
+
+// DefaultImportSpec reads DBN-shaped CSV: header names as in raw.txt,
+// integer ns timestamps and fixed-9 integer prices.
+func DefaultImportSpec() *ImportSpec {
+	return &ImportSpec{
+		Columns:     map[string]string{},
+		Defaults:    map[string]string{},
+		TimeFormats: map[string]string{},
+		TimeFormat:  TimeAuto,
+		PriceScale:  PxScale,
+		Sides:       map[string]int8{"B": 1, "A": -1, "N": 0},
+		Actions:     map[string]int8{},
+	}
+}
+
+// ParseImportSpec reads a mapping spec (format at the top of this file) on
+// top of DefaultImportSpec.
+func ParseImportSpec(path string) (*ImportSpec, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s := DefaultImportSpec()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text, _, _ := strings.Cut(sc.Text(), "#")
+		if strings.TrimSpace(text) == "" {
+			continue
+		}
+		key, val, ok := strings.Cut(text, "=")
+		key, val = strings.TrimSpace(key), strings.TrimSpace(val)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s:%d: want key = value", path, line)
+		}
+		if err := s.set(key, val); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ImportSpec) set(key, val string) error {
+	col, opt, _ := strings.Cut(key, ".")
+	if slices.Contains(tbboColumnNames[:], col) {
+		switch opt {
+		case "":
+			s.Columns[col] = val
+		case "default":
+			s.Defaults[col] = val
+		case "format":
+			if col != "ts_event" && col != "ts_recv" {
+				return fmt.Errorf("%s is not a timestamp column", col)
+			}
+			s.TimeFormats[col] = val
+		default:
+			return fmt.Errorf("unknown option %q (want default or format)", opt)
+		}
+		return nil
+	}
+
+	switch key {
+	case "time_format":
+		s.TimeFormat = val
+	case "time_zone":
+		loc, err := time.LoadLocation(val)
+		if err != nil {
+			return err
+		}
+		s.Location = loc
+	case "price_scale":
+		v, err := strconv.ParseFloat(val, 64)
+		if err != nil || v <= 0 {
+			return fmt.Errorf("bad price_scale %q", val)
+		}
+		s.PriceScale = v
+	case "sides":
+		return parseCharMap(val, func(k string, c byte) error {
+			switch c {
+			case 'B':
+				s.Sides[k] = 1
+			case 'A':
+				s.Sides[k] = -1
+			case 'N':
+				s.Sides[k] = 0
+			default:
+				return fmt.Errorf("side %q: want B, A or N", string(c))
+			}
+			return nil
+		})
+	case "actions":
+		return parseCharMap(val, func(k string, c byte) error {
+			s.Actions[k] = int8(c)
+			return nil
+		})
+	case "delimiter":
+		switch val {
+		case "tab", `\t`:
+			s.Delimiter = '\t'
+		default:
+			r, n := utf8.DecodeRuneInString(val)
+			if n == 0 || n != len(val) {
+				return fmt.Errorf("bad delimiter %q", val)
+			}
+			s.Delimiter = r
+		}
+	case "dataset":
+		s.Dataset = val
+	case "asset":
+		s.Asset = val
+	default:
+		return fmt.Errorf("unknown key %q", key)
+	}
+	return nil
+}
+
+// parseCharMap reads "TEXT:C, TEXT:C" pairs, C a single ASCII char.
+func parseCharMap(val string, put func(string, byte) error) error {
+	for _, pair := range strings.Split(val, ",") {
+		k, c, ok := strings.Cut(strings.TrimSpace(pair), ":")
+		if !ok || k == "" || len(c) != 1 {
+			return fmt.Errorf("bad pair %q (want TEXT:C)", pair)
+		}
+		if err := put(k, c[0]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImportStats is what ImportCSV did.
+type ImportStats struct {
+	Rows    int // rows written
+	Skipped int // rows without a price
+}
+
+// csvRow turns CSV records into tbboRows for one header.
+type csvRow struct {
+	spec  *ImportSpec
+	idx   [len(tbboColumnNames)]int // header index per TBBO column, -1 if absent
+	pxMul int64                     // PriceScale/PxScale when it is a whole number, else 0
+}
+
+func newCSVRow(spec *ImportSpec, header []string) (*csvRow, error) {
+	p := &csvRow{spec: spec}
+	for i, col := range tbboColumnNames {
+		want := []string{col, dbnFieldNames[col]}
+		if h, ok := spec.Columns[col]; ok {
+			want = []string{h}
+		}
+		p.idx[i] = -1
+		for j, h := range header {
+			if h = strings.TrimSpace(h); h != "" && slices.Contains(want, h) {
+				p.idx[i] = j
+				break
+			}
+		}
+		if _, ok := spec.Columns[col]; ok && p.idx[i] < 0 {
+			return nil, fmt.Errorf("column %q (for %s) not in header", spec.Columns[col], col)
+		}
+	}
+	for _, on := range []ColumnSet{ColTsEvent, ColPrices} {
+		if p.idx[bits.TrailingZeros32(uint32(on))] < 0 && spec.Defaults[on.name()] == "" {
+			return nil, fmt.Errorf("no column for %s", on.name())
+		}
+	}
+	if m := math.Round(spec.PriceScale / PxScale); m >= 1 && math.Abs(m*PxScale-spec.PriceScale) <= 1e-6*spec.PriceScale {
+		p.pxMul = int64(m)
+	}
+	return p, nil
+}
+
+// cell is the text of column on, or its default when absent or empty.
+func (p *csvRow) cell(rec []string, on ColumnSet) string {
+	if j := p.idx[bits.TrailingZeros32(uint32(on))]; j >= 0 && j < len(rec) {
+		if s := strings.TrimSpace(rec[j]); s != "" {
+			return s
+		}
+	}
+	return p.spec.Defaults[on.name()]
+}
+
+// parse fills r from rec. It reports false for rows without a price.
+func (p *csvRow) parse(rec []string, r *tbboRow) (bool, error) {
+	var err error
+	field := func(on ColumnSet, parse func(string) error) {
+		if err != nil {
+			return
+		}
+		if s := p.cell(rec, on); s != "" {
+			if e := parse(s); e != nil {
+				err = fmt.Errorf("%s: %w", on.name(), e)
+			}
+		}
+	}
+	unsigned := func(dst *uint64, bits int) func(string) error {
+		return func(s string) error {
+			v, e := strconv.ParseUint(s, 10, bits)
+			if e != nil {
+				// Sizes sometimes come as "3.0".
+				f, fe := strconv.ParseFloat(s, 64)
+				if fe != nil || f < 0 || f != math.Trunc(f) || f >= math.Ldexp(1, bits) {
+					return e
+				}
+				v = uint64(f)
+			}
+			*dst = v
+			return nil
+		}
+	}
+
+	*r = tbboRow{pxRaw: NullPrice, bidPxRaw: NullPrice, askPxRaw: NullPrice, action: 'T'}
+	var pub, instr, size, flags, depth, seq, bs, as, bc, ac uint64
+	var delta int64
+
+	field(ColTsEvent, func(s string) (e error) { r.tsEvent, e = p.time(s, ColTsEvent); return })
+	field(ColTsRecv, func(s string) (e error) { r.tsRecv, e = p.time(s, ColTsRecv); return })
+	field(ColTsInDelta, func(s string) (e error) { delta, e = strconv.ParseInt(s, 10, 32); return })
+	field(ColPrices, func(s string) (e error) { r.pxRaw, e = p.price(s); return })
+	field(ColSizes, unsigned(&size, 32))
+	field(ColSides, func(s string) (e error) { r.side, e = p.side(s); return })
+	field(ColActions, func(s string) (e error) { r.action, e = p.action(s); return })
+	field(ColFlags, unsigned(&flags, 8))
+	field(ColDepth, unsigned(&depth, 8))
+	field(ColSequences, unsigned(&seq, 32))
+	field(ColBidPx, func(s string) (e error) { r.bidPxRaw, e = p.price(s); return })
+	field(ColAskPx, func(s string) (e error) { r.askPxRaw, e = p.price(s); return })
+	field(ColBidSz, unsigned(&bs, 32))
+	field(ColAskSz, unsigned(&as, 32))
+	field(ColBidCt, unsigned(&bc, 32))
+	field(ColAskCt, unsigned(&ac, 32))
+	field(ColPublisherID, unsigned(&pub, 16))
+	field(ColInstrumentID, unsigned(&instr, 32))
+	if err != nil {
+		return false, err
+	}
+
+	if r.tsRecv == 0 {
+		r.tsRecv = r.tsEvent
+	}
+	r.tsInDelta = int32(delta)
+	r.size, r.flags, r.depth, r.seq = uint32(size), uint8(flags), uint8(depth), uint32(seq)
+	r.bidSz, r.askSz, r.bidCt, r.askCt = uint32(bs), uint32(as), uint32(bc), uint32(ac)
+	r.pubID, r.instrID = uint16(pub), uint32(instr)
+	return r.pxRaw != NullPrice, nil
+}
+
+// time parses a timestamp of column on to ns since the UNIX epoch.
+func (p *csvRow) time(s string, on ColumnSet) (uint64, error) {
+	format := p.spec.TimeFormats[on.name()]
+	if format == "" {
+		format = p.spec.TimeFormat
+	}
+	loc := p.spec.Location
+	if loc == nil {
+		loc = time.UTC
+	}
+	switch format {
+	case TimeNanos:
+		return strconv.ParseUint(s, 10, 64)
+	case TimeMicros:
+		return parseEpoch(s, 1e3)
+	case TimeMillis:
+		return parseEpoch(s, 1e6)
+	case TimeSeconds:
+		return parseEpoch(s, 1e9)
+	case TimeAuto:
+		if ns, err := strconv.ParseUint(s, 10, 64); err == nil {
+			return ns, nil
+		}
+		format = time.RFC3339Nano
+	case TimeRFC3339:
+		format = time.RFC3339Nano
+	}
+	t, err := time.ParseInLocation(format, s, loc)
+	if err != nil {
+		return 0, err
+	}
+	if t.UnixNano() < 0 {
+		return 0, fmt.Errorf("%s before 1970", s)
+	}
+	return uint64(t.UnixNano()), nil
+}
+
+// parseEpoch parses a decimal count of units (ns per unit) since the epoch.
+func parseEpoch(s string, unit uint64) (uint64, error) {
+	whole, frac, _ := strings.Cut(s, ".")
+	w, err := strconv.ParseUint(whole, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if w > math.MaxUint64/unit {
+		return 0, fmt.Errorf("%s out of range", s)
+	}
+	ns := w * unit
+	for d := unit / 10; len(frac) > 0; d /= 10 {
+		c := frac[0]
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("bad time %q", s)
+		}
+		ns += uint64(c-'0') * d
+		frac = frac[1:]
+	}
+	return ns, nil
+}
+
+// price converts price text to DBN fixed-9. Whole numbers with a whole
+// PriceScale/PxScale convert exactly.
+func (p *csvRow) price(s string) (int64, error) {
+	if n, err := strconv.ParseInt(s, 10, 64); err == nil && p.pxMul != 0 {
+		if n != 0 && (n > math.MaxInt64/p.pxMul || n < math.MinInt64/p.pxMul) {
+			return 0, fmt.Errorf("%s out of range", s)
+		}
+		return n * p.pxMul, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f != f {
+		return NullPrice, nil
+	}
+	raw := math.Round(f * p.spec.PriceScale * 1e9)
+	if math.Abs(raw) >= math.MaxInt64 {
+		return 0, fmt.Errorf("%s out of range", s)
+	}
+	return int64(raw), nil
+}
+
+func (p *csvRow) side(s string) (int8, error) {
+	if v, ok := p.spec.Sides[s]; ok {
+		return v, nil
+	}
+	if v, err := strconv.ParseInt(s, 10, 8); err == nil && v >= -1 && v <= 1 {
+		return int8(v), nil
+	}
+	return 0, fmt.Errorf("unknown side %q (map it with sides = %s:B)", s, s)
+}
+
+func (p *csvRow) action(s string) (int8, error) {
+	if v, ok := p.spec.Actions[s]; ok {
+		return v, nil
+	}
+	if len(s) == 1 {
+		return int8(s[0]), nil
+	}
+	return 0, fmt.Errorf("unknown action %q (map it with actions = %s:T)", s, s)
+}
+
+// ImportCSV converts the CSV/TSV file path (optionally .zst) to the TBBO
+// .quantdev file out. The output is only renamed into place on success.
+func ImportCSV(path, out string, spec *ImportSpec, opts IngestOptions) (ImportStats, error) {
+	var st ImportStats
+	f, err := os.Open(path)
+	if err != nil {
+		return st, err
+	}
+	defer f.Close()
+
+	var src io.Reader = bufio.NewReaderSize(f, 1<<20)
+	base := path
+	if strings.HasSuffix(path, ".zst") {
+		src = NewZstdReader(src)
+		base = strings.TrimSuffix(path, ".zst")
+	}
+	cr := csv.NewReader(src)
+	cr.ReuseRecord = true
+	cr.FieldsPerRecord = -1
+	cr.Comma = spec.Delimiter
+	if cr.Comma == 0 {
+		cr.Comma = ','
+		if ext := strings.ToLower(filepath.Ext(base)); ext == ".tsv" || ext == ".tab" {
+			cr.Comma = '\t'
+		}
+	}
+	if cr.Comma == '\t' {
+		cr.LazyQuotes = true
+	}
+
+	header, err := cr.Read()
+	if err != nil {
+		if err == io.EOF {
+			err = errors.New("empty file")
+		}
+		return st, err
+	}
+	header = slices.Clone(header)
+	header[0] = strings.TrimPrefix(header[0], "\ufeff") // spreadsheet BOM
+	p, err := newCSVRow(spec, header)
+	if err != nil {
+		return st, err
+	}
+
+	var enc *Encoder
+	_, statErr := os.Stat(out)
+	appending := opts.Append && statErr == nil
+	switch {
+	case appending:
+		enc, err = OpenEncoderAppend(out)
+	case opts.ExactPrices:
+		enc, err = NewEncoderExact(out)
+	default:
+		enc, err = NewEncoder(out)
+	}
+	if err != nil {
+		return st, err
+	}
+	if !appending {
+		enc.SetCompact(opts.Compact)
+		enc.SetMeta(MetaSource, filepath.Base(path))
+	}
+	if spec.Dataset != "" {
+		enc.SetMeta(MetaDataset, spec.Dataset)
+	}
+	if spec.Asset != "" {
+		enc.SetMeta(MetaAsset, spec.Asset)
+	}
+
+	var r tbboRow
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err == nil {
+			var ok bool
+			if ok, err = p.parse(rec, &r); err == nil {
+				if !ok {
+					st.Skipped++
+					continue
+				}
+				err = enc.addRow(&r)
+				st.Rows++
+			}
+			if err != nil {
+				line, _ := cr.FieldPos(0)
+				err = fmt.Errorf("line %d: %w", line, err)
+			}
+		}
+		if err != nil {
+			enc.Abort()
+			return st, err
+		}
+	}
+	if err := enc.Close(); err != nil {
+		enc.Abort()
+		return st, err
+	}
+	return st, nil
+}
+
+// runImport implements
+// `import [-spec file] [-o out] [-exact] [-compact] [-append] files...`
+// and reports whether every file was imported.
+func runImport(args []string) bool {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	specPath := fs.String("spec", "", "column mapping `file` (default: DBN field names, ns timestamps, fixed-9 prices)")
+	out := fs.String("o", "", "output file (single input only; default: input name with .quantdev)")
+	var opts IngestOptions
+	fs.BoolVar(&opts.ExactPrices, "exact", false, "store prices as int64 fixed-point")
+	fs.BoolVar(&opts.Compact, "compact", false, "delta/RLE/dictionary-encode timestamps, flags and ids")
+	fs.BoolVar(&opts.Append, "append", false, "add the rows to an existing output instead of replacing it")
+	fs.Parse(args)
+
+	fmt.Println(">>> IMPORT: CSV/TSV -> QuantDev <<<")
+
+	spec := DefaultImportSpec()
+	if *specPath != "" {
+		var err error
+		if spec, err = ParseImportSpec(*specPath); err != nil {
+			fmt.Printf("[err] %v\n", err)
+			return false
+		}
+	}
+
+	files := fs.Args()
+	if len(files) == 0 {
+		for _, pat := range []string{"*.csv", "*.tsv", "*.csv.zst", "*.tsv.zst"} {
+			m, _ := filepath.Glob(pat)
+			files = append(files, m...)
+		}
+	}
+	if len(files) == 0 {
+		fmt.Println("No .csv/.tsv files found.")
+		return true
+	}
+	if *out != "" && len(files) > 1 {
+		fmt.Println("[err] -o needs exactly one input file")
+		return false
+	}
+
+	ok := true
+	for _, path := range files {
+		dst := *out
+		if dst == "" {
+			base := strings.TrimSuffix(path, ".zst")
+			dst = strings.TrimSuffix(base, filepath.Ext(base)) + ".quantdev"
+		}
+		fmt.Printf(" -> Importing %s...\n", filepath.Base(path))
+		st, err := ImportCSV(path, dst, spec, opts)
+		if err != nil {
+			fmt.Printf("   [err] %s: %v\n", filepath.Base(path), err)
+			ok = false
+			continue
+		}
+		fmt.Printf("    %d rows -> %s\n", st.Rows, dst)
+		if st.Skipped > 0 {
+			fmt.Printf("   [info] %d rows without a price skipped\n", st.Skipped)
+		}
+	}
+	return ok
+}
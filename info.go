@@ -0,0 +1,285 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// File metadata: a key-value section written by every encoder's Close, so a
+// .quantdev file says where it came from without its filename or the DBN
+// source at hand.
+//
+// Layout (position in header[58:64], a u48; 0 = none):
+//
+//	[u32 n] then n x [u16 key len][key][u32 value len][value]
+//
+// Keys are written sorted. Readers ignore keys they do not know; values are
+// UTF-8 text, timestamps decimal ns since the UNIX epoch.
+// -----------------------------------------------------------------------------
+
+// Keys written by gncFile.fileMeta.
+const (
+	MetaSource   = "source"   // input file name (SetMeta)
+	MetaDataset  = "dataset"  // DBN dataset, e.g. GLBX.MDP3
+	MetaSchema   = "schema"   // DBN schema name
+	MetaLayout   = "layout"   // .quantdev column layout
+	MetaRows     = "rows"     // total rows
+	MetaTsFirst  = "ts_first" // smallest ts_event
+	MetaTsLast   = "ts_last"  // largest ts_event
+	MetaSymbols  = "symbols"  // raw symbols of the file's instruments, comma-separated
+	MetaAsset    = "asset"    // product root, the AssetConfigs key
+	MetaEncoder  = "encoder"  // EncoderVersion
+	MetaBuild    = "build"    // module version, VCS revision and Go version
+	MetaCreated  = "created"  // RFC 3339 UTC
+	MetaAppended = "appended" // RFC 3339 UTC of the last OpenEncoderAppend
+)
+
+// maxMetaItems bounds the entry count parseFileMeta accepts.
+const maxMetaItems = 1 << 16
+
+// FileMeta is the metadata section of a file.
+type FileMeta map[string]string
+
+// TsRange returns the parsed ts_first and ts_last, 0 when absent.
+func (m FileMeta) TsRange() (first, last uint64) {
+	first, _ = strconv.ParseUint(m[MetaTsFirst], 10, 64)
+	last, _ = strconv.ParseUint(m[MetaTsLast], 10, 64)
+	return first, last
+}
+
+// SetMeta records a key-value pair for the metadata section, overriding the
+// derived value of the same key.
+func (g *gncFile) SetMeta(key, value string) {
+	if g.meta == nil {
+		g.meta = make(FileMeta)
+	}
+	g.meta[key] = value
+}
+
+// fileMeta is what finish writes: the derived keys plus those set with
+// SetMeta.
+func (g *gncFile) fileMeta() FileMeta {
+	m := FileMeta{
+		MetaLayout:  layoutNames[g.layout],
+		MetaRows:    strconv.FormatUint(g.totalRows, 10),
+		MetaEncoder: strconv.Itoa(EncoderVersion),
+		MetaBuild:   buildString(),
+		MetaCreated: time.Now().UTC().Format(time.RFC3339),
+	}
+	if g.source != nil {
+		m[MetaDataset] = g.source.Dataset
+		m[MetaSchema] = g.source.SchemaName()
+	}
+
+	var first, last uint64
+	var ids []uint32
+	for i := range g.zones {
+		z := &g.zones[i]
+		if z.rows == 0 {
+			continue
+		}
+		if first == 0 || z.tsMin < first {
+			first = z.tsMin
+		}
+		last = max(last, z.tsMax)
+		for _, id := range z.instruments {
+			if !slices.Contains(ids, id) {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if first != 0 {
+		m[MetaTsFirst] = strconv.FormatUint(first, 10)
+		m[MetaTsLast] = strconv.FormatUint(last, 10)
+	}
+
+	// Symbols come from the instrument catalog, the asset from the first
+	// instrument's definition or else the DBN symbols.
+	syms := make([]string, len(ids))
+	for i, id := range ids {
+		syms[i] = Instruments.Symbol(id, first)
+	}
+	if len(syms) > 0 {
+		m[MetaSymbols] = strings.Join(syms, ",")
+	}
+	if len(ids) > 0 {
+		if in, ok := Instruments.Resolve(ids[0], first); ok && in.Asset != "" {
+			m[MetaAsset] = in.Asset
+		}
+	}
+	if m[MetaAsset] == "" && g.source != nil && len(g.source.Symbols) > 0 {
+		m[MetaAsset] = symbolRoot(g.source.Symbols[0])
+	}
+
+	for k, v := range g.meta {
+		m[k] = v
+	}
+	return m
+}
+
+// buildString identifies the binary that wrote a file.
+func buildString() string {
+	bi, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	s := bi.Main.Path + " " + bi.Main.Version
+	var rev string
+	dirty := false
+	for _, kv := range bi.Settings {
+		switch kv.Key {
+		case "vcs.revision":
+			rev = kv.Value
+		case "vcs.modified":
+			dirty = kv.Value == "true"
+		}
+	}
+	if rev != "" {
+		s += " rev " + rev[:min(len(rev), 12)]
+		if dirty {
+			s += "+dirty"
+		}
+	}
+	return s + " " + bi.GoVersion
+}
+
+// appendFileMeta serializes a metadata section (layout at the top of this
+// file).
+func appendFileMeta(b []byte, m FileMeta) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	b = binary.LittleEndian.AppendUint32(b, uint32(len(keys)))
+	for _, k := range keys {
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(k)))
+		b = append(b, k...)
+		b = binary.LittleEndian.AppendUint32(b, uint32(len(m[k])))
+		b = append(b, m[k]...)
+	}
+	return b
+}
+
+// parseFileMeta is the inverse of appendFileMeta.
+func parseFileMeta(buf []byte) (FileMeta, error) {
+	c := &dbnCursor{buf: buf}
+	n := int(c.u32())
+	if n > maxMetaItems {
+		return nil, fmt.Errorf("metadata: %d entries", n)
+	}
+	m := make(FileMeta, n)
+	for i := 0; i < n && c.err == nil; i++ {
+		k := string(c.take(int(c.u16())))
+		m[k] = string(c.take(int(c.u32())))
+	}
+	if c.err != nil {
+		return nil, fmt.Errorf("metadata: %w", c.err)
+	}
+	return m, nil
+}
+
+// ReadQuantDevMeta returns the metadata section of a .quantdev file, or
+// (nil, nil) if the file was written without one.
+func ReadQuantDevMeta(path string) (FileMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	hdr, err := readGNCHeader(f)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.MetaPos == 0 {
+		return nil, nil
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	end := hdr.sectionEnd(hdr.MetaPos, uint64(st.Size()))
+	if hdr.MetaPos >= end {
+		return nil, fmt.Errorf("metadata at %d past end of file", hdr.MetaPos)
+	}
+	buf := make([]byte, end-hdr.MetaPos)
+	if _, err := f.ReadAt(buf, int64(hdr.MetaPos)); err != nil {
+		return nil, fmt.Errorf("reading metadata: %w", err)
+	}
+	return parseFileMeta(buf)
+}
+
+// runInfo implements `info [files...]` (default: every .quantdev in the
+// working directory).
+func runInfo(args []string) {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	fs.Parse(args)
+
+	files := fs.Args()
+	if len(files) == 0 {
+		files, _ = filepath.Glob("*.quantdev")
+	}
+	if len(files) == 0 {
+		fmt.Println("No .quantdev files found.")
+		return
+	}
+	if err := Instruments.Load(CatalogFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+
+	for _, path := range files {
+		printFileInfo(path)
+	}
+}
+
+func printFileInfo(path string) {
+	name := filepath.Base(path)
+	x, err := ReadQuantDevIndex(path)
+	if err != nil {
+		fmt.Printf("\n[err] %s: %v\n", name, err)
+		return
+	}
+	fmt.Printf("\n>>> %s: %s, format %d, %d rows, %d chunks <<<\n",
+		name, x.Header.LayoutName(), x.Header.Version, x.Header.Rows, len(x.Chunks))
+
+	m, err := ReadQuantDevMeta(path)
+	if err != nil {
+		fmt.Printf("   [err] %v\n", err)
+		return
+	}
+	if m == nil {
+		fmt.Println("   no metadata section (written before metadata); from the footer:")
+		first, last := x.TimeRange()
+		fmt.Printf("   %-10s %s\n   %-10s %s\n", MetaTsFirst, fmtNanos(first), MetaTsLast, fmtNanos(last))
+		if src, err := ReadQuantDevSource(path); err == nil && src != nil {
+			fmt.Printf("   %-10s %s\n", "dbn", src.Summary())
+		}
+		return
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		v := m[k]
+		if k == MetaTsFirst || k == MetaTsLast {
+			if ns, err := strconv.ParseUint(v, 10, 64); err == nil {
+				v = fmt.Sprintf("%s (%s)", fmtNanos(ns), v)
+			}
+		}
+		fmt.Printf("   %-10s %s\n", k, v)
+	}
+}
@@ -18,26 +18,62 @@ func main() {
 
 	cmd := os.Args[1]
 	start := time.Now()
+	ok := true
 
 	switch cmd {
 	case "data":
-		// Ingests raw .dbn files into the high-performance .quantdev format
-		runData()
+		// Ingests raw .dbn / .dbn.zst files into the high-performance .quantdev format
+		runData(parseIngestFlags(os.Args[2:]))
 	case "test":
 		// Runs the Microstructure Backtest + Metrics
 		runTest()
 	case "check":
 		// Forensic analysis of data quality
-		runCheck()
+		runCheck(os.Args[2:])
+	case "verify":
+		// Checksum scan for bit rot and truncated writes
+		ok = runVerify(os.Args[2:])
+	case "merge":
+		// k-way merge of overlapping downloads into one sorted file
+		ok = runMerge(os.Args[2:])
+	case "import":
+		// CSV/TSV from other vendors into TBBO .quantdev
+		ok = runImport(os.Args[2:])
+	case "export":
+		// Arrow IPC / Parquet for notebooks
+		ok = runExport(os.Args[2:])
+	case "info":
+		// File metadata: source, dataset, date range, encoder build
+		runInfo(os.Args[2:])
 	default:
 		printHelp()
 	}
 	fmt.Printf("\n[sys] Time: %s\n", time.Since(start))
+	if !ok {
+		os.Exit(1)
+	}
 }
 
 func printHelp() {
-	fmt.Println("Usage: go run . [data|test|check]")
-	fmt.Println("  data  -> Convert raw Databento (.dbn) to optimized format")
+	fmt.Println("Usage: go run . [data|test|check|verify|info|merge|import|export]")
+	fmt.Println("  data  -> Convert raw Databento (.dbn, .dbn.zst) to optimized format")
+	fmt.Println("          [-split instrument|day|instrument,day] one file per contract/session")
+	fmt.Println("          [-exact] keep TBBO prices as exact fixed-point integers")
+	fmt.Println("          [-compact] delta/RLE/dictionary-encode timestamps, flags and ids")
+	fmt.Println("          [-force] reconvert files the ingest manifest says are unchanged")
+	fmt.Println("          [-max-bad 0.0001] [-on-corrupt quarantine|fail] malformed-record tolerance")
 	fmt.Println("  test  -> Run strategy + metrics")
 	fmt.Println("  check -> Analyze data files for gaps and packet loss")
+	fmt.Println("          [-chunks] per-chunk time/price/instrument ranges from the footer")
+	fmt.Println("          [-seq] sequence gaps, out-of-order and duplicate messages per instrument")
+	fmt.Println("  verify -> Check chunk checksums and report corrupt chunks by offset")
+	fmt.Println("          [files...] default: every .quantdev in the directory")
+	fmt.Println("  merge -> Merge .quantdev files sorted by (ts_event, sequence), dropping duplicates")
+	fmt.Println("          -o out.quantdev [-exact] [-compact] inputs...")
+	fmt.Println("  import -> Convert CSV/TSV TBBO-like rows to .quantdev")
+	fmt.Println("          [-spec mapping.txt] [-o out] [-exact] [-compact] [-append] [files...]")
+	fmt.Println("  export -> Write .quantdev as Arrow IPC or Parquet")
+	fmt.Println("          [-format arrow|arrows|parquet] [-from t] [-to t] [-columns a,b] [-o out] [files...]")
+	fmt.Println("  info   -> Print file metadata (source, dataset, symbols, time range, encoder build)")
+	fmt.Println("          [files...] default: every .quantdev in the directory")
 }
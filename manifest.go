@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// -----------------------------------------------------------------------------
+// Ingest manifest: what `data` produced from which input, so unchanged inputs
+// are skipped on the next run.
+//
+// One TSV row per source file:
+//
+//	source  size  mtime_ns  xxh64  encoder  options  rows  outputs (comma-separated)
+//
+// An entry is only written once all of a file's outputs are complete, and is
+// dropped before the file is reconverted, so a run that dies half-way leaves
+// the input without an entry and the next run redoes it. Outputs are written
+// as "<path>.tmp" and renamed once the file passed the framing check, so a
+// rejected run leaves the previous outputs alone; leftovers are removed at
+// startup.
+// -----------------------------------------------------------------------------
+
+const (
+	ManifestFile = "ingest.manifest"
+	TmpSuffix    = ".tmp"
+
+	// EncoderVersion is bumped whenever the bytes `data` writes for the same
+	// input change, invalidating every manifest entry.
+	EncoderVersion = 7
+)
+
+type ManifestEntry struct {
+	Source  string
+	Size    int64
+	ModTime int64 // ns since epoch
+	Hash    uint64
+	Encoder int
+	Options string // IngestOptions.signature()
+	Rows    int
+	Outputs []string
+}
+
+// IngestManifest is safe for concurrent use by the conversion workers.
+type IngestManifest struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]*ManifestEntry
+	dirty   bool // an mtime refreshed by upToDate is not saved yet
+}
+
+// Manifest is the process-wide ingest manifest, loaded by runData.
+var Manifest = &IngestManifest{entries: make(map[string]*ManifestEntry)}
+
+// signature captures the options that change what is written.
+func (o IngestOptions) signature() string {
+	var parts []string
+	if o.SplitInstrument {
+		parts = append(parts, "instrument")
+	}
+	if o.SplitDay {
+		parts = append(parts, "day")
+	}
+	if o.ExactPrices {
+		parts = append(parts, "exact")
+	}
+	if o.Compact {
+		parts = append(parts, "compact")
+	}
+	if len(parts) == 0 {
+		return "-"
+	}
+	return strings.Join(parts, ",")
+}
+
+// upToDate reports whether e still describes source, whose current stat is
+// st: same encoder and options, all outputs present, and either the same
+// size and mtime or (after a touch or copy) the same content hash. A hash
+// match refreshes the recorded mtime.
+func (m *IngestManifest) upToDate(source string, st os.FileInfo, opts IngestOptions) bool {
+	m.mu.Lock()
+	e := m.entries[source]
+	m.mu.Unlock()
+	if e == nil || e.Encoder != EncoderVersion || e.Options != opts.signature() {
+		return false
+	}
+	for _, out := range e.Outputs {
+		if _, err := os.Stat(out); err != nil {
+			return false
+		}
+	}
+	if e.Size == st.Size() && e.ModTime == st.ModTime().UnixNano() {
+		return true
+	}
+	if e.Size != st.Size() {
+		return false
+	}
+	h, err := hashFile(source)
+	if err != nil || h != e.Hash {
+		return false
+	}
+	m.mu.Lock()
+	e.ModTime = st.ModTime().UnixNano()
+	m.dirty = true
+	m.mu.Unlock()
+	return true
+}
+
+// Dirty reports whether upToDate changed entries since the last Save.
+func (m *IngestManifest) Dirty() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.dirty
+}
+
+// Record stores the result of a successful conversion of source.
+func (m *IngestManifest) Record(source string, st os.FileInfo, res ingestResult, opts IngestOptions) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[source] = &ManifestEntry{
+		Source:  source,
+		Size:    st.Size(),
+		ModTime: st.ModTime().UnixNano(),
+		Hash:    res.hash,
+		Encoder: EncoderVersion,
+		Options: opts.signature(),
+		Rows:    res.rows,
+		Outputs: slices.Clone(res.outputs),
+	}
+}
+
+// Forget drops the entry for source.
+func (m *IngestManifest) Forget(source string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.entries, source)
+}
+
+func (m *IngestManifest) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.entries)
+}
+
+const manifestHeader = "source\tsize\tmtime_ns\txxh64\tencoder\toptions\trows\toutputs"
+
+// Load reads the manifest at path and remembers path for Save. A missing file
+// is an empty manifest.
+func (m *IngestManifest) Load(path string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.path = path
+	m.entries = make(map[string]*ManifestEntry)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	sc := bufio.NewScanner(f)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := sc.Text()
+		if text == "" || strings.HasPrefix(text, "source\t") {
+			continue
+		}
+		fields := strings.Split(text, "\t")
+		if len(fields) != 8 {
+			return fmt.Errorf("%s:%d: want 8 fields, got %d", path, line, len(fields))
+		}
+		e := &ManifestEntry{Source: fields[0], Options: fields[5]}
+		e.Size, _ = strconv.ParseInt(fields[1], 10, 64)
+		e.ModTime, _ = strconv.ParseInt(fields[2], 10, 64)
+		e.Hash, _ = strconv.ParseUint(fields[3], 16, 64)
+		e.Encoder, _ = strconv.Atoi(fields[4])
+		e.Rows, _ = strconv.Atoi(fields[6])
+		if fields[7] != "" {
+			e.Outputs = strings.Split(fields[7], ",")
+		}
+		m.entries[e.Source] = e
+	}
+	return sc.Err()
+}
+
+// Save writes the manifest to a temp file and renames it over the loaded
+// path. Workers call it after every file so a crash loses at most the
+// conversions in flight.
+func (m *IngestManifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.path == "" {
+		return nil
+	}
+
+	sources := make([]string, 0, len(m.entries))
+	for s := range m.entries {
+		sources = append(sources, s)
+	}
+	slices.Sort(sources)
+
+	var sb strings.Builder
+	sb.WriteString(manifestHeader)
+	sb.WriteByte('\n')
+	for _, s := range sources {
+		e := m.entries[s]
+		fmt.Fprintf(&sb, "%s\t%d\t%d\t%016x\t%d\t%s\t%d\t%s\n",
+			e.Source, e.Size, e.ModTime, e.Hash, e.Encoder, e.Options, e.Rows,
+			strings.Join(e.Outputs, ","))
+	}
+
+	tmp := m.path + TmpSuffix
+	if err := os.WriteFile(tmp, []byte(sb.String()), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return err
+	}
+	m.dirty = false
+	return nil
+}
+
+// hashFile returns the xxh64 of the file's bytes.
+func hashFile(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	var h xxh64
+	h.reset()
+	if _, err := io.Copy(&h, f); err != nil {
+		return 0, err
+	}
+	return h.Sum64(), nil
+}
+
+// removeStaleTemps deletes outputs left behind by an interrupted run and
+// returns how many there were.
+func removeStaleTemps() int {
+	var stale []string
+	for _, pat := range []string{"*.quantdev" + TmpSuffix, "*" + EventLogSuffix + TmpSuffix} {
+		m, _ := filepath.Glob(pat)
+		stale = append(stale, m...)
+	}
+	for _, p := range stale {
+		fmt.Printf("[warn] %s: left by an interrupted run, removing\n", p)
+		os.Remove(p)
+	}
+	return len(stale)
+}
@@ -82,6 +82,11 @@ type MarketPhysics struct {
 
 	// Liquidation state machine
 	LiqState LiquidationState
+
+	// Trading halts from the event log (sorted by Start); windows are rebuilt
+	// after each one just like after a sequence gap.
+	Halts   []HaltWindow
+	haltIdx int
 }
 
 func NewMarketPhysics() *MarketPhysics {
@@ -96,6 +101,27 @@ func NewMarketPhysics() *MarketPhysics {
 	}
 }
 
+func (mp *MarketPhysics) resetWindows() {
+	mp.OFIWindow.Reset()
+	mp.AvgBidSzWindow.Reset()
+	mp.AvgAskSzWindow.Reset()
+	mp.UrgencyWindow.Reset()
+	mp.SweepWindow.Reset()
+	mp.LiqState = LiquidationState{}
+	mp.validHist = false
+}
+
+// crossedHalt advances past every halt that started at or before ts and
+// reports whether there was any.
+func (mp *MarketPhysics) crossedHalt(ts uint64) bool {
+	crossed := false
+	for mp.haltIdx < len(mp.Halts) && mp.Halts[mp.haltIdx].Start <= ts {
+		mp.haltIdx++
+		crossed = true
+	}
+	return crossed
+}
+
 // ============================================================================
 //  RollingWindow – exact sliding-window average via ring buffer
 // ============================================================================
@@ -163,16 +189,16 @@ func (mp *MarketPhysics) UpdateAtoms(a *Atoms, i int, raw *TBBOColumns) {
 	currentSeq := raw.Sequences[i]
 	if mp.validHist && currentSeq != mp.LastSeq+1 {
 		// GAP DETECTED: invalidate state to avoid phantom OFI / sweep spikes.
-		mp.OFIWindow.Reset()
-		mp.AvgBidSzWindow.Reset()
-		mp.AvgAskSzWindow.Reset()
-		mp.UrgencyWindow.Reset()
-		mp.SweepWindow.Reset()
-		mp.LiqState = LiquidationState{}
-		mp.validHist = false
+		mp.resetWindows()
 	}
 	mp.LastSeq = currentSeq
 
+	// Same for a halt that began since the previous tick: pre-halt flow says
+	// nothing about the reopening book.
+	if mp.crossedHalt(raw.TsEvent[i]) {
+		mp.resetWindows()
+	}
+
 	// Current TBBO state
 	q_n := raw.Sizes[i]
 	p_n := raw.Prices[i]
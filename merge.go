@@ -0,0 +1,460 @@
+package main
+
+import (
+	"cmp"
+	"container/heap"
+	"flag"
+	"fmt"
+	"iter"
+	"math"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// -----------------------------------------------------------------------------
+// merge: k-way merge of TBBO .quantdev files into one file sorted by
+// (TsEvent, Sequences).
+//
+// Sorted inputs are streamed chunk by chunk, so memory stays at one chunk per
+// input. DBN files are ordered by ts_recv, so a converted file can have
+// ts_event step back; such an input is loaded whole and sorted first.
+// Overlapping downloads hold the same venue messages, so rows that share the
+// key are compared across inputs: a row equal in every column to a row already
+// taken from another input is a duplicate and dropped. Each input's copy of a
+// row pairs with at most one kept row, so the repeated fills a single file
+// legitimately holds survive. Rows that share (ts_event, sequence, publisher,
+// instrument) with another input's row but differ elsewhere are kept and
+// counted as conflicts.
+// -----------------------------------------------------------------------------
+
+// MergeOptions configures MergeQuantDev.
+type MergeOptions struct {
+	Exact   bool // fixed-point prices (NewEncoderExact); implied when every input is exact
+	Compact bool // see SetCompact
+}
+
+// MergeStats is what MergeQuantDev did.
+type MergeStats struct {
+	RowsIn     int
+	RowsOut    int
+	Duplicates int // rows dropped as copies of another input's rows
+	Conflicts  int // same message id as another input's row, different content; kept
+	Overlaps   int // input pairs whose time ranges overlap
+}
+
+// tbboRow is one TBBO row with prices in DBN fixed-9, as Encoder.AddRow
+// takes it.
+type tbboRow struct {
+	pubID     uint16
+	instrID   uint32
+	tsEvent   uint64
+	tsRecv    uint64
+	tsInDelta int32
+	pxRaw     int64
+	size      uint32
+	side      int8
+	action    int8
+	flags     uint8
+	depth     uint8
+	seq       uint32
+	bidPxRaw  int64
+	askPxRaw  int64
+	bidSz     uint32
+	askSz     uint32
+	bidCt     uint32
+	askCt     uint32
+}
+
+// row returns row i of c (all columns loaded).
+func (c *TBBOColumns) row(i int) tbboRow {
+	px := func(f []float64, raw []int64) int64 {
+		if c.PriceScale == PxScale {
+			return raw[i]
+		}
+		if f[i] != f[i] || f[i] >= float64(NullPrice)*PxScale {
+			return NullPrice
+		}
+		return int64(math.Round(f[i] / PxScale))
+	}
+	return tbboRow{
+		pubID:     c.PublisherID[i],
+		instrID:   c.InstrumentID[i],
+		tsEvent:   c.TsEvent[i],
+		tsRecv:    c.TsRecv[i],
+		tsInDelta: c.TsInDelta[i],
+		pxRaw:     px(c.Prices, c.PricesRaw),
+		size:      uint32(c.Sizes[i]),
+		side:      c.Sides[i],
+		action:    c.Actions[i],
+		flags:     c.Flags[i],
+		depth:     c.Depth[i],
+		seq:       c.Sequences[i],
+		bidPxRaw:  px(c.BidPx, c.BidPxRaw),
+		askPxRaw:  px(c.AskPx, c.AskPxRaw),
+		bidSz:     uint32(c.BidSz[i]),
+		askSz:     uint32(c.AskSz[i]),
+		bidCt:     c.BidCt[i],
+		askCt:     c.AskCt[i],
+	}
+}
+
+// addRow is AddRow for a tbboRow.
+func (e *Encoder) addRow(r *tbboRow) error {
+	return e.AddRow(r.pubID, r.instrID, r.tsEvent, r.tsRecv, r.tsInDelta,
+		r.pxRaw, r.size, r.side, r.action, r.flags, r.depth, r.seq,
+		r.bidPxRaw, r.askPxRaw, r.bidSz, r.askSz, r.bidCt, r.askCt)
+}
+
+// sameMessage reports whether a and b carry the same venue message id.
+func (a *tbboRow) sameMessage(b *tbboRow) bool {
+	return a.tsEvent == b.tsEvent && a.seq == b.seq && a.pubID == b.pubID && a.instrID == b.instrID
+}
+
+// mergeInput is one input's position in the merge: a chunk stream, or a
+// whole file sorted in memory (r nil, loaded set).
+type mergeInput struct {
+	path   string
+	index  int
+	r      *ChunkReader
+	loaded *TBBOColumns
+	next   func() (int, *TBBOColumns, bool)
+	stop   func()
+	cols   *TBBOColumns
+	i      int // current row of cols
+}
+
+// openMergeInput streams path if it is sorted and loads and sorts it
+// otherwise.
+func openMergeInput(path string, index int) (*mergeInput, error) {
+	sorted, err := isSortedByKey(path)
+	if err != nil {
+		return nil, err
+	}
+	in := &mergeInput{path: path, index: index}
+	if sorted {
+		if in.r, err = OpenChunkReader(path, TBBOQuery{}); err != nil {
+			return nil, err
+		}
+		in.next, in.stop = iter.Pull2(in.r.All())
+		return in, nil
+	}
+
+	fmt.Printf("[info] %s is not sorted by (ts_event, sequence); sorting it in memory\n", filepath.Base(path))
+	if in.loaded, err = LoadQuantDev(path); err != nil {
+		return nil, err
+	}
+	in.loaded.sortRows()
+	in.next, in.stop = iter.Pull2(func(yield func(int, *TBBOColumns) bool) {
+		yield(0, in.loaded)
+	})
+	return in, nil
+}
+
+func (in *mergeInput) close() {
+	in.stop()
+	if in.r != nil {
+		in.r.Close()
+	}
+	if in.loaded != nil {
+		TBBOPool.Put(in.loaded)
+	}
+}
+
+// isSortedByKey scans the ts_event and sequence columns of a TBBO file.
+func isSortedByKey(path string) (bool, error) {
+	r, err := OpenChunkReader(path, TBBOQuery{Columns: ColTsEvent | ColSequences})
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	var ts uint64
+	var seq uint32
+	for _, c := range r.All() {
+		for i := 0; i < c.Count; i++ {
+			t, s := c.TsEvent[i], c.Sequences[i]
+			if t < ts || t == ts && s < seq {
+				return false, nil
+			}
+			ts, seq = t, s
+		}
+	}
+	return true, r.Err()
+}
+
+// sortRows orders the rows of c (all columns loaded) by (TsEvent,
+// Sequences), keeping the file order of equal keys.
+func (c *TBBOColumns) sortRows() {
+	idx := make([]int, c.Count)
+	for i := range idx {
+		idx[i] = i
+	}
+	slices.SortStableFunc(idx, func(a, b int) int {
+		if r := cmp.Compare(c.TsEvent[a], c.TsEvent[b]); r != 0 {
+			return r
+		}
+		return cmp.Compare(c.Sequences[a], c.Sequences[b])
+	})
+
+	c.PublisherID = gather(c.PublisherID, idx)
+	c.InstrumentID = gather(c.InstrumentID, idx)
+
+	c.TsEvent = gather(c.TsEvent, idx)
+	c.TsRecv = gather(c.TsRecv, idx)
+	c.TsInDelta = gather(c.TsInDelta, idx)
+
+	c.Prices = gather(c.Prices, idx)
+	c.Sizes = gather(c.Sizes, idx)
+	c.Sides = gather(c.Sides, idx)
+	c.Actions = gather(c.Actions, idx)
+	c.Flags = gather(c.Flags, idx)
+	c.Depth = gather(c.Depth, idx)
+	c.Sequences = gather(c.Sequences, idx)
+
+	c.BidPx = gather(c.BidPx, idx)
+	c.AskPx = gather(c.AskPx, idx)
+	c.BidSz = gather(c.BidSz, idx)
+	c.AskSz = gather(c.AskSz, idx)
+	c.BidCt = gather(c.BidCt, idx)
+	c.AskCt = gather(c.AskCt, idx)
+
+	c.PricesRaw = gather(c.PricesRaw, idx)
+	c.BidPxRaw = gather(c.BidPxRaw, idx)
+	c.AskPxRaw = gather(c.AskPxRaw, idx)
+}
+
+// gather returns s reordered so that element i is s[idx[i]]. Columns that
+// were not loaded stay empty.
+func gather[T any](s []T, idx []int) []T {
+	if len(s) == 0 {
+		return s
+	}
+	out := make([]T, len(idx))
+	for i, j := range idx {
+		out[i] = s[j]
+	}
+	return out
+}
+
+// key is the merge order of the current row.
+func (in *mergeInput) key() (uint64, uint32) {
+	return in.cols.TsEvent[in.i], in.cols.Sequences[in.i]
+}
+
+// advance moves to the next row and reports whether there is one.
+func (in *mergeInput) advance() (bool, error) {
+	var ts uint64
+	var seq uint32
+	if in.cols != nil {
+		ts, seq = in.key()
+		in.i++
+	}
+	for in.cols == nil || in.i >= in.cols.Count {
+		_, cols, ok := in.next()
+		if !ok {
+			if in.r != nil {
+				return false, in.r.Err()
+			}
+			return false, nil
+		}
+		in.cols, in.i = cols, 0
+	}
+	if t, s := in.key(); t < ts || t == ts && s < seq {
+		return false, fmt.Errorf("%s is not sorted by (ts_event, sequence) at %s", in.path, fmtNanos(t))
+	}
+	return true, nil
+}
+
+// mergeHeap orders inputs by their current row, ties by input order.
+type mergeHeap []*mergeInput
+
+func (h mergeHeap) Len() int { return len(h) }
+func (h mergeHeap) Less(i, j int) bool {
+	ti, si := h[i].key()
+	tj, sj := h[j].key()
+	if ti != tj {
+		return ti < tj
+	}
+	if si != sj {
+		return si < sj
+	}
+	return h[i].index < h[j].index
+}
+func (h mergeHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeap) Push(x any)   { *h = append(*h, x.(*mergeInput)) }
+func (h *mergeHeap) Pop() any {
+	old := *h
+	in := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return in
+}
+
+// keptRow is a written row of the current key and the inputs it stands for.
+type keptRow struct {
+	row    tbboRow
+	inputs []int
+}
+
+// MergeQuantDev merges the TBBO files inputs into out.
+func MergeQuantDev(out string, inputs []string, opts MergeOptions) (*MergeStats, error) {
+	st := &MergeStats{}
+	ins := make([]*mergeInput, 0, len(inputs))
+	defer func() {
+		for _, in := range ins {
+			in.close()
+		}
+	}()
+
+	exact := true
+	ranges := make([][2]uint64, 0, len(inputs))
+	for k, path := range inputs {
+		x, err := ReadQuantDevIndex(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		in, err := openMergeInput(path, k)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ins = append(ins, in)
+
+		exact = exact && x.Header.PriceEnc == PriceFixedInt64
+		if x.Header.Rows == 0 {
+			continue
+		}
+		first, last := x.TimeRange()
+		for _, rg := range ranges {
+			if first <= rg[1] && rg[0] <= last {
+				st.Overlaps++
+			}
+		}
+		ranges = append(ranges, [2]uint64{first, last})
+	}
+
+	newEnc := NewEncoder
+	if opts.Exact || exact {
+		newEnc = NewEncoderExact
+	}
+	enc, err := newEnc(out)
+	if err != nil {
+		return nil, err
+	}
+	enc.SetCompact(opts.Compact)
+	if len(inputs) > 0 {
+		if src, err := ReadQuantDevSource(inputs[0]); err == nil {
+			enc.SetSource(src)
+		}
+	}
+	names := make([]string, len(inputs))
+	for i, p := range inputs {
+		names[i] = filepath.Base(p)
+	}
+	enc.SetMeta(MetaSource, strings.Join(names, ","))
+
+	if err := mergeRows(enc, ins, st); err != nil {
+		enc.Abort()
+		return nil, err
+	}
+	return st, enc.Close()
+}
+
+// mergeRows writes the rows of ins to enc in key order without duplicates.
+func mergeRows(enc *Encoder, ins []*mergeInput, st *MergeStats) error {
+	h := make(mergeHeap, 0, len(ins))
+	for _, in := range ins {
+		ok, err := in.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			h = append(h, in)
+		}
+	}
+	heap.Init(&h)
+
+	// Rows written for the current (ts_event, sequence).
+	var group []keptRow
+	for h.Len() > 0 {
+		in := h[0]
+		r := in.cols.row(in.i)
+		st.RowsIn++
+
+		if len(group) > 0 && (group[0].row.tsEvent != r.tsEvent || group[0].row.seq != r.seq) {
+			group = group[:0]
+		}
+		dup, conflict := false, false
+		for g := range group {
+			kr := &group[g]
+			if slices.Contains(kr.inputs, in.index) {
+				continue
+			}
+			if kr.row == r {
+				kr.inputs = append(kr.inputs, in.index)
+				dup = true
+				break
+			}
+			if kr.row.sameMessage(&r) {
+				conflict = true
+			}
+		}
+		switch {
+		case dup:
+			st.Duplicates++
+		default:
+			if conflict {
+				st.Conflicts++
+			}
+			if err := enc.addRow(&r); err != nil {
+				return err
+			}
+			group = append(group, keptRow{row: r, inputs: []int{in.index}})
+			st.RowsOut++
+		}
+
+		ok, err := in.advance()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(&h, 0)
+		} else {
+			heap.Pop(&h)
+		}
+	}
+	return nil
+}
+
+// runMerge implements `merge -o out.quantdev [-exact] [-compact] inputs...`
+// and reports whether the output was written.
+func runMerge(args []string) bool {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	out := fs.String("o", "", "output `file` (.quantdev)")
+	var opts MergeOptions
+	fs.BoolVar(&opts.Exact, "exact", false, "store prices as int64 fixed-point even if an input is float64")
+	fs.BoolVar(&opts.Compact, "compact", false, "delta/RLE/dictionary-encode timestamps, flags and ids")
+	fs.Parse(args)
+
+	fmt.Println(">>> MERGE: sorted, deduplicated QuantDev <<<")
+
+	inputs := fs.Args()
+	if *out == "" || len(inputs) == 0 {
+		fmt.Println("Usage: merge -o out.quantdev [-exact] [-compact] in1.quantdev in2.quantdev ...")
+		return false
+	}
+	if err := Instruments.Load(CatalogFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+
+	st, err := MergeQuantDev(*out, inputs, opts)
+	if err != nil {
+		fmt.Printf("[err] %v\n", err)
+		return false
+	}
+	fmt.Printf("[sys] %d inputs, %d rows in, %d rows out -> %s\n", len(inputs), st.RowsIn, st.RowsOut, *out)
+	fmt.Printf("[sys] %d duplicates dropped, %d overlapping input pairs\n", st.Duplicates, st.Overlaps)
+	if st.Conflicts > 0 {
+		fmt.Printf("[warn] %d rows share a message id (ts_event, sequence, publisher, instrument) with another input's row but differ; kept\n", st.Conflicts)
+	}
+	return true
+}
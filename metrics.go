@@ -1,6 +1,7 @@
 package main
 
 import (
+	"iter"
 	"math"
 	"sort"
 	"sync"
@@ -636,33 +637,65 @@ func (p *Portfolio) MergeLocal(local *SymbolReport) {
 //  CORE STRATEGY LOOP: TBBO → Signals → Metrics (no execution sim)
 // ============================================================================
 
-func RunStrategy(raw *TBBOColumns, config AssetConfig, report *SymbolReport) {
-	n := raw.Count
-	if n < 2000 {
+// minStrategyRows is the fewest rows worth evaluating.
+const minStrategyRows = 2000
+
+// halts (see HaltWindows) reset the physics windows when trading stops; nil
+// is fine for files without an event log.
+func RunStrategy(raw *TBBOColumns, config AssetConfig, halts []HaltWindow, report *SymbolReport) {
+	if raw.Count < minStrategyRows {
 		return
 	}
+	newStrategyRun(halts, report).advance(raw, true)
+}
 
-	// --- BCE HOISTING: verify column lengths once ---
-	if len(raw.Prices) < n || len(raw.BidPx) < n || len(raw.AskPx) < n ||
-		len(raw.BidSz) < n || len(raw.AskSz) < n || len(raw.TsEvent) < n {
-		panic("corrupt TBBO column length")
+// RunStrategyStream is RunStrategy over chunks as a ChunkReader yields them.
+// It holds only the rows from the one being processed to the end of its
+// longest horizon, plus the chunk just read, instead of the whole file.
+func RunStrategyStream(chunks iter.Seq2[int, *TBBOColumns], config AssetConfig, halts []HaltWindow, report *SymbolReport) {
+	var look TBBOColumns
+	var run *strategyRun
+	for _, c := range chunks {
+		look.appendRows(c)
+		if run == nil {
+			if look.Count < minStrategyRows {
+				continue
+			}
+			run = newStrategyRun(halts, report)
+		}
+		run.advance(&look, false)
+
+		// Drop what has been processed.
+		look.keepRows(run.next, look.Count)
+		run.shift(run.next)
+	}
+	if run != nil {
+		run.advance(&look, true)
 	}
+}
 
-	// Hoist slice headers to locals (helps BCE and register allocation)
-	tsEvents := raw.TsEvent[:n]
-	prices := raw.Prices[:n]
-	bidPxs := raw.BidPx[:n]
-	askPxs := raw.AskPx[:n]
-	bidSzs := raw.BidSz[:n]
-	askSzs := raw.AskSz[:n]
+// strategyRun is the state of one strategy pass over a window of rows that
+// may grow at the end (streaming) and lose processed rows at the front.
+type strategyRun struct {
+	mp      *MarketPhysics
+	signals *SignalEngine
 
-	mp := NewMarketPhysics()
-	signals := &SignalEngine{}
+	sigStats [NumSignals][HzCount]*ICStats
+	trdStats [NumSignals][HzCount]*AdvancedStats
 
-	// --- INIT REPORTING POINTERS ---
-	var sigStats [NumSignals][HzCount]*ICStats
-	var trdStats [NumSignals][HzCount]*AdvancedStats
+	cursors [HzCount]int
+	atoms   Atoms
+	alphas  [NumSignals]float64
+
+	started bool
+	next    int // next row of the window to process
+}
+
+func newStrategyRun(halts []HaltWindow, report *SymbolReport) *strategyRun {
+	s := &strategyRun{mp: NewMarketPhysics(), signals: &SignalEngine{}}
+	s.mp.Halts = halts
 
+	// --- INIT REPORTING POINTERS ---
 	report.Lock.Lock()
 	for i, id := range ActiveSignals {
 		if _, ok := report.Signals[id]; !ok {
@@ -670,25 +703,62 @@ func RunStrategy(raw *TBBOColumns, config AssetConfig, report *SymbolReport) {
 			report.Trades[id] = &[HzCount]AdvancedStats{}
 		}
 		for h := 0; h < int(HzCount); h++ {
-			sigStats[i][h] = &report.Signals[id][h]
-			trdStats[i][h] = &report.Trades[id][h]
+			s.sigStats[i][h] = &report.Signals[id][h]
+			s.trdStats[i][h] = &report.Trades[id][h]
 		}
 	}
 	report.Lock.Unlock()
+	return s
+}
 
-	cursors := [HzCount]int{}
+// shift accounts for the first k rows having been dropped from the window.
+func (s *strategyRun) shift(k int) {
+	s.next -= k
+	for h := range s.cursors {
+		s.cursors[h] = max(s.cursors[h]-k, 0)
+	}
+}
 
-	// Initialize physics state with first tick
-	mp.PrevTime = tsEvents[0]
-	mp.PrevPrice = prices[0]
-	mp.PrevMid = (bidPxs[0] + askPxs[0]) * 0.5
-	mp.PrevBidSz = bidSzs[0]
-	mp.PrevAskSz = askSzs[0]
+// advance processes the rows of raw from s.next on. Unless eof, it stops at
+// the first row whose horizons reach past the end of raw and leaves it for
+// when more rows have arrived; at eof, horizons past the end use the last row.
+func (s *strategyRun) advance(raw *TBBOColumns, eof bool) {
+	n := raw.Count
+	if n == 0 {
+		return
+	}
 
-	var atoms Atoms
-	var alphas [NumSignals]float64
+	// --- BCE HOISTING: verify column lengths once ---
+	if len(raw.Prices) < n || len(raw.BidPx) < n || len(raw.AskPx) < n ||
+		len(raw.BidSz) < n || len(raw.AskSz) < n || len(raw.TsEvent) < n {
+		panic("corrupt TBBO column length")
+	}
 
-	for i := 1; i < n; i++ {
+	// Hoist slice headers to locals (helps BCE and register allocation)
+	tsEvents := raw.TsEvent[:n]
+	prices := raw.Prices[:n]
+	bidPxs := raw.BidPx[:n]
+	askPxs := raw.AskPx[:n]
+	bidSzs := raw.BidSz[:n]
+	askSzs := raw.AskSz[:n]
+
+	mp := s.mp
+	cursors := &s.cursors
+	atoms := &s.atoms
+	alphas := &s.alphas
+
+	if !s.started {
+		// Initialize physics state with first tick
+		mp.PrevTime = tsEvents[0]
+		mp.PrevPrice = prices[0]
+		mp.PrevMid = (bidPxs[0] + askPxs[0]) * 0.5
+		mp.PrevBidSz = bidSzs[0]
+		mp.PrevAskSz = askSzs[0]
+		s.started = true
+		s.next = 1
+	}
+
+	for i := s.next; i < n; i++ {
 		tNow := tsEvents[i]
 
 		// Pre-compute cursors for horizons (amortized O(1))
@@ -702,14 +772,20 @@ func RunStrategy(raw *TBBOColumns, config AssetConfig, report *SymbolReport) {
 				c++
 			}
 			if c >= n {
+				if !eof {
+					// Horizon not loaded yet: resume here with more rows.
+					cursors[h] = c
+					s.next = i
+					return
+				}
 				c = n - 1
 			}
 			cursors[h] = c
 		}
 
 		// Update microstructure atoms and signals
-		mp.UpdateAtoms(&atoms, i, raw)
-		signals.Compute(&atoms, mp, raw, i, &alphas)
+		mp.UpdateAtoms(atoms, i, raw)
+		s.signals.Compute(atoms, mp, raw, i, alphas)
 
 		// For each horizon, record:
 		// - signal vs future log-return (IC, MI, ΔLL)
@@ -721,7 +797,7 @@ func RunStrategy(raw *TBBOColumns, config AssetConfig, report *SymbolReport) {
 
 			for sIdx := 0; sIdx < NumSignals; sIdx++ {
 				sig := alphas[sIdx]
-				sigStats[sIdx][h].Observe(sig, retLog)
+				s.sigStats[sIdx][h].Observe(sig, retLog)
 
 				if sig == 0 || math.IsNaN(sig) {
 					continue
@@ -731,10 +807,11 @@ func RunStrategy(raw *TBBOColumns, config AssetConfig, report *SymbolReport) {
 					dir = -1.0
 				}
 				stratRet := dir * retLog
-				trdStats[sIdx][h].Update(stratRet, stratRet, 0.0)
+				s.trdStats[sIdx][h].Update(stratRet, stratRet, 0.0)
 			}
 		}
 
-		mp.UpdateState(i, raw, &atoms)
+		mp.UpdateState(i, raw, atoms)
 	}
+	s.next = n
 }
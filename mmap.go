@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"unsafe"
+)
+
+// -----------------------------------------------------------------------------
+// Memory-mapped TBBO files.
+//
+// MmapQuantDev maps a .quantdev file read-only and hands out each chunk as
+// TBBOColumns whose slices point straight into the mapping. The encoder pads
+// every chunk so its column data starts 8-byte aligned; with ChunkSize rows
+// every column then stays aligned. Columns that are not (the short last
+// chunk, files written before the padding) are copied instead, and encoded
+// columns (`data -compact`) are decoded. Columns are found through the
+// file's column directory (format.go). Chunk does not check column
+// checksums, which would touch every page; run `verify` first if the file is
+// suspect.
+//
+// Lifecycle: a mapping is reference counted. MmapQuantDev and
+// MmapQuantDevShared each return one reference that Close releases; the file
+// is unmapped when the last one goes. Chunk views must not be used after that
+// and must never be written to. TBBOColumns filled by Chunk are marked as
+// mapped, so handing them to TBBOPool or a loader is safe: Reset drops the
+// views rather than reusing mapped memory as a buffer.
+// -----------------------------------------------------------------------------
+
+// MappedQuantDev is a read-only mapping of a TBBO .quantdev file.
+type MappedQuantDev struct {
+	Header  gncHeader
+	Chunks  []ChunkInfo
+	Columns *ColumnDir
+
+	path   string
+	data   []byte
+	refs   int // guarded by mmapMu
+	shared bool
+}
+
+var (
+	mmapMu sync.Mutex
+	// Process-wide mappings handed out by MmapQuantDevShared.
+	sharedMaps = make(map[string]*MappedQuantDev)
+)
+
+var errMappingClosed = errors.New("quantdev mapping is closed")
+
+// MmapQuantDev maps the TBBO file at path. The caller owns one reference and
+// must Close it.
+func MmapQuantDev(path string) (*MappedQuantDev, error) {
+	f, x, err := openGNC(path, LayoutTBBO)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := x.Header.checkPriceEnc(); err != nil {
+		return nil, err
+	}
+
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	data, err := mapFile(f, int(st.Size()))
+	if err != nil {
+		return nil, fmt.Errorf("mmap %s: %w", path, err)
+	}
+	return &MappedQuantDev{
+		Header: x.Header, Chunks: x.Chunks, Columns: x.Columns,
+		path: path, data: data, refs: 1,
+	}, nil
+}
+
+// MmapQuantDevShared returns the process-wide mapping of path, mapping it on
+// first use. Like MmapQuantDev, each call returns a reference to Close.
+func MmapQuantDevShared(path string) (*MappedQuantDev, error) {
+	mmapMu.Lock()
+	if m := sharedMaps[path]; m != nil {
+		m.refs++
+		mmapMu.Unlock()
+		return m, nil
+	}
+	mmapMu.Unlock()
+
+	// Map outside the lock; if another goroutine won the race, use its
+	// mapping and drop ours.
+	m, err := MmapQuantDev(path)
+	if err != nil {
+		return nil, err
+	}
+	mmapMu.Lock()
+	if cur := sharedMaps[path]; cur != nil {
+		cur.refs++
+		mmapMu.Unlock()
+		m.Close()
+		return cur, nil
+	}
+	m.shared = true
+	sharedMaps[path] = m
+	mmapMu.Unlock()
+	return m, nil
+}
+
+// Retain adds a reference for another owner, who must Close it.
+func (m *MappedQuantDev) Retain() *MappedQuantDev {
+	mmapMu.Lock()
+	defer mmapMu.Unlock()
+	if m.refs > 0 {
+		m.refs++
+	}
+	return m
+}
+
+// Close releases one reference and unmaps the file after the last.
+func (m *MappedQuantDev) Close() error {
+	mmapMu.Lock()
+	if m.refs == 0 {
+		mmapMu.Unlock()
+		return errMappingClosed
+	}
+	m.refs--
+	if m.refs > 0 {
+		mmapMu.Unlock()
+		return nil
+	}
+	if m.shared && sharedMaps[m.path] == m {
+		delete(sharedMaps, m.path)
+	}
+	data := m.data
+	m.data = nil
+	mmapMu.Unlock()
+	return unmapFile(data)
+}
+
+// Rows is the total row count of the file.
+func (m *MappedQuantDev) Rows() int {
+	return m.Header.Rows
+}
+
+// Chunk points dst at the rows of chunk i. Aligned columns alias the mapping;
+// in fixed-point files the float64 price views are computed into fresh
+// slices. dst is only valid while m is open and must not be written to.
+func (m *MappedQuantDev) Chunk(i int, dst *TBBOColumns) error {
+	if i < 0 || i >= len(m.Chunks) {
+		return fmt.Errorf("chunk %d out of range [0,%d)", i, len(m.Chunks))
+	}
+	mmapMu.Lock()
+	data := m.data
+	mmapMu.Unlock()
+	if data == nil {
+		return errMappingClosed
+	}
+
+	n := m.Chunks[i].Rows
+	exact := m.Header.PriceEnc == PriceFixedInt64
+
+	*dst = TBBOColumns{Count: n, mapped: true}
+
+	var err error
+
+	// prices maps one price column: float64 in place, or int64 in place
+	// plus a computed float64 view.
+	prices := func(c ColumnSet, raw *[]int64) []float64 {
+		if !exact {
+			return mapColumn[float64](m, data, i, c.name(), n, &err)
+		}
+		*raw = mapColumn[int64](m, data, i, c.name(), n, &err)
+		px := make([]float64, n)
+		scaleInto(px, *raw, m.Header.PriceScale)
+		return px
+	}
+
+	dst.TsEvent = mapColumn[uint64](m, data, i, ColTsEvent.name(), n, &err)
+	dst.TsRecv = mapColumn[uint64](m, data, i, ColTsRecv.name(), n, &err)
+	dst.TsInDelta = mapColumn[int32](m, data, i, ColTsInDelta.name(), n, &err)
+	dst.Prices = prices(ColPrices, &dst.PricesRaw)
+	dst.Sizes = mapColumn[float64](m, data, i, ColSizes.name(), n, &err)
+	dst.Sides = mapColumn[int8](m, data, i, ColSides.name(), n, &err)
+	dst.Actions = mapColumn[int8](m, data, i, ColActions.name(), n, &err)
+	dst.Flags = mapColumn[uint8](m, data, i, ColFlags.name(), n, &err)
+	dst.Depth = mapColumn[uint8](m, data, i, ColDepth.name(), n, &err)
+	dst.Sequences = mapColumn[uint32](m, data, i, ColSequences.name(), n, &err)
+	dst.BidPx = prices(ColBidPx, &dst.BidPxRaw)
+	dst.AskPx = prices(ColAskPx, &dst.AskPxRaw)
+	dst.BidSz = mapColumn[float64](m, data, i, ColBidSz.name(), n, &err)
+	dst.AskSz = mapColumn[float64](m, data, i, ColAskSz.name(), n, &err)
+	dst.BidCt = mapColumn[uint32](m, data, i, ColBidCt.name(), n, &err)
+	dst.AskCt = mapColumn[uint32](m, data, i, ColAskCt.name(), n, &err)
+	dst.PublisherID = mapColumn[uint16](m, data, i, ColPublisherID.name(), n, &err)
+	dst.InstrumentID = mapColumn[uint32](m, data, i, ColInstrumentID.name(), n, &err)
+	if exact {
+		dst.PriceScale = m.Header.PriceScale
+	}
+	if err != nil {
+		*dst = TBBOColumns{}
+		return fmt.Errorf("chunk %d: %w", i, err)
+	}
+	return nil
+}
+
+// mapColumn returns the n values of the named column in chunk k. The slice
+// aliases data when it is suitably aligned and is a copy otherwise; encoded
+// columns are decoded into a fresh slice and a column the file lacks is zeros.
+// The first failure is stored in *err, after which mapColumn returns nil.
+// Extents were checked against the footer when the directory was read.
+func mapColumn[T any](m *MappedQuantDev, data []byte, k int, name string, n int, err *error) []T {
+	if *err != nil {
+		return nil
+	}
+	c := m.Columns.Index(name)
+	if c < 0 {
+		return make([]T, n)
+	}
+	var zero T
+	e, xerr := m.Columns.extent(k, c, dtypeOf[T](), n*int(unsafe.Sizeof(zero)))
+	if xerr != nil {
+		*err = xerr
+		return nil
+	}
+	if n == 0 {
+		return nil
+	}
+	b := data[e.Offset : e.Offset+e.Bytes]
+	if enc := m.Columns.Columns[c].Encoding; enc != EncodingPlain {
+		out := make([]T, n)
+		if xerr := decodeColumn(enc, dtypeOf[T](), b, asBytes(out)); xerr != nil {
+			*err = fmt.Errorf("column %s (%s): %w", name, enc, xerr)
+			return nil
+		}
+		return out
+	}
+	if uintptr(unsafe.Pointer(&b[0]))%unsafe.Alignof(zero) != 0 {
+		out := make([]T, n)
+		copy(asBytes(out), b)
+		return out
+	}
+	return unsafe.Slice((*T)(unsafe.Pointer(&b[0])), n)
+}
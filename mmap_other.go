@@ -0,0 +1,22 @@
+//go:build !(linux || darwin || freebsd || windows)
+
+package main
+
+import (
+	"io"
+	"os"
+)
+
+// Without mmap the file is read into memory once; the views behave the same.
+func mapFile(f *os.File, size int) ([]byte, error) {
+	data := make([]byte, size)
+	_, err := f.ReadAt(data, 0)
+	if err == io.EOF {
+		err = nil
+	}
+	return data, err
+}
+
+func unmapFile(data []byte) error {
+	return nil
+}
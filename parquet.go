@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"slices"
+)
+
+// -----------------------------------------------------------------------------
+// Apache Parquet writer: one row group per WriteBatch, one uncompressed
+// PLAIN data page (v1) per column chunk, every column REQUIRED so pages hold
+// no definition or repetition levels.
+//
+//	"PAR1" [column chunks...] [FileMetaData] [u32 metadata length] "PAR1"
+//
+// Page headers and FileMetaData use the Thrift compact protocol (thriftWriter
+// below). 4- and 8-byte columns are written from the TBBOColumns slices as
+// they are; int8, uint8 and uint16 columns are widened to INT32, the
+// narrowest Parquet integer, with an INTEGER logical type recording the
+// original width. Timestamps are INT64 TIMESTAMP(NANOS, UTC).
+// -----------------------------------------------------------------------------
+
+const parquetMagic = "PAR1"
+
+// Parquet enums (parquet.thrift).
+const (
+	parquetInt32  = 1
+	parquetInt64  = 2
+	parquetDouble = 5
+
+	parquetRequired = 0
+	parquetPlain    = 0
+	parquetRLE      = 3
+	parquetDataPage = 0
+
+	parquetConvertedUint8  = 11
+	parquetConvertedUint16 = 12
+	parquetConvertedUint32 = 13
+	parquetConvertedUint64 = 14
+	parquetConvertedInt8   = 15
+	parquetConvertedInt32  = 17
+	parquetConvertedInt64  = 18
+)
+
+// ParquetWriter writes TBBO batches as a Parquet file.
+type ParquetWriter struct {
+	w    *countingWriter
+	cols []exportColumn
+	meta FileMeta
+
+	rows   int64
+	groups [][]byte // serialized RowGroup structs
+	wide   []int32  // widening scratch
+}
+
+// NewParquetWriter starts a Parquet file with the columns cols on w. meta
+// becomes the file's key-value metadata.
+func NewParquetWriter(w io.Writer, cols []exportColumn, meta FileMeta) (*ParquetWriter, error) {
+	p := &ParquetWriter{w: &countingWriter{w: w}, cols: cols, meta: meta}
+	if _, err := p.w.Write([]byte(parquetMagic)); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// WriteBatch writes the rows of c as one row group.
+func (p *ParquetWriter) WriteBatch(c *TBBOColumns) error {
+	if c.Count == 0 {
+		return nil
+	}
+	var rg thriftWriter
+	rg.listBegin(1, thriftStruct, len(p.cols))
+	var total int64
+	for _, col := range p.cols {
+		data := p.plain(c, col)
+		pageOff := p.w.n
+
+		var hdr thriftWriter
+		hdr.i32(1, parquetDataPage)
+		hdr.i32(2, int32(len(data)))
+		hdr.i32(3, int32(len(data)))
+		hdr.structBegin(5)
+		hdr.i32(1, int32(c.Count))
+		hdr.i32(2, parquetPlain)
+		hdr.i32(3, parquetRLE)
+		hdr.i32(4, parquetRLE)
+		hdr.structEnd()
+		hdr.stop()
+
+		if _, err := p.w.Write(hdr.b); err != nil {
+			return err
+		}
+		if _, err := p.w.Write(data); err != nil {
+			return err
+		}
+		size := int64(len(hdr.b) + len(data))
+		total += size
+
+		// ColumnChunk
+		rg.elemBegin()
+		rg.i64(2, pageOff)
+		rg.structBegin(3)
+		rg.i32(1, col.parquetType())
+		rg.listBegin(2, thriftI32, 1)
+		rg.zigzag(parquetPlain)
+		rg.listBegin(3, thriftBinary, 1)
+		rg.binary(col.name)
+		rg.i32(4, 0) // UNCOMPRESSED
+		rg.i64(5, int64(c.Count))
+		rg.i64(6, size)
+		rg.i64(7, size)
+		rg.i64(9, pageOff)
+		rg.structEnd()
+		rg.elemEnd()
+	}
+	rg.i64(2, total)
+	rg.i64(3, int64(c.Count))
+	rg.stop()
+
+	p.groups = append(p.groups, rg.b)
+	p.rows += int64(c.Count)
+	return nil
+}
+
+// plain returns the PLAIN encoding of a column: its bytes, widened to
+// INT32 for the narrow integer types.
+func (p *ParquetWriter) plain(c *TBBOColumns, col exportColumn) []byte {
+	b := c.column(col.set)
+	switch col.dtype {
+	case DTypeI8:
+		p.wide = resize(p.wide, len(b))
+		for i, v := range b {
+			p.wide[i] = int32(int8(v))
+		}
+	case DTypeU8:
+		p.wide = resize(p.wide, len(b))
+		for i, v := range b {
+			p.wide[i] = int32(v)
+		}
+	case DTypeU16:
+		p.wide = resize(p.wide, len(b)/2)
+		for i := range p.wide {
+			p.wide[i] = int32(binary.LittleEndian.Uint16(b[2*i:]))
+		}
+	default:
+		return b
+	}
+	return asBytes(p.wide)
+}
+
+// Close writes the footer. It does not close the underlying writer.
+func (p *ParquetWriter) Close() error {
+	var m thriftWriter
+	m.i32(1, 1) // version
+	m.listBegin(2, thriftStruct, len(p.cols)+1)
+	m.elemBegin()
+	m.str(4, "schema")
+	m.i32(5, int32(len(p.cols)))
+	m.elemEnd()
+	for _, col := range p.cols {
+		m.elemBegin()
+		m.i32(1, col.parquetType())
+		m.i32(3, parquetRequired)
+		m.str(4, col.name)
+		col.parquetLogicalType(&m)
+		m.elemEnd()
+	}
+	m.i64(3, p.rows)
+	m.listBegin(4, thriftStruct, len(p.groups))
+	for _, g := range p.groups {
+		m.b = append(m.b, g...)
+	}
+	if len(p.meta) > 0 {
+		keys := make([]string, 0, len(p.meta))
+		for k := range p.meta {
+			keys = append(keys, k)
+		}
+		slices.Sort(keys)
+		m.listBegin(5, thriftStruct, len(keys))
+		for _, k := range keys {
+			m.elemBegin()
+			m.str(1, k)
+			m.str(2, p.meta[k])
+			m.elemEnd()
+		}
+	}
+	m.str(6, "go-tbbo export")
+	m.stop()
+
+	if _, err := p.w.Write(m.b); err != nil {
+		return err
+	}
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(m.b)))
+	if _, err := p.w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := p.w.Write([]byte(parquetMagic))
+	return err
+}
+
+func (col exportColumn) parquetType() int32 {
+	switch col.dtype {
+	case DTypeF64:
+		return parquetDouble
+	case DTypeU64, DTypeI64:
+		return parquetInt64
+	}
+	return parquetInt32
+}
+
+// parquetLogicalType writes the converted_type and logicalType fields of a
+// SchemaElement.
+func (col exportColumn) parquetLogicalType(m *thriftWriter) {
+	if col.time {
+		// No converted type exists for nanoseconds.
+		m.structBegin(10)
+		m.structBegin(8) // TIMESTAMP
+		m.bool(1, true)  // isAdjustedToUTC
+		m.structBegin(2)
+		m.structBegin(3) // NANOS
+		m.structEnd()
+		m.structEnd()
+		m.structEnd()
+		m.structEnd()
+		return
+	}
+	var converted int32
+	switch col.dtype {
+	case DTypeF64:
+		return
+	case DTypeI8:
+		converted = parquetConvertedInt8
+	case DTypeU8:
+		converted = parquetConvertedUint8
+	case DTypeU16:
+		converted = parquetConvertedUint16
+	case DTypeI32:
+		converted = parquetConvertedInt32
+	case DTypeU32:
+		converted = parquetConvertedUint32
+	case DTypeI64:
+		converted = parquetConvertedInt64
+	case DTypeU64:
+		converted = parquetConvertedUint64
+	}
+	m.i32(6, converted)
+	m.structBegin(10)
+	m.structBegin(10) // INTEGER
+	m.i8(1, int8(col.dtype.Size()*8))
+	m.bool(2, col.dtype.signed())
+	m.structEnd()
+	m.structEnd()
+}
+
+// =============================================================================
+//  Thrift compact protocol
+// =============================================================================
+
+const (
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftI8     = 3
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thriftWriter appends compact-protocol fields to b. Field ids are
+// delta-encoded against the previous field of the enclosing struct, which
+// last tracks per nesting level.
+type thriftWriter struct {
+	b    []byte
+	last []int16
+	cur  int16
+}
+
+func (t *thriftWriter) field(id int16, typ byte) {
+	if d := id - t.cur; d > 0 && d <= 15 {
+		t.b = append(t.b, byte(d)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.zigzag(int64(id))
+	}
+	t.cur = id
+}
+
+func (t *thriftWriter) varint(v uint64) {
+	t.b = binary.AppendUvarint(t.b, v)
+}
+
+func (t *thriftWriter) zigzag(v int64) {
+	t.varint(uint64(v<<1) ^ uint64(v>>63))
+}
+
+func (t *thriftWriter) bool(id int16, v bool) {
+	typ := byte(thriftFalse)
+	if v {
+		typ = thriftTrue
+	}
+	t.field(id, typ)
+}
+
+func (t *thriftWriter) i8(id int16, v int8) {
+	t.field(id, thriftI8)
+	t.b = append(t.b, byte(v))
+}
+
+func (t *thriftWriter) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.zigzag(int64(v))
+}
+
+func (t *thriftWriter) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.zigzag(v)
+}
+
+func (t *thriftWriter) str(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.binary(s)
+}
+
+// binary writes a string value without a field header (list elements).
+func (t *thriftWriter) binary(s string) {
+	t.varint(uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func (t *thriftWriter) structBegin(id int16) {
+	t.field(id, thriftStruct)
+	t.elemBegin()
+}
+
+func (t *thriftWriter) structEnd() {
+	t.elemEnd()
+}
+
+// elemBegin and elemEnd bracket a struct that is a list element.
+func (t *thriftWriter) elemBegin() {
+	t.last = append(t.last, t.cur)
+	t.cur = 0
+}
+
+func (t *thriftWriter) elemEnd() {
+	t.stop()
+	t.cur = t.last[len(t.last)-1]
+	t.last = t.last[:len(t.last)-1]
+}
+
+func (t *thriftWriter) stop() {
+	t.b = append(t.b, 0)
+}
+
+// listBegin writes a list field header; the n elements follow.
+func (t *thriftWriter) listBegin(id int16, elem byte, n int) {
+	t.field(id, thriftList)
+	if n < 15 {
+		t.b = append(t.b, byte(n)<<4|elem)
+		return
+	}
+	t.b = append(t.b, 0xF0|elem)
+	t.varint(uint64(n))
+}
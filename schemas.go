@@ -0,0 +1,542 @@
+package main
+
+import (
+	"fmt"
+)
+
+// -----------------------------------------------------------------------------
+// Non-TBBO DBN schemas: trades (MBP-0), MBP-10 and OHLCV bars.
+//
+// Each schema has its own SoA layout, encoder and loader. Chunk framing,
+// header and footer are shared with the TBBO path (gncFile / ColumnDir); only
+// the column set differs. columnBytes yields the columns in layoutSchema
+// order (format.go) for both directions, so encoder and loader cannot drift
+// apart.
+// -----------------------------------------------------------------------------
+
+const MBP10Levels = 10
+
+// TradeEvent holds the event fields shared by trades, MBP-1 and MBP-10
+// records, still in DBN units (fixed-9 prices).
+type TradeEvent struct {
+	PubID     uint16
+	InstrID   uint32
+	TsEvent   uint64
+	TsRecv    uint64
+	TsInDelta int32
+	PxRaw     int64
+	Size      uint32
+	Side      int8 // already mapped: +1 buy, -1 sell, 0 none
+	Action    int8
+	Flags     uint8
+	Depth     uint8
+	Seq       uint32
+}
+
+// BookLevel is one bid/ask level of an MBP record (fixed-9 prices).
+type BookLevel struct {
+	BidPxRaw int64
+	AskPxRaw int64
+	BidSz    uint32
+	AskSz    uint32
+	BidCt    uint32
+	AskCt    uint32
+}
+
+// OHLCVBar is one OHLCV record (fixed-9 prices).
+type OHLCVBar struct {
+	PubID    uint16
+	InstrID  uint32
+	TsEvent  uint64 // bar open time
+	OpenRaw  int64
+	HighRaw  int64
+	LowRaw   int64
+	CloseRaw int64
+	Volume   uint64
+}
+
+// =============================================================================
+//  Trades
+// =============================================================================
+
+// TradesColumns is the DBN trades schema (rtype 0x00): the TBBO event fields
+// without a book snapshot. It is also the event half of MBP10Columns.
+type TradesColumns struct {
+	Count int
+
+	PublisherID  []uint16
+	InstrumentID []uint32
+
+	TsEvent   []uint64
+	TsRecv    []uint64
+	TsInDelta []int32
+
+	Prices    []float64
+	Sizes     []float64
+	Sides     []int8
+	Actions   []int8
+	Flags     []uint8
+	Depth     []uint8
+	Sequences []uint32
+}
+
+func (c *TradesColumns) Reset() {
+	c.Count = 0
+	c.PublisherID = c.PublisherID[:0]
+	c.InstrumentID = c.InstrumentID[:0]
+	c.TsEvent = c.TsEvent[:0]
+	c.TsRecv = c.TsRecv[:0]
+	c.TsInDelta = c.TsInDelta[:0]
+	c.Prices = c.Prices[:0]
+	c.Sizes = c.Sizes[:0]
+	c.Sides = c.Sides[:0]
+	c.Actions = c.Actions[:0]
+	c.Flags = c.Flags[:0]
+	c.Depth = c.Depth[:0]
+	c.Sequences = c.Sequences[:0]
+}
+
+func (c *TradesColumns) resize(n int) {
+	c.PublisherID = resize(c.PublisherID, n)
+	c.InstrumentID = resize(c.InstrumentID, n)
+	c.TsEvent = resize(c.TsEvent, n)
+	c.TsRecv = resize(c.TsRecv, n)
+	c.TsInDelta = resize(c.TsInDelta, n)
+	c.Prices = resize(c.Prices, n)
+	c.Sizes = resize(c.Sizes, n)
+	c.Sides = resize(c.Sides, n)
+	c.Actions = resize(c.Actions, n)
+	c.Flags = resize(c.Flags, n)
+	c.Depth = resize(c.Depth, n)
+	c.Sequences = resize(c.Sequences, n)
+}
+
+func (c *TradesColumns) appendEvent(ev *TradeEvent) {
+	c.PublisherID = append(c.PublisherID, ev.PubID)
+	c.InstrumentID = append(c.InstrumentID, ev.InstrID)
+	c.TsEvent = append(c.TsEvent, ev.TsEvent)
+	c.TsRecv = append(c.TsRecv, ev.TsRecv)
+	c.TsInDelta = append(c.TsInDelta, ev.TsInDelta)
+	c.Prices = append(c.Prices, float64(ev.PxRaw)*PxScale)
+	c.Sizes = append(c.Sizes, float64(ev.Size))
+	c.Sides = append(c.Sides, ev.Side)
+	c.Actions = append(c.Actions, ev.Action)
+	c.Flags = append(c.Flags, ev.Flags)
+	c.Depth = append(c.Depth, ev.Depth)
+	c.Sequences = append(c.Sequences, ev.Seq)
+	c.Count++
+}
+
+// columnBytes returns byte views of rows [i0:i1] of every column in file order.
+func (c *TradesColumns) columnBytes(dst [][]byte, i0, i1 int) [][]byte {
+	return append(dst,
+		asBytes(c.TsEvent[i0:i1]),
+		asBytes(c.TsRecv[i0:i1]),
+		asBytes(c.TsInDelta[i0:i1]),
+		asBytes(c.Prices[i0:i1]),
+		asBytes(c.Sizes[i0:i1]),
+		asBytes(c.Sides[i0:i1]),
+		asBytes(c.Actions[i0:i1]),
+		asBytes(c.Flags[i0:i1]),
+		asBytes(c.Depth[i0:i1]),
+		asBytes(c.Sequences[i0:i1]),
+		asBytes(c.PublisherID[i0:i1]),
+		asBytes(c.InstrumentID[i0:i1]),
+	)
+}
+
+type TradesEncoder struct {
+	buf  TradesColumns
+	cols [][]byte
+	gncFile
+}
+
+func NewTradesEncoder(path string) (*TradesEncoder, error) {
+	g, err := createGNC(path, LayoutTrades, RTypeMBP0)
+	if err != nil {
+		return nil, err
+	}
+	e := &TradesEncoder{gncFile: g}
+	e.buf.resize(ChunkSize)
+	e.buf.Reset()
+	return e, nil
+}
+
+func (e *TradesEncoder) AddRow(ev *TradeEvent) error {
+	e.buf.appendEvent(ev)
+	e.totalRows++
+	if e.buf.Count >= ChunkSize {
+		return e.flushChunk()
+	}
+	return nil
+}
+
+func (e *TradesEncoder) flushChunk() error {
+	n := e.buf.Count
+	if n == 0 {
+		return nil
+	}
+	lo, hi := minMaxPx(e.buf.Prices)
+	e.addZone(e.buf.TsEvent, e.buf.InstrumentID, lo, hi, 1)
+	if err := e.beginChunk(n); err != nil {
+		return err
+	}
+	e.cols = e.buf.columnBytes(e.cols[:0], 0, n)
+	if err := e.writeColumns(e.cols...); err != nil {
+		return err
+	}
+	e.buf.Reset()
+	return nil
+}
+
+func (e *TradesEncoder) Close() error {
+	if err := e.flushChunk(); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+func LoadTrades(path string) (*TradesColumns, error) {
+	cols := &TradesColumns{}
+	if _, err := loadColumns(path, LayoutTrades, func(n int) {
+		cols.resize(n)
+		cols.Count = n
+	}, cols.columnBytes); err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// =============================================================================
+//  MBP-10
+// =============================================================================
+
+// MBP10Columns is the DBN MBP-10 schema (rtype 0x0A): the event fields plus
+// ten book levels, one column per (field, level). Level 0 is the best price.
+type MBP10Columns struct {
+	TradesColumns
+
+	BidPx [MBP10Levels][]float64
+	AskPx [MBP10Levels][]float64
+	BidSz [MBP10Levels][]float64
+	AskSz [MBP10Levels][]float64
+	BidCt [MBP10Levels][]uint32
+	AskCt [MBP10Levels][]uint32
+}
+
+func (c *MBP10Columns) Reset() {
+	c.TradesColumns.Reset()
+	for l := 0; l < MBP10Levels; l++ {
+		c.BidPx[l] = c.BidPx[l][:0]
+		c.AskPx[l] = c.AskPx[l][:0]
+		c.BidSz[l] = c.BidSz[l][:0]
+		c.AskSz[l] = c.AskSz[l][:0]
+		c.BidCt[l] = c.BidCt[l][:0]
+		c.AskCt[l] = c.AskCt[l][:0]
+	}
+}
+
+func (c *MBP10Columns) resize(n int) {
+	c.TradesColumns.resize(n)
+	for l := 0; l < MBP10Levels; l++ {
+		c.BidPx[l] = resize(c.BidPx[l], n)
+		c.AskPx[l] = resize(c.AskPx[l], n)
+		c.BidSz[l] = resize(c.BidSz[l], n)
+		c.AskSz[l] = resize(c.AskSz[l], n)
+		c.BidCt[l] = resize(c.BidCt[l], n)
+		c.AskCt[l] = resize(c.AskCt[l], n)
+	}
+}
+
+func (c *MBP10Columns) appendRow(ev *TradeEvent, levels *[MBP10Levels]BookLevel) {
+	c.appendEvent(ev)
+	for l := range levels {
+		lv := &levels[l]
+		c.BidPx[l] = append(c.BidPx[l], float64(lv.BidPxRaw)*PxScale)
+		c.AskPx[l] = append(c.AskPx[l], float64(lv.AskPxRaw)*PxScale)
+		c.BidSz[l] = append(c.BidSz[l], float64(lv.BidSz))
+		c.AskSz[l] = append(c.AskSz[l], float64(lv.AskSz))
+		c.BidCt[l] = append(c.BidCt[l], lv.BidCt)
+		c.AskCt[l] = append(c.AskCt[l], lv.AskCt)
+	}
+}
+
+// columnBytes: event columns, then each book field for levels 0..9.
+func (c *MBP10Columns) columnBytes(dst [][]byte, i0, i1 int) [][]byte {
+	dst = c.TradesColumns.columnBytes(dst, i0, i1)
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.BidPx[l][i0:i1]))
+	}
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.AskPx[l][i0:i1]))
+	}
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.BidSz[l][i0:i1]))
+	}
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.AskSz[l][i0:i1]))
+	}
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.BidCt[l][i0:i1]))
+	}
+	for l := 0; l < MBP10Levels; l++ {
+		dst = append(dst, asBytes(c.AskCt[l][i0:i1]))
+	}
+	return dst
+}
+
+// TopOfBook exposes level 0 as TBBO columns so MBP-10 data can feed
+// RunStrategy. dst aliases c's backing arrays; no data is copied.
+func (c *MBP10Columns) TopOfBook(dst *TBBOColumns) {
+	dst.Count = c.Count
+	dst.PublisherID = c.PublisherID
+	dst.InstrumentID = c.InstrumentID
+	dst.TsEvent = c.TsEvent
+	dst.TsRecv = c.TsRecv
+	dst.TsInDelta = c.TsInDelta
+	dst.Prices = c.Prices
+	dst.Sizes = c.Sizes
+	dst.Sides = c.Sides
+	dst.Actions = c.Actions
+	dst.Flags = c.Flags
+	dst.Depth = c.Depth
+	dst.Sequences = c.Sequences
+	dst.BidPx = c.BidPx[0]
+	dst.AskPx = c.AskPx[0]
+	dst.BidSz = c.BidSz[0]
+	dst.AskSz = c.AskSz[0]
+	dst.BidCt = c.BidCt[0]
+	dst.AskCt = c.AskCt[0]
+	dst.PriceScale = 0
+	dst.PricesRaw = dst.PricesRaw[:0]
+	dst.BidPxRaw = dst.BidPxRaw[:0]
+	dst.AskPxRaw = dst.AskPxRaw[:0]
+}
+
+type MBP10Encoder struct {
+	buf  MBP10Columns
+	cols [][]byte
+	gncFile
+}
+
+func NewMBP10Encoder(path string) (*MBP10Encoder, error) {
+	g, err := createGNC(path, LayoutMBP10, RTypeMBP10)
+	if err != nil {
+		return nil, err
+	}
+	e := &MBP10Encoder{gncFile: g}
+	e.buf.resize(ChunkSize)
+	e.buf.Reset()
+	return e, nil
+}
+
+func (e *MBP10Encoder) AddRow(ev *TradeEvent, levels *[MBP10Levels]BookLevel) error {
+	e.buf.appendRow(ev, levels)
+	e.totalRows++
+	if e.buf.Count >= ChunkSize {
+		return e.flushChunk()
+	}
+	return nil
+}
+
+func (e *MBP10Encoder) flushChunk() error {
+	n := e.buf.Count
+	if n == 0 {
+		return nil
+	}
+	lo, hi := minMaxPx(e.buf.Prices)
+	e.addZone(e.buf.TsEvent, e.buf.InstrumentID, lo, hi, 1)
+	if err := e.beginChunk(n); err != nil {
+		return err
+	}
+	e.cols = e.buf.columnBytes(e.cols[:0], 0, n)
+	if err := e.writeColumns(e.cols...); err != nil {
+		return err
+	}
+	e.buf.Reset()
+	return nil
+}
+
+func (e *MBP10Encoder) Close() error {
+	if err := e.flushChunk(); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+func LoadMBP10(path string) (*MBP10Columns, error) {
+	cols := &MBP10Columns{}
+	if _, err := loadColumns(path, LayoutMBP10, func(n int) {
+		cols.resize(n)
+		cols.Count = n
+	}, cols.columnBytes); err != nil {
+		return nil, err
+	}
+	return cols, nil
+}
+
+// =============================================================================
+//  OHLCV
+// =============================================================================
+
+// OHLCVColumns holds OHLCV bars (rtypes 0x20-0x24). RType identifies the bar
+// interval (RTypeOHLCV1S .. RTypeOHLCVEOD).
+type OHLCVColumns struct {
+	Count int
+	RType uint8
+
+	PublisherID  []uint16
+	InstrumentID []uint32
+	TsEvent      []uint64
+
+	Open   []float64
+	High   []float64
+	Low    []float64
+	Close  []float64
+	Volume []float64
+}
+
+func (c *OHLCVColumns) Reset() {
+	c.Count = 0
+	c.PublisherID = c.PublisherID[:0]
+	c.InstrumentID = c.InstrumentID[:0]
+	c.TsEvent = c.TsEvent[:0]
+	c.Open = c.Open[:0]
+	c.High = c.High[:0]
+	c.Low = c.Low[:0]
+	c.Close = c.Close[:0]
+	c.Volume = c.Volume[:0]
+}
+
+func (c *OHLCVColumns) resize(n int) {
+	c.PublisherID = resize(c.PublisherID, n)
+	c.InstrumentID = resize(c.InstrumentID, n)
+	c.TsEvent = resize(c.TsEvent, n)
+	c.Open = resize(c.Open, n)
+	c.High = resize(c.High, n)
+	c.Low = resize(c.Low, n)
+	c.Close = resize(c.Close, n)
+	c.Volume = resize(c.Volume, n)
+}
+
+func (c *OHLCVColumns) appendBar(b *OHLCVBar) {
+	c.PublisherID = append(c.PublisherID, b.PubID)
+	c.InstrumentID = append(c.InstrumentID, b.InstrID)
+	c.TsEvent = append(c.TsEvent, b.TsEvent)
+	c.Open = append(c.Open, float64(b.OpenRaw)*PxScale)
+	c.High = append(c.High, float64(b.HighRaw)*PxScale)
+	c.Low = append(c.Low, float64(b.LowRaw)*PxScale)
+	c.Close = append(c.Close, float64(b.CloseRaw)*PxScale)
+	c.Volume = append(c.Volume, float64(b.Volume))
+	c.Count++
+}
+
+func (c *OHLCVColumns) columnBytes(dst [][]byte, i0, i1 int) [][]byte {
+	return append(dst,
+		asBytes(c.TsEvent[i0:i1]),
+		asBytes(c.Open[i0:i1]),
+		asBytes(c.High[i0:i1]),
+		asBytes(c.Low[i0:i1]),
+		asBytes(c.Close[i0:i1]),
+		asBytes(c.Volume[i0:i1]),
+		asBytes(c.PublisherID[i0:i1]),
+		asBytes(c.InstrumentID[i0:i1]),
+	)
+}
+
+type OHLCVEncoder struct {
+	buf  OHLCVColumns
+	cols [][]byte
+	gncFile
+}
+
+// NewOHLCVEncoder creates an encoder for bars of one interval; rtype is the
+// DBN rtype of the bars (RTypeOHLCV1S .. RTypeOHLCVEOD).
+func NewOHLCVEncoder(path string, rtype uint8) (*OHLCVEncoder, error) {
+	g, err := createGNC(path, LayoutOHLCV, rtype)
+	if err != nil {
+		return nil, err
+	}
+	e := &OHLCVEncoder{gncFile: g}
+	e.buf.resize(ChunkSize)
+	e.buf.Reset()
+	return e, nil
+}
+
+func (e *OHLCVEncoder) AddRow(b *OHLCVBar) error {
+	e.buf.appendBar(b)
+	e.totalRows++
+	if e.buf.Count >= ChunkSize {
+		return e.flushChunk()
+	}
+	return nil
+}
+
+func (e *OHLCVEncoder) flushChunk() error {
+	n := e.buf.Count
+	if n == 0 {
+		return nil
+	}
+	lo, _ := minMaxPx(e.buf.Low)
+	_, hi := minMaxPx(e.buf.High)
+	e.addZone(e.buf.TsEvent, e.buf.InstrumentID, lo, hi, 1)
+	if err := e.beginChunk(n); err != nil {
+		return err
+	}
+	e.cols = e.buf.columnBytes(e.cols[:0], 0, n)
+	if err := e.writeColumns(e.cols...); err != nil {
+		return err
+	}
+	e.buf.Reset()
+	return nil
+}
+
+func (e *OHLCVEncoder) Close() error {
+	if err := e.flushChunk(); err != nil {
+		return err
+	}
+	return e.finish()
+}
+
+func LoadOHLCV(path string) (*OHLCVColumns, error) {
+	cols := &OHLCVColumns{}
+	hdr, err := loadColumns(path, LayoutOHLCV, func(n int) {
+		cols.resize(n)
+		cols.Count = n
+	}, cols.columnBytes)
+	if err != nil {
+		return nil, err
+	}
+	cols.RType = hdr.RType
+	return cols, nil
+}
+
+// =============================================================================
+//  Shared loader
+// =============================================================================
+
+// loadColumns opens a .quantdev file of the given layout, calls alloc with
+// the total row count, then reads every chunk into the views produced by
+// views(dst, i0, i1), looking each one up in the column directory.
+func loadColumns(path string, layout uint16, alloc func(n int), views func(dst [][]byte, i0, i1 int) [][]byte) (gncHeader, error) {
+	f, x, err := openGNC(path, layout)
+	if err != nil {
+		return gncHeader{}, err
+	}
+	defer f.Close()
+
+	alloc(x.Header.Rows)
+
+	schema := layoutSchema(layout, false)
+	var bufs [][]byte
+	for k := range x.Chunks {
+		c := &x.Chunks[k]
+		bufs = views(bufs[:0], c.FirstRow, c.FirstRow+c.Rows)
+		for i, b := range bufs {
+			if err := readColumnBytes(f, x.Columns, k, schema[i], b); err != nil {
+				return x.Header, fmt.Errorf("chunk %d: %w", k, err)
+			}
+		}
+	}
+	return x.Header, nil
+}
@@ -0,0 +1,271 @@
+package main
+
+import (
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// -----------------------------------------------------------------------------
+// Partitioned ingestion: one .quantdev per instrument and/or trading day.
+//
+// Output names extend the unsplit name with the resolved symbol and day:
+//
+//	glbx_mes.dbn -> glbx_mes_MESZ4.quantdev, glbx_mes_MESH5.quantdev
+//	             -> glbx_mes_MESZ4_20241118.quantdev (with day splitting)
+//
+// Symbols come from the instrument catalog (previous runs plus this file's
+// symbol mappings); unknown instruments fall back to their numeric id.
+// -----------------------------------------------------------------------------
+
+// IngestOptions configures `data`.
+type IngestOptions struct {
+	SplitInstrument bool // one output per InstrumentID
+	SplitDay        bool // one output per trading day
+	ExactPrices     bool // TBBO prices as int64 fixed-9 (NewEncoderExact)
+	Compact         bool // encode timestamps, ids and flags (compactEncoding)
+	Force           bool // reconvert inputs the manifest says are up to date
+	Append          bool // import: extend an existing output (OpenEncoderAppend)
+
+	// Framing tolerance: share of malformed records above which a file's
+	// outputs are quarantined or removed (see withinTolerance).
+	MaxBadFrac float64
+	OnCorrupt  int // CorruptQuarantine or CorruptFail
+}
+
+const (
+	CorruptQuarantine = iota // move outputs to QuarantineDir
+	CorruptFail              // delete outputs
+
+	DefaultMaxBadFrac = 0.0001
+	QuarantineDir     = "quarantine"
+)
+
+func (o IngestOptions) split() bool {
+	return o.SplitInstrument || o.SplitDay
+}
+
+// parseIngestFlags parses
+// `data [-split instrument|day|instrument,day] [-exact] [-compact] [-force] [-max-bad f] [-on-corrupt quarantine|fail]`.
+func parseIngestFlags(args []string) IngestOptions {
+	var opts IngestOptions
+	fs := flag.NewFlagSet("data", flag.ExitOnError)
+	split := fs.String("split", "", "partition output by `instrument`, day, or instrument,day")
+	fs.BoolVar(&opts.ExactPrices, "exact", false, "store TBBO prices as int64 fixed-point")
+	fs.BoolVar(&opts.Compact, "compact", false, "delta/RLE/dictionary-encode timestamps, flags and ids")
+	fs.BoolVar(&opts.Force, "force", false, "reconvert files even if unchanged since the last run")
+	fs.Float64Var(&opts.MaxBadFrac, "max-bad", DefaultMaxBadFrac, "tolerated share of malformed DBN records")
+	onCorrupt := fs.String("on-corrupt", "quarantine", "over tolerance: `quarantine` or fail")
+	fs.Parse(args)
+
+	switch *onCorrupt {
+	case "quarantine":
+		opts.OnCorrupt = CorruptQuarantine
+	case "fail":
+		opts.OnCorrupt = CorruptFail
+	default:
+		fmt.Printf("[warn] unknown -on-corrupt value %q (want quarantine, fail)\n", *onCorrupt)
+	}
+
+	for _, part := range strings.Split(*split, ",") {
+		switch strings.TrimSpace(part) {
+		case "":
+		case "instrument":
+			opts.SplitInstrument = true
+		case "day":
+			opts.SplitDay = true
+		default:
+			fmt.Printf("[warn] unknown -split value %q (want instrument, day)\n", part)
+		}
+	}
+	return opts
+}
+
+// CME Globex pauses 21:00-22:00 UTC in summer and 22:00-23:00 UTC in winter,
+// so rolling the trading day at 22:00 UTC splits sessions in both seasons.
+const TradingDayRollUTC = 22 * time.Hour
+
+// tradingDay returns the YYYYMMDD session date of ts (ns).
+func tradingDay(ts uint64) uint32 {
+	return yyyymmdd(ts + uint64(24*time.Hour-TradingDayRollUTC))
+}
+
+// openSink returns the sink for rtype, partitioned as opts asks. Like
+// newRecordSink it returns (nil, nil) for rtypes without a layout.
+func openSink(outPath string, rtype uint8, opts IngestOptions) (recordSink, error) {
+	if !opts.split() {
+		return newRecordSink(outPath, rtype, opts)
+	}
+	if !hasLayout(rtype) {
+		return nil, nil
+	}
+	s := &splitSink{
+		outPath: outPath,
+		rtype:   rtype,
+		opts:    opts,
+		parts:   make(map[partKey]recordSink),
+	}
+	if rtype == RTypeMBO {
+		s.replay = NewMBOReplay()
+	}
+	return s, nil
+}
+
+type partKey struct {
+	instr uint32
+	day   uint32
+}
+
+// splitSink fans records out to one child sink per partition, created on
+// first use. With day splitting the partitions of earlier days are closed as
+// soon as a later day starts, to bound open files and encoder buffers.
+// Records arrive in ts_recv order but are partitioned by ts_event, so a
+// record received after the roll can still carry the previous day's
+// ts_event; a closed day is never reopened, and such a record goes to the
+// current day's partition instead.
+type splitSink struct {
+	outPath string
+	rtype   uint8
+	opts    IngestOptions
+	source  *DBNMetadata
+	meta    FileMeta
+
+	parts  map[partKey]recordSink
+	paths  []string
+	curDay uint32
+	err    error    // first Close error of an evicted partition
+	closed []string // outputs of evicted partitions, for Abort
+
+	// MBO: one book replay for all partitions, so resting orders carry
+	// across the day roll.
+	replay *MBOReplay
+}
+
+func (s *splitSink) RType() uint8 {
+	return s.rtype
+}
+
+func (s *splitSink) SetSource(m *DBNMetadata) {
+	s.source = m
+	for _, p := range s.parts {
+		p.SetSource(m)
+	}
+}
+
+func (s *splitSink) SetMeta(key, value string) {
+	if s.meta == nil {
+		s.meta = make(FileMeta)
+	}
+	s.meta[key] = value
+	for _, p := range s.parts {
+		p.SetMeta(key, value)
+	}
+}
+
+func (s *splitSink) Write(rec []byte) (bool, error) {
+	if len(rec) < 16 {
+		return false, nil
+	}
+	instr := binary.LittleEndian.Uint32(rec[4:8])
+	ts := binary.LittleEndian.Uint64(rec[8:16])
+
+	var k partKey
+	if s.opts.SplitInstrument {
+		k.instr = instr
+	}
+	if s.opts.SplitDay {
+		k.day = tradingDay(ts)
+		switch {
+		case k.day > s.curDay:
+			s.closeBefore(k.day)
+			s.curDay = k.day
+		case k.day < s.curDay:
+			k.day = s.curDay
+		}
+	}
+
+	p := s.parts[k]
+	if p == nil {
+		path := s.partPath(k, instr, ts)
+		var err error
+		if p, err = newRecordSink(path, s.rtype, s.opts); err != nil {
+			return false, err
+		}
+		if m, ok := p.(*mboSink); ok {
+			m.replay, m.shared = s.replay, true
+		}
+		p.SetSource(s.source)
+		for key, v := range s.meta {
+			p.SetMeta(key, v)
+		}
+		s.parts[k] = p
+		s.paths = append(s.paths, path)
+	}
+	return p.Write(rec)
+}
+
+// closeBefore closes the partitions of days before day.
+func (s *splitSink) closeBefore(day uint32) {
+	for k, p := range s.parts {
+		if k.day < day {
+			if err := p.Close(); err != nil && s.err == nil {
+				s.err = err
+			}
+			s.closed = append(s.closed, p.Outputs()...)
+			delete(s.parts, k)
+		}
+	}
+}
+
+func (s *splitSink) partPath(k partKey, instr uint32, ts uint64) string {
+	stem := strings.TrimSuffix(s.outPath, ".quantdev")
+	if s.opts.SplitInstrument {
+		stem += "_" + fileSafe(Instruments.Symbol(instr, ts))
+	}
+	if s.opts.SplitDay {
+		stem += fmt.Sprintf("_%d", k.day)
+	}
+	return stem + ".quantdev"
+}
+
+func (s *splitSink) Outputs() []string {
+	return s.paths
+}
+
+func (s *splitSink) Close() error {
+	err := s.err
+	for k, p := range s.parts {
+		if cerr := p.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+		delete(s.parts, k)
+	}
+	if s.replay != nil {
+		reportUnknown(s.replay)
+	}
+	return err
+}
+
+// Abort discards the open partitions and the staged files of those already
+// closed, so a failed conversion leaves none of its partitions behind.
+func (s *splitSink) Abort() {
+	for k, p := range s.parts {
+		p.Abort()
+		delete(s.parts, k)
+	}
+	discardOutputs(s.closed)
+}
+
+// fileSafe maps characters that are awkward in filenames (spaces in option
+// symbols, '/' in spreads) to '-'.
+func fileSafe(sym string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'A' && r <= 'Z', r >= 'a' && r <= 'z', r >= '0' && r <= '9', r == '.':
+			return r
+		}
+		return '-'
+	}, sym)
+}
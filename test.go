@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
 	"sort"
 	"strings"
 	"sync"
@@ -21,6 +22,15 @@ func runTest() {
 		return
 	}
 
+	if err := Instruments.Load(CatalogFile); err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+
+	events, err := LoadAllEventLogs()
+	if err != nil {
+		fmt.Printf("[warn] %v\n", err)
+	}
+
 	portfolio := &Portfolio{Assets: make(map[string]*SymbolReport)}
 
 	// Sort files by size (largest first)
@@ -38,8 +48,10 @@ func runTest() {
 	sort.Slice(jobs, func(i, j int) bool { return jobs[i].size > jobs[j].size })
 
 	var wg sync.WaitGroup
-	// Use a smaller concurrency limit for memory-heavy backtest.
-	sem := make(chan struct{}, TestMaxParallel)
+	// TBBO files stream chunk by chunk; the rest are loaded whole, under a
+	// smaller concurrency limit.
+	sem := make(chan struct{}, TestStreamParallel)
+	memSem := make(chan struct{}, TestMaxParallel)
 
 	for _, j := range jobs {
 		wg.Add(1)
@@ -48,23 +60,29 @@ func runTest() {
 			defer wg.Done()
 			defer func() { <-sem }()
 
-			base := filepath.Base(path)
-			parts := strings.Split(base, "_")
-			sym := "UNKNOWN"
-			if len(parts) > 0 {
-				sym = strings.ToUpper(strings.TrimSuffix(parts[0], ".quantdev"))
+			if streamed, err := testFileStream(path, events, portfolio); streamed || err != nil {
+				if err != nil {
+					fmt.Printf("\n[err] %s: %v\n", path, err)
+				} else {
+					fmt.Print(".")
+				}
+				return
 			}
 
-			config := GetAssetConfig(sym)
-			cols, err := LoadQuantDev(path)
+			memSem <- struct{}{}
+			defer func() { <-memSem }()
+			cols, err := LoadQuantDevTBBO(path, TBBOQuery{})
 			if err != nil {
 				fmt.Printf("\n[err] %s: %v\n", path, err)
 				return
 			}
 			defer TBBOPool.Put(cols)
+			ids := instrumentIDs(cols)
+			sym, config := resolveAsset(path, ids, minTsEvent(cols))
 
 			local := NewSymbolReport(sym)
-			RunStrategy(cols, config, local)
+			halts := HaltWindows(events, instrumentKeys(cols)...)
+			RunStrategy(cols, config, halts, local)
 			portfolio.MergeLocal(local)
 			fmt.Print(".")
 		}(j.path)
@@ -77,6 +95,154 @@ func runTest() {
 	fmt.Printf("[sys] Execution Time: %s\n", time.Since(start))
 }
 
+// testFileStream runs the strategy over a TBBO file without loading it
+// whole. It reports false (and does nothing) for files it cannot stream:
+// other layouts and files without zone maps to name their instruments.
+func testFileStream(path string, events []MarketEvent, portfolio *Portfolio) (bool, error) {
+	r, err := OpenChunkReader(path, TBBOQuery{})
+	if err != nil {
+		return false, nil
+	}
+	defer r.Close()
+	ids, ok := r.Index.Instruments()
+	if !ok {
+		return false, nil
+	}
+	sym, config := resolveAsset(path, ids, r.Index.MinTsEvent())
+	keys, err := streamInstrumentKeys(path)
+	if err != nil {
+		return true, err
+	}
+
+	local := NewSymbolReport(sym)
+	halts := HaltWindows(events, keys...)
+	RunStrategyStream(r.All(), config, halts, local)
+	if err := r.Err(); err != nil {
+		return true, err
+	}
+	portfolio.MergeLocal(local)
+	return true, nil
+}
+
+// instrumentIDs lists the distinct instrument ids of a file, ascending like
+// QuantDevIndex.Instruments, so loaded and streamed files resolve the same
+// asset. Files are usually single-instrument, so this stays tiny.
+func instrumentIDs(cols *TBBOColumns) []uint32 {
+	var ids []uint32
+	last := uint32(0)
+	for i, id := range cols.InstrumentID[:cols.Count] {
+		if i > 0 && id == last {
+			continue
+		}
+		last = id
+		if !slices.Contains(ids, id) {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// instrumentKeys lists the distinct (publisher, instrument) pairs of a file,
+// which HaltWindows matches status events against.
+func instrumentKeys(cols *TBBOColumns) []InstrumentKey {
+	var keys []InstrumentKey
+	var last InstrumentKey
+	for i := range cols.Count {
+		k := InstrumentKey{cols.PublisherID[i], cols.InstrumentID[i]}
+		if i > 0 && k == last {
+			continue
+		}
+		last = k
+		if !slices.Contains(keys, k) {
+			keys = append(keys, k)
+		}
+	}
+	return keys
+}
+
+// streamInstrumentKeys is instrumentKeys for a file read chunk by chunk;
+// it reads only the publisher and instrument columns.
+func streamInstrumentKeys(path string) ([]InstrumentKey, error) {
+	r, err := OpenChunkReader(path, TBBOQuery{Columns: ColPublisherID | ColInstrumentID})
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var keys []InstrumentKey
+	for _, c := range r.All() {
+		for _, k := range instrumentKeys(c) {
+			if !slices.Contains(keys, k) {
+				keys = append(keys, k)
+			}
+		}
+	}
+	return keys, r.Err()
+}
+
+// minTsEvent is the earliest ts_event of cols (0 when empty), matching
+// QuantDevIndex.MinTsEvent for the time resolveAsset resolves at.
+func minTsEvent(cols *TBBOColumns) uint64 {
+	if cols.Count == 0 {
+		return 0
+	}
+	return slices.Min(cols.TsEvent[:cols.Count])
+}
+
+// resolveAsset names the product behind a file and builds its AssetConfig.
+// The instrument catalog (the file's first instrument id, resolved at ts)
+// wins, so tick value comes from the definition; otherwise the symbol falls
+// back to resolveSymbol and AssetConfigs.
+func resolveAsset(path string, ids []uint32, ts uint64) (string, AssetConfig) {
+	if len(ids) > 0 {
+		if in, ok := Instruments.Resolve(ids[0], ts); ok && in.Asset != "" {
+			return in.Asset, in.AssetConfig()
+		}
+	}
+	sym := resolveSymbol(path)
+	return sym, GetAssetConfig(sym)
+}
+
+// resolveSymbol prefers the asset in the file's metadata section, then the
+// DBN symbols recorded at conversion time, and only falls back to the
+// filename prefix (e.g. "mes_2024.quantdev" -> "MES") for files converted
+// before either existed.
+func resolveSymbol(path string) string {
+	if m, err := ReadQuantDevMeta(path); err == nil && m[MetaAsset] != "" {
+		return m[MetaAsset]
+	}
+	if src, err := ReadQuantDevSource(path); err == nil && src != nil && len(src.Symbols) > 0 {
+		return symbolRoot(src.Symbols[0])
+	}
+
+	base := filepath.Base(path)
+	parts := strings.Split(base, "_")
+	sym := "UNKNOWN"
+	if len(parts) > 0 {
+		sym = strings.ToUpper(strings.TrimSuffix(parts[0], ".quantdev"))
+	}
+	return sym
+}
+
+// symbolRoot reduces a Databento symbol to the product root used as the
+// AssetConfigs key: "MES.FUT" / "MES.c.0" -> "MES", "MESZ4" / "MESZ24" -> "MES".
+func symbolRoot(sym string) string {
+	sym = strings.ToUpper(sym)
+	if i := strings.IndexByte(sym, '.'); i > 0 {
+		return sym[:i]
+	}
+
+	// Futures raw symbol: root + month code + 1-2 digit year.
+	j := len(sym)
+	for j > 0 && j > len(sym)-2 && sym[j-1] >= '0' && sym[j-1] <= '9' {
+		j--
+	}
+	if j < len(sym) && j >= 2 && strings.IndexByte("FGHJKMNQUVXZ", sym[j-1]) >= 0 {
+		return sym[:j-1]
+	}
+	return sym
+}
+
 func printPortfolio(p *Portfolio) {
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, 1, ' ', 0)
 
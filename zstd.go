@@ -0,0 +1,1181 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// -----------------------------------------------------------------------------
+// Streaming Zstandard decoder (RFC 8878), stdlib only.
+//
+// Covers everything Databento's .dbn.zst output uses: concatenated and
+// skippable frames, raw / RLE / compressed blocks, Huffman literals (1 and 4
+// streams, treeless reuse), FSE sequence tables (predefined, RLE, compressed,
+// repeat) and the optional XXH64 content checksum. Dictionaries are rejected.
+//
+// Output is produced one block (<= 128 KiB) at a time into a sliding history
+// buffer that retains one window of back-references, so memory is bounded by
+// the frame's window size rather than the file size.
+// -----------------------------------------------------------------------------
+
+const (
+	zstdFrameMagic     = 0xFD2FB528
+	zstdSkippableMagic = 0x184D2A50 // low nibble is user-defined
+	zstdMaxBlockSize   = 128 << 10
+	zstdMaxWindowSize  = 1 << 30
+	zstdEagerWindow    = 64 << 20 // windows up to this size get a 2x history buffer up front
+	zstdCopySlack      = 32       // spare capacity for over-copying short literals and matches
+
+	zstdMaxLLSymbol = 35
+	zstdMaxMLSymbol = 52
+	zstdMaxOFSymbol = 31
+	zstdMaxLLLog    = 9
+	zstdMaxMLLog    = 9
+	zstdMaxOFLog    = 8
+	zstdMaxHuffBits = 11
+)
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// ZstdReader decompresses a zstd stream. It implements io.Reader.
+type ZstdReader struct {
+	br  *bufio.Reader
+	err error
+
+	// Frame state
+	inFrame    bool
+	lastBlock  bool
+	checksum   bool
+	windowSize int
+	hash       xxh64
+
+	// hist holds decoded bytes; hist[outPos:] has not been returned yet.
+	hist   []byte
+	outPos int
+
+	// Entropy state carried between blocks of a frame
+	reps                [3]int
+	huf                 huffTable
+	llTab, ofTab, mlTab *fseTable
+	llBuf, ofBuf, mlBuf fseTable
+
+	block    []byte
+	literals []byte
+}
+
+func NewZstdReader(r io.Reader) *ZstdReader {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReaderSize(r, 256*1024)
+	}
+	return &ZstdReader{br: br}
+}
+
+func (z *ZstdReader) Read(p []byte) (int, error) {
+	for z.outPos == len(z.hist) {
+		if z.err != nil {
+			return 0, z.err
+		}
+		z.err = z.next()
+	}
+	n := copy(p, z.hist[z.outPos:])
+	z.outPos += n
+	return n, nil
+}
+
+// next advances the frame state machine by one step: a frame header, a block,
+// or a frame trailer. Returns io.EOF only on a clean frame boundary.
+func (z *ZstdReader) next() error {
+	if !z.inFrame {
+		return z.readFrameHeader()
+	}
+	if !z.lastBlock {
+		return z.decodeBlock()
+	}
+
+	z.inFrame = false
+	if z.checksum {
+		var sum [4]byte
+		if _, err := io.ReadFull(z.br, sum[:]); err != nil {
+			return fmt.Errorf("zstd: reading checksum: %w", noEOF(err))
+		}
+		if binary.LittleEndian.Uint32(sum[:]) != uint32(z.hash.Sum64()) {
+			return errors.New("zstd: content checksum mismatch")
+		}
+	}
+	return nil
+}
+
+func (z *ZstdReader) readFrameHeader() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(z.br, magic[:]); err != nil {
+		if err == io.EOF {
+			return io.EOF
+		}
+		return fmt.Errorf("zstd: reading frame magic: %w", noEOF(err))
+	}
+
+	m := binary.LittleEndian.Uint32(magic[:])
+	if m&^0xF == zstdSkippableMagic {
+		if _, err := io.ReadFull(z.br, magic[:]); err != nil {
+			return fmt.Errorf("zstd: skippable frame: %w", noEOF(err))
+		}
+		size := int(binary.LittleEndian.Uint32(magic[:]))
+		if _, err := z.br.Discard(size); err != nil {
+			return fmt.Errorf("zstd: skippable frame: %w", noEOF(err))
+		}
+		return nil
+	}
+	if m != zstdFrameMagic {
+		return fmt.Errorf("zstd: bad frame magic %#08x", m)
+	}
+
+	desc, err := z.br.ReadByte()
+	if err != nil {
+		return fmt.Errorf("zstd: frame header: %w", noEOF(err))
+	}
+	fcsFlag := desc >> 6
+	singleSegment := desc&0x20 != 0
+	if desc&0x08 != 0 {
+		return errors.New("zstd: reserved frame header bit set")
+	}
+	z.checksum = desc&0x04 != 0
+	dictIDSize := [4]int{0, 1, 2, 4}[desc&3]
+
+	fcsSize := [4]int{0, 2, 4, 8}[fcsFlag]
+	if fcsFlag == 0 && singleSegment {
+		fcsSize = 1
+	}
+
+	var hdr [14]byte
+	n := dictIDSize + fcsSize
+	if !singleSegment {
+		n++
+	}
+	if _, err := io.ReadFull(z.br, hdr[:n]); err != nil {
+		return fmt.Errorf("zstd: frame header: %w", noEOF(err))
+	}
+	p := hdr[:n]
+
+	windowSize := 0
+	if !singleSegment {
+		exp, mant := int(p[0]>>3), int(p[0]&7)
+		base := 1 << (10 + exp)
+		windowSize = base + (base/8)*mant
+		p = p[1:]
+	}
+
+	var dictID uint64
+	for i := dictIDSize - 1; i >= 0; i-- {
+		dictID = dictID<<8 | uint64(p[i])
+	}
+	if dictID != 0 {
+		return fmt.Errorf("zstd: dictionary %d required (not supported)", dictID)
+	}
+	p = p[dictIDSize:]
+
+	if singleSegment {
+		var fcs uint64
+		for i := fcsSize - 1; i >= 0; i-- {
+			fcs = fcs<<8 | uint64(p[i])
+		}
+		if fcsSize == 2 {
+			fcs += 256
+		}
+		if fcs > zstdMaxWindowSize {
+			return fmt.Errorf("zstd: frame content size %d too large for single-segment decode", fcs)
+		}
+		windowSize = int(fcs)
+	}
+	if windowSize > zstdMaxWindowSize {
+		return fmt.Errorf("zstd: window size %d exceeds limit", windowSize)
+	}
+
+	z.inFrame = true
+	z.lastBlock = false
+	z.windowSize = windowSize
+	z.reps = [3]int{1, 4, 8}
+	z.huf.valid = false
+	z.llTab, z.ofTab, z.mlTab = nil, nil, nil
+	z.hash.reset()
+
+	// Frames are independent: no back-references across them.
+	z.hist = z.hist[:0]
+	z.outPos = 0
+	return nil
+}
+
+func (z *ZstdReader) decodeBlock() error {
+	var hdr [3]byte
+	if _, err := io.ReadFull(z.br, hdr[:]); err != nil {
+		return fmt.Errorf("zstd: block header: %w", noEOF(err))
+	}
+	bh := uint32(hdr[0]) | uint32(hdr[1])<<8 | uint32(hdr[2])<<16
+	z.lastBlock = bh&1 != 0
+	size := int(bh >> 3)
+	if size > zstdMaxBlockSize {
+		return fmt.Errorf("zstd: block size %d exceeds limit", size)
+	}
+
+	z.reserve(zstdMaxBlockSize + zstdCopySlack)
+	start := len(z.hist)
+
+	switch (bh >> 1) & 3 {
+	case 0: // Raw
+		z.hist = z.hist[:start+size]
+		if _, err := io.ReadFull(z.br, z.hist[start:]); err != nil {
+			z.hist = z.hist[:start]
+			return fmt.Errorf("zstd: raw block: %w", noEOF(err))
+		}
+	case 1: // RLE
+		b, err := z.br.ReadByte()
+		if err != nil {
+			return fmt.Errorf("zstd: rle block: %w", noEOF(err))
+		}
+		z.hist = z.hist[:start+size]
+		fill := z.hist[start:]
+		for i := range fill {
+			fill[i] = b
+		}
+	case 2: // Compressed
+		z.block = resize(z.block, size)
+		if _, err := io.ReadFull(z.br, z.block); err != nil {
+			return fmt.Errorf("zstd: compressed block: %w", noEOF(err))
+		}
+		if err := z.decodeCompressed(z.block); err != nil {
+			z.hist = z.hist[:start]
+			return err
+		}
+	default:
+		return errors.New("zstd: reserved block type")
+	}
+
+	if z.checksum {
+		z.hash.write(z.hist[start:])
+	}
+	z.outPos = start
+	return nil
+}
+
+// reserve guarantees room for n more bytes of output without reallocating,
+// first dropping history older than one window. Only called when every
+// decoded byte has already been handed to the caller.
+func (z *ZstdReader) reserve(n int) {
+	if len(z.hist)+n <= cap(z.hist) {
+		return
+	}
+	if excess := len(z.hist) - z.windowSize; excess > 0 {
+		m := copy(z.hist, z.hist[excess:])
+		z.hist = z.hist[:m]
+		z.outPos = m
+		if len(z.hist)+n <= cap(z.hist) {
+			return
+		}
+	}
+
+	newCap := 2*cap(z.hist) + n
+	if z.windowSize <= zstdEagerWindow && newCap < 2*z.windowSize+n {
+		newCap = 2*z.windowSize + n
+	}
+	grown := make([]byte, len(z.hist), newCap)
+	copy(grown, z.hist)
+	z.hist = grown
+}
+
+func (z *ZstdReader) decodeCompressed(src []byte) error {
+	lits, n, err := z.decodeLiterals(src)
+	if err != nil {
+		return err
+	}
+	return z.decodeSequences(src[n:], lits)
+}
+
+// -----------------------------------------------------------------------------
+// Literals section
+// -----------------------------------------------------------------------------
+
+func (z *ZstdReader) decodeLiterals(src []byte) ([]byte, int, error) {
+	if len(src) < 1 {
+		return nil, 0, errZstdCorrupt
+	}
+	b0 := src[0]
+	litType := b0 & 3
+	sizeFormat := (b0 >> 2) & 3
+
+	if litType < 2 {
+		var size, hl int
+		switch sizeFormat {
+		case 0, 2:
+			size, hl = int(b0>>3), 1
+		case 1:
+			if len(src) < 2 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, hl = int(b0>>4)|int(src[1])<<4, 2
+		case 3:
+			if len(src) < 3 {
+				return nil, 0, errZstdCorrupt
+			}
+			size, hl = int(b0>>4)|int(src[1])<<4|int(src[2])<<12, 3
+		}
+		if size > zstdMaxBlockSize {
+			return nil, 0, errZstdCorrupt
+		}
+
+		if litType == 0 { // Raw: literals live in the block buffer
+			if hl+size > len(src) {
+				return nil, 0, errZstdCorrupt
+			}
+			return src[hl : hl+size], hl + size, nil
+		}
+
+		// RLE
+		if hl+1 > len(src) {
+			return nil, 0, errZstdCorrupt
+		}
+		z.literals = resize(z.literals, size)
+		for i := range z.literals {
+			z.literals[i] = src[hl]
+		}
+		return z.literals, hl + 1, nil
+	}
+
+	// Huffman compressed (2) or treeless (3)
+	var regen, comp, hl int
+	streams := 4
+	switch sizeFormat {
+	case 0:
+		streams = 1
+		fallthrough
+	case 1:
+		if len(src) < 3 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		regen, comp, hl = int(v>>4)&0x3FF, int(v>>14)&0x3FF, 3
+	case 2:
+		if len(src) < 4 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := binary.LittleEndian.Uint32(src)
+		regen, comp, hl = int(v>>4)&0x3FFF, int(v>>18)&0x3FFF, 4
+	case 3:
+		if len(src) < 5 {
+			return nil, 0, errZstdCorrupt
+		}
+		v := uint64(binary.LittleEndian.Uint32(src)) | uint64(src[4])<<32
+		regen, comp, hl = int(v>>4)&0x3FFFF, int(v>>22)&0x3FFFF, 5
+	}
+	if regen > zstdMaxBlockSize || hl+comp > len(src) {
+		return nil, 0, errZstdCorrupt
+	}
+	data := src[hl : hl+comp]
+
+	if litType == 2 {
+		n, err := z.huf.readTable(data)
+		if err != nil {
+			return nil, 0, err
+		}
+		data = data[n:]
+	} else if !z.huf.valid {
+		return nil, 0, errors.New("zstd: treeless literals without a previous Huffman table")
+	}
+
+	z.literals = resize(z.literals, regen)
+	if streams == 1 {
+		if err := z.huf.decodeStream(z.literals, data); err != nil {
+			return nil, 0, err
+		}
+		return z.literals, hl + comp, nil
+	}
+
+	if len(data) < 6 {
+		return nil, 0, errZstdCorrupt
+	}
+	s1 := int(binary.LittleEndian.Uint16(data[0:2]))
+	s2 := int(binary.LittleEndian.Uint16(data[2:4]))
+	s3 := int(binary.LittleEndian.Uint16(data[4:6]))
+	data = data[6:]
+	if s1+s2+s3 > len(data) {
+		return nil, 0, errZstdCorrupt
+	}
+	seg := (regen + 3) / 4
+	if 3*seg > regen {
+		return nil, 0, errZstdCorrupt
+	}
+
+	out := z.literals
+	streamsIn := [4][]byte{
+		data[:s1],
+		data[s1 : s1+s2],
+		data[s1+s2 : s1+s2+s3],
+		data[s1+s2+s3:],
+	}
+	for i, in := range streamsIn {
+		end := (i + 1) * seg
+		if i == 3 {
+			end = regen
+		}
+		if err := z.huf.decodeStream(out[i*seg:end], in); err != nil {
+			return nil, 0, err
+		}
+	}
+	return z.literals, hl + comp, nil
+}
+
+// -----------------------------------------------------------------------------
+// Sequences section
+// -----------------------------------------------------------------------------
+
+func (z *ZstdReader) decodeSequences(src []byte, lits []byte) error {
+	if len(src) < 1 {
+		return errZstdCorrupt
+	}
+	blockStart := len(z.hist)
+
+	nSeq := int(src[0])
+	p := 1
+	switch {
+	case nSeq == 0:
+		z.hist = append(z.hist, lits...)
+		return nil
+	case nSeq < 128:
+	case nSeq < 255:
+		if len(src) < 2 {
+			return errZstdCorrupt
+		}
+		nSeq = (nSeq-128)<<8 + int(src[1])
+		p = 2
+	default:
+		if len(src) < 3 {
+			return errZstdCorrupt
+		}
+		nSeq = int(src[1]) + int(src[2])<<8 + 0x7F00
+		p = 3
+	}
+
+	if p >= len(src) {
+		return errZstdCorrupt
+	}
+	modes := src[p]
+	p++
+	if modes&3 != 0 {
+		return errors.New("zstd: reserved sequence mode bits set")
+	}
+
+	var err error
+	var n int
+	if z.llTab, n, err = selectFSETable(modes>>6, src[p:], z.llTab, &z.llBuf, &fsePredefLL, zstdMaxLLSymbol, zstdMaxLLLog); err != nil {
+		return err
+	}
+	p += n
+	if z.ofTab, n, err = selectFSETable((modes>>4)&3, src[p:], z.ofTab, &z.ofBuf, &fsePredefOF, zstdMaxOFSymbol, zstdMaxOFLog); err != nil {
+		return err
+	}
+	p += n
+	if z.mlTab, n, err = selectFSETable((modes>>2)&3, src[p:], z.mlTab, &z.mlBuf, &fsePredefML, zstdMaxMLSymbol, zstdMaxMLLog); err != nil {
+		return err
+	}
+	p += n
+
+	var br bitReaderRev
+	if err := br.init(src[p:]); err != nil {
+		return err
+	}
+	llDT, ofDT, mlDT := z.llTab.dt[:1<<z.llTab.log], z.ofTab.dt[:1<<z.ofTab.log], z.mlTab.dt[:1<<z.mlTab.log]
+	llState := br.getBits(z.llTab.log)
+	ofState := br.getBits(z.ofTab.log)
+	mlState := br.getBits(z.mlTab.log)
+
+	h := z.hist
+	reps := z.reps
+
+	for i := 0; i < nSeq; i++ {
+		llE, ofE, mlE := llDT[llState], ofDT[ofState], mlDT[mlState]
+
+		// Offset bits first (up to 31), then match and literal lengths
+		// (up to 16 each); one refill before each group keeps reads in range.
+		br.fill()
+		ofCode := ofE.sym
+		ofVal := 1<<ofCode + int(br.getBitsFast(ofCode))
+		br.fill()
+		mlCode, llCode := mlE.sym, llE.sym
+		ml := int(zstdMLBase[mlCode]) + int(br.getBitsFast(zstdMLBits[mlCode]))
+		ll := int(zstdLLBase[llCode]) + int(br.getBitsFast(zstdLLBits[llCode]))
+
+		// Repeat-offset resolution (RFC 8878 3.1.1.5)
+		var offset int
+		if ofVal > 3 {
+			offset = ofVal - 3
+			reps[2], reps[1], reps[0] = reps[1], reps[0], offset
+		} else {
+			idx := ofVal - 1
+			if ll == 0 {
+				idx++
+			}
+			switch idx {
+			case 0:
+				offset = reps[0]
+			case 1:
+				offset = reps[1]
+				reps[1], reps[0] = reps[0], offset
+			case 2:
+				offset = reps[2]
+				reps[2], reps[1], reps[0] = reps[1], reps[0], offset
+			default:
+				offset = reps[0] - 1
+				reps[2], reps[1], reps[0] = reps[1], reps[0], offset
+			}
+		}
+
+		if i != nSeq-1 {
+			br.fill()
+			llState = llE.base + br.getBitsFast(llE.nbBits)
+			mlState = mlE.base + br.getBitsFast(mlE.nbBits)
+			ofState = ofE.base + br.getBitsFast(ofE.nbBits)
+		}
+
+		// Execute: literals, then match.
+		if ll > len(lits) || len(h)-blockStart+ll+ml > zstdMaxBlockSize {
+			z.hist = h
+			return errZstdCorrupt
+		}
+		n := len(h)
+		if ll <= 16 && len(lits) >= 16 {
+			*(*[16]byte)(h[n : n+16]) = *(*[16]byte)(lits)
+		} else {
+			copy(h[n:n+ll], lits[:ll])
+		}
+		h = h[:n+ll]
+		lits = lits[ll:]
+
+		if offset <= 0 || offset > len(h) {
+			z.hist = h
+			return fmt.Errorf("zstd: match offset %d beyond history (%d bytes)", offset, len(h))
+		}
+		n = len(h)
+		if offset >= 16 {
+			// Short-copy fast path: 16-byte moves may overshoot into the
+			// reserved slack; the overshoot is overwritten by later output.
+			for d, s := n, n-offset; d < n+ml; d, s = d+16, s+16 {
+				*(*[16]byte)(h[d : d+16]) = *(*[16]byte)(h[s : s+16])
+			}
+			h = h[:n+ml]
+		} else if offset >= ml {
+			h = h[:n+ml]
+			copy(h[n:], h[n-offset:n-offset+ml])
+		} else {
+			h = h[:n+ml]
+			// Overlapping match: each pass doubles the repeated span.
+			for src, dst := n-offset, n; dst < n+ml; {
+				dst += copy(h[dst:n+ml], h[src:dst])
+			}
+		}
+	}
+
+	z.hist = h
+	z.reps = reps
+	if !br.finished() {
+		return errors.New("zstd: sequence bitstream not fully consumed")
+	}
+	if len(h)-blockStart+len(lits) > zstdMaxBlockSize {
+		return errZstdCorrupt
+	}
+	z.hist = append(z.hist, lits...)
+	return nil
+}
+
+// Literal-length and match-length code tables (RFC 8878 3.1.1.3.2.1.1).
+var (
+	zstdLLBase = [zstdMaxLLSymbol + 1]uint32{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	zstdLLBits = [zstdMaxLLSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	zstdMLBase = [zstdMaxMLSymbol + 1]uint32{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	zstdMLBits = [zstdMaxMLSymbol + 1]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// -----------------------------------------------------------------------------
+// FSE tables
+// -----------------------------------------------------------------------------
+
+type fseEntry struct {
+	sym    uint8
+	nbBits uint8
+	base   uint32
+}
+
+type fseTable struct {
+	log uint8
+	dt  [1 << zstdMaxLLLog]fseEntry
+}
+
+var (
+	fsePredefLL = mustPredefFSE(6, []int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	})
+	fsePredefML = mustPredefFSE(6, []int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	})
+	fsePredefOF = mustPredefFSE(5, []int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	})
+)
+
+func mustPredefFSE(log uint8, norm []int16) fseTable {
+	var t fseTable
+	if err := t.build(norm, log); err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// selectFSETable resolves one of the three sequence tables for a block
+// according to its compression mode, returning the table and bytes consumed.
+func selectFSETable(mode uint8, src []byte, prev, buf, predef *fseTable, maxSym, maxLog int) (*fseTable, int, error) {
+	switch mode {
+	case 0: // Predefined
+		return predef, 0, nil
+	case 1: // RLE
+		if len(src) < 1 || int(src[0]) > maxSym {
+			return nil, 0, errZstdCorrupt
+		}
+		buf.log = 0
+		buf.dt[0] = fseEntry{sym: src[0]}
+		return buf, 1, nil
+	case 2: // FSE compressed
+		n, err := buf.readNCount(src, maxSym, maxLog)
+		if err != nil {
+			return nil, 0, err
+		}
+		return buf, n, nil
+	default: // Repeat
+		if prev == nil {
+			return nil, 0, errors.New("zstd: repeat table mode without a previous table")
+		}
+		return prev, 0, nil
+	}
+}
+
+// readNCount parses an FSE table description (RFC 8878 4.1.1) and builds the
+// decoding table. Returns the number of bytes consumed.
+func (t *fseTable) readNCount(src []byte, maxSym, maxLog int) (int, error) {
+	if len(src) < 1 {
+		return 0, errZstdCorrupt
+	}
+	peek := func(pos int) uint32 {
+		var v uint64
+		for i, b := 0, pos>>3; i < 8 && b+i < len(src); i++ {
+			v |= uint64(src[b+i]) << (8 * i)
+		}
+		return uint32(v >> (pos & 7))
+	}
+
+	log := int(src[0]&0xF) + 5
+	if log > maxLog {
+		return 0, fmt.Errorf("zstd: FSE accuracy log %d exceeds %d", log, maxLog)
+	}
+
+	var norm [256]int16
+	bitPos := 4
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbBits := log + 1
+	sym := 0
+	prev0 := false
+
+	for remaining > 1 && sym <= maxSym {
+		if prev0 {
+			n0 := sym
+			for {
+				r := int(peek(bitPos) & 3)
+				bitPos += 2
+				n0 += r
+				if r != 3 {
+					break
+				}
+				if bitPos > len(src)*8 {
+					return 0, errZstdCorrupt
+				}
+			}
+			if n0 > maxSym {
+				return 0, errZstdCorrupt
+			}
+			sym = n0
+		}
+
+		maxV := 2*threshold - 1 - remaining
+		v := int(peek(bitPos))
+		var count int
+		if v&(threshold-1) < maxV {
+			count = v & (threshold - 1)
+			bitPos += nbBits - 1
+		} else {
+			count = v & (2*threshold - 1)
+			if count >= threshold {
+				count -= maxV
+			}
+			bitPos += nbBits
+		}
+		count--
+
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		if remaining < 1 {
+			return 0, errZstdCorrupt
+		}
+		norm[sym] = int16(count)
+		sym++
+		prev0 = count == 0
+
+		for remaining < threshold {
+			nbBits--
+			threshold >>= 1
+		}
+	}
+
+	used := (bitPos + 7) >> 3
+	if remaining != 1 || used > len(src) {
+		return 0, errZstdCorrupt
+	}
+	if err := t.build(norm[:sym], uint8(log)); err != nil {
+		return 0, err
+	}
+	return used, nil
+}
+
+func (t *fseTable) build(norm []int16, log uint8) error {
+	size := 1 << log
+	dt := t.dt[:size]
+	high := size - 1
+
+	var next [256]uint32
+	for s, c := range norm {
+		if c == -1 {
+			dt[high].sym = uint8(s)
+			high--
+			next[s] = 1
+		} else {
+			next[s] = uint32(c)
+		}
+	}
+
+	step := size>>1 + size>>3 + 3
+	mask := size - 1
+	pos := 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			dt[pos].sym = uint8(s)
+			pos = (pos + step) & mask
+			for pos > high {
+				pos = (pos + step) & mask
+			}
+		}
+	}
+	if pos != 0 {
+		return errZstdCorrupt
+	}
+
+	for u := range dt {
+		s := dt[u].sym
+		ns := next[s]
+		next[s]++
+		nb := uint32(log) - uint32(bits.Len32(ns)-1)
+		dt[u].nbBits = uint8(nb)
+		dt[u].base = ns<<nb - uint32(size)
+	}
+	t.log = log
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Huffman literals
+// -----------------------------------------------------------------------------
+
+type huffTable struct {
+	valid   bool
+	maxBits uint8
+	dt      [1 << zstdMaxHuffBits]uint16 // symbol | nbBits<<8
+}
+
+// readTable parses a Huffman tree description (RFC 8878 4.2.1) and returns
+// the number of bytes consumed.
+func (h *huffTable) readTable(src []byte) (int, error) {
+	h.valid = false
+	if len(src) < 1 {
+		return 0, errZstdCorrupt
+	}
+
+	var weights [256]uint8
+	var nw, used int
+	hb := int(src[0])
+	if hb < 128 {
+		if 1+hb > len(src) {
+			return 0, errZstdCorrupt
+		}
+		var err error
+		if nw, err = decodeHuffWeights(src[1:1+hb], &weights); err != nil {
+			return 0, err
+		}
+		used = 1 + hb
+	} else {
+		nw = hb - 127
+		nb := (nw + 1) / 2
+		if 1+nb > len(src) {
+			return 0, errZstdCorrupt
+		}
+		for i := 0; i < nw; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				weights[i] = b >> 4
+			} else {
+				weights[i] = b & 0xF
+			}
+		}
+		used = 1 + nb
+	}
+
+	// The last symbol's weight is implied by completing the power of two.
+	total := 0
+	for _, w := range weights[:nw] {
+		if w > zstdMaxHuffBits {
+			return 0, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || nw >= 256 {
+		return 0, errZstdCorrupt
+	}
+	maxBits := bits.Len(uint(total))
+	if maxBits > zstdMaxHuffBits {
+		return 0, errZstdCorrupt
+	}
+	rest := 1<<maxBits - total
+	if rest&(rest-1) != 0 {
+		return 0, errZstdCorrupt
+	}
+	weights[nw] = uint8(bits.Len(uint(rest)))
+	nw++
+
+	var rankStart [zstdMaxHuffBits + 2]int
+	for _, w := range weights[:nw] {
+		rankStart[w]++
+	}
+	pos := 0
+	for w := 1; w <= maxBits; w++ {
+		cnt := rankStart[w]
+		rankStart[w] = pos
+		pos += cnt << (w - 1)
+	}
+	for s, w := range weights[:nw] {
+		if w == 0 {
+			continue
+		}
+		entry := uint16(s) | uint16(maxBits+1-int(w))<<8
+		start := rankStart[w]
+		length := 1 << (w - 1)
+		for i := start; i < start+length; i++ {
+			h.dt[i] = entry
+		}
+		rankStart[w] += length
+	}
+
+	h.maxBits = uint8(maxBits)
+	h.valid = true
+	return used, nil
+}
+
+// decodeHuffWeights decodes FSE-compressed Huffman weights: two interleaved
+// states over one backward bitstream, stopping when the stream overflows.
+func decodeHuffWeights(src []byte, w *[256]uint8) (int, error) {
+	var t fseTable
+	n, err := t.readNCount(src, 15, 6)
+	if err != nil {
+		return 0, err
+	}
+	var br bitReaderRev
+	if err := br.init(src[n:]); err != nil {
+		return 0, err
+	}
+	dt := t.dt[:1<<t.log]
+	s1 := br.getBits(t.log)
+	s2 := br.getBits(t.log)
+
+	nw := 0
+	for {
+		if nw >= 254 {
+			return 0, errZstdCorrupt
+		}
+		e := dt[s1]
+		w[nw] = e.sym
+		nw++
+		s1 = e.base + br.getBits(e.nbBits)
+		if br.overflow() {
+			w[nw] = dt[s2].sym
+			nw++
+			break
+		}
+
+		e = dt[s2]
+		w[nw] = e.sym
+		nw++
+		s2 = e.base + br.getBits(e.nbBits)
+		if br.overflow() {
+			w[nw] = dt[s1].sym
+			nw++
+			break
+		}
+	}
+	return nw, nil
+}
+
+func (h *huffTable) decodeStream(dst, src []byte) error {
+	var br bitReaderRev
+	if err := br.init(src); err != nil {
+		return err
+	}
+	// A refill leaves >= 33 bits loaded: enough for three maximum-length codes.
+	dt := &h.dt
+	shift := 64 - uint(h.maxBits)
+	i := 0
+	for ; i+3 <= len(dst); i += 3 {
+		br.fill()
+		e0 := dt[(br.value<<br.bitsRead)>>shift]
+		br.bitsRead += uint(e0 >> 8)
+		e1 := dt[(br.value<<br.bitsRead)>>shift]
+		br.bitsRead += uint(e1 >> 8)
+		e2 := dt[(br.value<<br.bitsRead)>>shift]
+		br.bitsRead += uint(e2 >> 8)
+		dst[i], dst[i+1], dst[i+2] = byte(e0), byte(e1), byte(e2)
+	}
+	for ; i < len(dst); i++ {
+		br.fill()
+		e := dt[(br.value<<br.bitsRead)>>shift]
+		br.bitsRead += uint(e >> 8)
+		dst[i] = byte(e)
+	}
+	if !br.finished() {
+		return errors.New("zstd: Huffman stream not fully consumed")
+	}
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+// Backward bit reader shared by Huffman and FSE streams.
+// Bits are consumed from the end of the buffer towards the start.
+// -----------------------------------------------------------------------------
+
+type bitReaderRev struct {
+	in       []byte
+	off      int    // in[:off] not yet loaded
+	value    uint64 // loaded bits, unread ones in the low (64-bitsRead) bits
+	bitsRead uint
+}
+
+func (b *bitReaderRev) init(in []byte) error {
+	if len(in) == 0 || in[len(in)-1] == 0 {
+		return errZstdCorrupt
+	}
+	last := in[len(in)-1]
+	b.in = in
+	b.off = len(in)
+	b.value = 0
+	b.bitsRead = 64
+	b.fill()
+	// Skip the zero padding and the end-of-stream marker bit.
+	b.bitsRead += uint(8-bits.Len8(last)) + 1
+	return nil
+}
+
+func (b *bitReaderRev) fill() {
+	if b.bitsRead < 32 {
+		return
+	}
+	if b.off >= 4 {
+		b.value = b.value<<32 | uint64(binary.LittleEndian.Uint32(b.in[b.off-4:]))
+		b.bitsRead -= 32
+		b.off -= 4
+		return
+	}
+	for b.off > 0 && b.bitsRead >= 8 {
+		b.value = b.value<<8 | uint64(b.in[b.off-1])
+		b.bitsRead -= 8
+		b.off--
+	}
+}
+
+// peek returns the next n (<= 32) bits without consuming them.
+func (b *bitReaderRev) peek(n uint8) uint32 {
+	if b.bitsRead+uint(n) > 64 {
+		b.fill()
+	}
+	if n == 0 {
+		return 0
+	}
+	return uint32((b.value << b.bitsRead) >> (64 - n))
+}
+
+func (b *bitReaderRev) getBits(n uint8) uint32 {
+	v := b.peek(n)
+	b.bitsRead += uint(n)
+	return v
+}
+
+// getBitsFast consumes n bits without refilling; callers must have called
+// fill so that at least n bits are loaded.
+func (b *bitReaderRev) getBitsFast(n uint8) uint32 {
+	v := (b.value << b.bitsRead) >> (64 - n)
+	b.bitsRead += uint(n)
+	return uint32(v)
+}
+
+func (b *bitReaderRev) overflow() bool {
+	return b.off == 0 && b.bitsRead > 64
+}
+
+func (b *bitReaderRev) finished() bool {
+	return b.off == 0 && b.bitsRead == 64
+}
+
+// -----------------------------------------------------------------------------
+// XXH64 (seed 0) for frame content checksums.
+// -----------------------------------------------------------------------------
+
+const (
+	xxhPrime1 uint64 = 11400714785074694791
+	xxhPrime2 uint64 = 14029467366897019727
+	xxhPrime3 uint64 = 1609587929392839161
+	xxhPrime4 uint64 = 9650029242287828579
+	xxhPrime5 uint64 = 2870177450012600261
+)
+
+type xxh64 struct {
+	v     [4]uint64
+	total uint64
+	mem   [32]byte
+	n     int
+}
+
+func (x *xxh64) reset() {
+	p1 := xxhPrime1 // non-constant so the seed arithmetic wraps
+	x.v = [4]uint64{p1 + xxhPrime2, xxhPrime2, 0, -p1}
+	x.total = 0
+	x.n = 0
+}
+
+func xxhRound(acc, in uint64) uint64 {
+	acc += in * xxhPrime2
+	return bits.RotateLeft64(acc, 31) * xxhPrime1
+}
+
+func xxhMerge(acc, v uint64) uint64 {
+	acc ^= xxhRound(0, v)
+	return acc*xxhPrime1 + xxhPrime4
+}
+
+func (x *xxh64) stripes(p []byte) []byte {
+	for len(p) >= 32 {
+		x.v[0] = xxhRound(x.v[0], binary.LittleEndian.Uint64(p[0:]))
+		x.v[1] = xxhRound(x.v[1], binary.LittleEndian.Uint64(p[8:]))
+		x.v[2] = xxhRound(x.v[2], binary.LittleEndian.Uint64(p[16:]))
+		x.v[3] = xxhRound(x.v[3], binary.LittleEndian.Uint64(p[24:]))
+		p = p[32:]
+	}
+	return p
+}
+
+func (x *xxh64) write(p []byte) {
+	x.total += uint64(len(p))
+	if x.n > 0 {
+		k := copy(x.mem[x.n:], p)
+		x.n += k
+		p = p[k:]
+		if x.n < 32 {
+			return
+		}
+		x.stripes(x.mem[:])
+		x.n = 0
+	}
+	p = x.stripes(p)
+	x.n = copy(x.mem[:], p)
+}
+
+// Write makes xxh64 an io.Writer; it never fails.
+func (x *xxh64) Write(p []byte) (int, error) {
+	x.write(p)
+	return len(p), nil
+}
+
+func (x *xxh64) Sum64() uint64 {
+	var h uint64
+	if x.total >= 32 {
+		h = bits.RotateLeft64(x.v[0], 1) + bits.RotateLeft64(x.v[1], 7) +
+			bits.RotateLeft64(x.v[2], 12) + bits.RotateLeft64(x.v[3], 18)
+		for _, v := range x.v {
+			h = xxhMerge(h, v)
+		}
+	} else {
+		h = x.v[2] + xxhPrime5
+	}
+	h += x.total
+
+	p := x.mem[:x.n]
+	for ; len(p) >= 8; p = p[8:] {
+		h ^= xxhRound(0, binary.LittleEndian.Uint64(p))
+		h = bits.RotateLeft64(h, 27)*xxhPrime1 + xxhPrime4
+	}
+	if len(p) >= 4 {
+		h ^= uint64(binary.LittleEndian.Uint32(p)) * xxhPrime1
+		h = bits.RotateLeft64(h, 23)*xxhPrime2 + xxhPrime3
+		p = p[4:]
+	}
+	for _, b := range p {
+		h ^= uint64(b) * xxhPrime5
+		h = bits.RotateLeft64(h, 11) * xxhPrime1
+	}
+
+	h ^= h >> 33
+	h *= xxhPrime2
+	h ^= h >> 29
+	h *= xxhPrime3
+	h ^= h >> 32
+	return h
+}
+
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"testing"
+)
+
+// smallFrame is `zstd -19 --check` of smallText: one compressed block with
+// raw literals and predefined FSE tables.
+const (
+	smallText  = "key=alpha;key=beta;key=gamma;key=alpha;val=beta;key=gamma;key=alpha;key=beta;\n"
+	smallFrame = "28b52ffd244e450100d86b65793d616c7068613b6b65793d62657467616d6d613b" +
+		"76616c0a04005d6d00ac01d42e399425d33b08fb"
+)
+
+// tradeCSV is the input of the testdata frames, made with
+//
+//	zstd -19 --check trades<n>.csv -o testdata/trades<n>.csv.zst
+//
+// Rows repeat with the given period, so later blocks reuse offsets, Huffman
+// tables (treeless literals) and FSE tables (repeat and RLE modes).
+func tradeCSV(n, period int) []byte {
+	var b bytes.Buffer
+	for i := range n {
+		fmt.Fprintf(&b, "%d,ESZ4,%d.%02d,%d\n",
+			1730757600000000000+uint64(i%period)*37013, 5000+(i*7)%23, (i*3)%4*25, 1+(i*13)%9)
+	}
+	return b.Bytes()
+}
+
+func mustHex(t testing.TB, s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func readTestdata(t testing.TB, name string) []byte {
+	b, err := os.ReadFile("testdata/" + name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// zstdBlock is one block of a hand-built frame: raw, or RLE of rle repeated
+// n times.
+type zstdBlock struct {
+	raw []byte
+	rle byte
+	n   int
+}
+
+// buildZstdFrame assembles a frame with a 128 KiB window and no content
+// size, optionally followed by the XXH64 checksum of its content.
+func buildZstdFrame(blocks []zstdBlock, checksum bool) []byte {
+	var fhd byte
+	if checksum {
+		fhd |= 1 << 2
+	}
+	out := binary.LittleEndian.AppendUint32(nil, zstdFrameMagic)
+	out = append(out, fhd, 7<<3) // window 1<<(10+7)
+
+	var h xxh64
+	h.reset()
+	for i, b := range blocks {
+		last := uint32(0)
+		if i == len(blocks)-1 {
+			last = 1
+		}
+		if b.raw != nil || b.n == 0 {
+			hdr := last | uint32(len(b.raw))<<3
+			out = append(out, byte(hdr), byte(hdr>>8), byte(hdr>>16))
+			out = append(out, b.raw...)
+			h.Write(b.raw)
+			continue
+		}
+		hdr := last | 1<<1 | uint32(b.n)<<3
+		out = append(out, byte(hdr), byte(hdr>>8), byte(hdr>>16), b.rle)
+		h.Write(bytes.Repeat([]byte{b.rle}, b.n))
+	}
+	if checksum {
+		out = binary.LittleEndian.AppendUint32(out, uint32(h.Sum64()))
+	}
+	return out
+}
+
+// rawZstdFrame stores p in raw blocks of at most zstdMaxBlockSize.
+func rawZstdFrame(p []byte) []byte {
+	blocks := []zstdBlock{{raw: []byte{}}}
+	if len(p) > 0 {
+		blocks = blocks[:0]
+	}
+	for len(p) > 0 {
+		n := min(len(p), zstdMaxBlockSize)
+		blocks = append(blocks, zstdBlock{raw: p[:n]})
+		p = p[n:]
+	}
+	return buildZstdFrame(blocks, true)
+}
+
+func skippableFrame(payload string) []byte {
+	out := binary.LittleEndian.AppendUint32(nil, zstdSkippableMagic|0x7)
+	out = binary.LittleEndian.AppendUint32(out, uint32(len(payload)))
+	return append(out, payload...)
+}
+
+func decodeZstd(frame []byte) ([]byte, error) {
+	return io.ReadAll(NewZstdReader(bytes.NewReader(frame)))
+}
+
+func TestZstdKnownFrames(t *testing.T) {
+	tests := []struct {
+		name  string
+		frame []byte
+		want  []byte
+	}{
+		{"raw literals", mustHex(t, smallFrame), []byte(smallText)},
+		{"multi-block", readTestdata(t, "trades2000.csv.zst"), tradeCSV(2000, 20)},
+		{"repeat tables", readTestdata(t, "trades4000.csv.zst"), tradeCSV(4000, 50)},
+	}
+	for _, tt := range tests {
+		got, err := decodeZstd(tt.frame)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !bytes.Equal(got, tt.want) {
+			t.Errorf("%s: decoded %d bytes, want %d", tt.name, len(got), len(tt.want))
+		}
+	}
+}
+
+func TestZstdRawAndRLEBlocks(t *testing.T) {
+	frame := buildZstdFrame([]zstdBlock{
+		{raw: []byte("head,")},
+		{rle: 'x', n: 1000},
+		{raw: []byte(",tail")},
+	}, true)
+	want := "head," + strings.Repeat("x", 1000) + ",tail"
+
+	got, err := decodeZstd(frame)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Fatalf("got %q..., want %q...", got[:min(len(got), 20)], want[:20])
+	}
+}
+
+func TestZstdConcatenatedAndSkippableFrames(t *testing.T) {
+	var stream []byte
+	stream = append(stream, skippableFrame("leading")...)
+	stream = append(stream, mustHex(t, smallFrame)...)
+	stream = append(stream, skippableFrame("")...)
+	stream = append(stream, rawZstdFrame([]byte("second frame\n"))...)
+	stream = append(stream, skippableFrame("trailing")...)
+
+	got, err := decodeZstd(stream)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := smallText + "second frame\n"; string(got) != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+}
+
+func TestZstdChecksumMismatch(t *testing.T) {
+	for _, frame := range [][]byte{mustHex(t, smallFrame), rawZstdFrame([]byte("payload"))} {
+		frame[len(frame)-1] ^= 0xFF
+		if _, err := decodeZstd(frame); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("corrupt checksum: err = %v", err)
+		}
+	}
+}
+
+func TestZstdTruncated(t *testing.T) {
+	frames := map[string][]byte{
+		"small":       mustHex(t, smallFrame),
+		"multi-block": readTestdata(t, "trades2000.csv.zst"),
+		"raw":         rawZstdFrame([]byte("payload")),
+	}
+	for name, frame := range frames {
+		for n := 1; n < len(frame); n++ {
+			if _, err := decodeZstd(frame[:n]); err == nil {
+				t.Errorf("%s cut at %d of %d bytes: no error", name, n, len(frame))
+			}
+		}
+	}
+	if got, err := decodeZstd(nil); err != nil || len(got) != 0 {
+		t.Errorf("empty stream: %d bytes, %v", len(got), err)
+	}
+}
+
+// FuzzZstdRoundTrip checks that any payload stored in raw blocks decodes
+// back to itself.
+func FuzzZstdRoundTrip(f *testing.F) {
+	f.Add([]byte{})
+	f.Add([]byte(smallText))
+	f.Add(tradeCSV(100, 20))
+	f.Fuzz(func(t *testing.T, p []byte) {
+		got, err := decodeZstd(rawZstdFrame(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, p) {
+			t.Fatalf("round trip of %d bytes gave %d", len(p), len(got))
+		}
+	})
+}
+
+// FuzzZstdReader feeds arbitrary input to the decoder, which must fail
+// cleanly rather than panic.
+func FuzzZstdReader(f *testing.F) {
+	f.Add(mustHex(f, smallFrame))
+	f.Add(readTestdata(f, "trades2000.csv.zst"))
+	f.Add(readTestdata(f, "trades4000.csv.zst"))
+	f.Add(buildZstdFrame([]zstdBlock{{raw: []byte("a")}, {rle: 'b', n: 3}}, true))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		io.Copy(io.Discard, io.LimitReader(NewZstdReader(bytes.NewReader(data)), 1<<24))
+	})
+}